@@ -0,0 +1,93 @@
+package cuckoo
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// AnalyzeRequest describes what Analyze should submit.  Set either File or URL.
+type AnalyzeRequest struct {
+	// Filename and File to submit through TasksCreateFile
+	Filename string
+	File     io.Reader
+	// URL to submit through TasksCreateURL
+	URL string
+
+	// Options for the created task, may be nil
+	Options *TaskOptions
+	// Options for waiting on the task, may be nil
+	Wait *WaitOptions
+}
+
+// Analysis is the result of Analyze.
+//
+// The task and report are fetched up front, the other artifacts are only downloaded when asked for.
+type Analysis struct {
+	Task   *Task
+	Report *Report
+
+	client *Client
+}
+
+// Analyze Submits a file or URL, waits for it to be reported and fetches the report.
+//
+// The overall deadline is taken from ctx, so it should usually have a timeout well above the analysis timeout.
+// If the wait fails after the task was created the error is returned along with whatever Analysis is known
+// so the caller can still find the task.
+func (c *Client) Analyze(ctx context.Context, request *AnalyzeRequest) (*Analysis, error) {
+	var (
+		taskID int
+		err    error
+	)
+	switch {
+	case request.File != nil && request.URL != "":
+		return nil, fmt.Errorf("only one of file or url can be analyzed")
+	case request.File != nil:
+		taskID, err = c.TasksCreateFile(ctx, request.Filename, request.File, request.Options)
+	case request.URL != "":
+		taskID, err = c.TasksCreateURL(ctx, request.URL, request.Options)
+	default:
+		return nil, fmt.Errorf("nothing to analyze")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{Task: &Task{ID: taskID}, client: c}
+
+	task, err := c.WaitForTask(ctx, taskID, StatusReported, request.Wait)
+	if task != nil {
+		analysis.Task = task
+	}
+	if err != nil {
+		return analysis, fmt.Errorf("error waiting for task %d: %w", taskID, err)
+	}
+
+	analysis.Report, err = c.TasksReportParsed(ctx, taskID)
+	if err != nil {
+		return analysis, fmt.Errorf("error getting report for task %d: %w", taskID, err)
+	}
+
+	return analysis, nil
+}
+
+// Pcap Returns the PCAP of the analysis, see PcapGet
+func (a *Analysis) Pcap(ctx context.Context) (io.ReadCloser, error) {
+	return a.client.PcapGet(ctx, a.Task.ID)
+}
+
+// Screenshots Returns the ZIP data of all screenshots of the analysis, see TasksScreenshots
+func (a *Analysis) Screenshots(ctx context.Context) (io.ReadCloser, error) {
+	return a.client.TasksScreenshots(ctx, a.Task.ID, -1)
+}
+
+// MemoryDumps Returns the names of the memory dumps of the analysis, see MemoryList
+func (a *Analysis) MemoryDumps(ctx context.Context) ([]string, error) {
+	return a.client.MemoryList(ctx, a.Task.ID)
+}
+
+// MemoryDump Returns the memory dump of one process of the analysis, see MemoryGet
+func (a *Analysis) MemoryDump(ctx context.Context, pID int) (io.ReadCloser, error) {
+	return a.client.MemoryGet(ctx, a.Task.ID, pID)
+}
@@ -0,0 +1,67 @@
+package cuckoo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyze(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/create/url":
+			w.Write([]byte(`{"task_id": 5}`))
+		case "/tasks/view/5":
+			w.Write([]byte(`{"task": {"id": 5, "category": "url", "status": "reported"}}`))
+		case "/tasks/report/5/json":
+			w.Write([]byte(`{"info": {"id": 5, "score": 4.2}}`))
+		case "/pcap/get/5":
+			w.Write([]byte("pcap data"))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			w.WriteHeader(404)
+		}
+	})
+
+	analysis, err := c.Analyze(context.Background(), &AnalyzeRequest{
+		URL:  "http://example.com",
+		Wait: &WaitOptions{PollInterval: time.Millisecond},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if analysis.Task.ID != 5 || analysis.Task.Status != StatusReported {
+		t.Errorf("unexpected task %+v", analysis.Task)
+	}
+	if analysis.Report.Info == nil || analysis.Report.Info.Score != 4.2 {
+		t.Errorf("report was not decoded")
+	}
+
+	pcap, err := analysis.Pcap(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pcap.Close()
+	data, _ := ioutil.ReadAll(pcap)
+	if string(data) != "pcap data" {
+		t.Errorf("unexpected pcap %q", data)
+	}
+}
+
+func TestAnalyzeInvalid(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("nothing should be sent")
+	})
+
+	if _, err := c.Analyze(context.Background(), &AnalyzeRequest{}); err == nil {
+		t.Errorf("expected an error for an empty request")
+	}
+	if _, err := c.Analyze(context.Background(), &AnalyzeRequest{URL: "http://example.com", File: strings.NewReader("MZ")}); err == nil {
+		t.Errorf("expected an error when both a file and url are given")
+	}
+}
@@ -0,0 +1,112 @@
+package cuckoo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+)
+
+// IsArchive returns true if the format is served as a tarball
+func (f ReportFormat) IsArchive() bool {
+	switch f {
+	case ReportAll, ReportDropped, ReportPackageFiles:
+		return true
+	}
+	return false
+}
+
+// ReportArchive reads the tarball of an archive report format entry by entry, like tar.Reader
+type ReportArchive struct {
+	body   io.ReadCloser
+	closer io.Closer
+	tar    *tar.Reader
+}
+
+// NewReportArchive Reads a report tarball.  It can be plain, gzip or bzip2 compressed.
+func NewReportArchive(body io.ReadCloser) (*ReportArchive, error) {
+	buffered := bufio.NewReader(body)
+	magic, err := buffered.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	archive := &ReportArchive{body: body}
+	var r io.Reader = buffered
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("cuckoo: bad gzip archive: %w", err)
+		}
+		archive.closer = gz
+		r = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(buffered)
+	}
+	archive.tar = tar.NewReader(r)
+
+	return archive, nil
+}
+
+// TasksReportArchive Downloads an archive report format (all, dropped or package_files) of the specified task ID.
+//
+// The returned archive must be closed once done.
+func (c *Client) TasksReportArchive(ctx context.Context, taskID int, format ReportFormat) (*ReportArchive, error) {
+	if !format.IsArchive() {
+		return nil, fmt.Errorf("report format %s is not an archive", format)
+	}
+
+	body, err := c.TasksReportFormat(ctx, taskID, format)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := NewReportArchive(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Next advances to the next entry of the archive.  It returns io.EOF at the end.
+func (a *ReportArchive) Next() (*tar.Header, error) {
+	return a.tar.Next()
+}
+
+// Read reads from the current entry of the archive
+func (a *ReportArchive) Read(p []byte) (int, error) {
+	return a.tar.Read(p)
+}
+
+// Walk calls fn for every regular file in the archive.  Returning an error from fn stops the walk.
+func (a *ReportArchive) Walk(fn func(header *tar.Header, r io.Reader) error) error {
+	for {
+		header, err := a.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if err := fn(header, a.tar); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the underlying response
+func (a *ReportArchive) Close() error {
+	if a.closer != nil {
+		a.closer.Close()
+	}
+	return a.body.Close()
+}
@@ -0,0 +1,82 @@
+package cuckoo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func testTarball(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "files", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestTasksReportArchive(t *testing.T) {
+	tarball := testTarball(t, map[string]string{"files/abc/updater.exe": "MZ updater"})
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/report/42/dropped" || r.URL.Query().Get("tar") != "gz" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write(tarball)
+	})
+
+	archive, err := c.TasksReportArchive(context.Background(), 42, ReportDropped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	files := map[string]string{}
+	err = archive.Walk(func(header *tar.Header, r io.Reader) error {
+		content, err := ioutil.ReadAll(r)
+		files[header.Name] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files["files/abc/updater.exe"] != "MZ updater" {
+		t.Errorf("unexpected files %v", files)
+	}
+
+	if _, err := c.TasksReportArchive(context.Background(), 42, ReportHTML); err == nil {
+		t.Errorf("expected an error for a non archive format")
+	}
+}
+
+func TestTasksReportFormat(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/report/42/html":
+			w.Write([]byte("<html></html>"))
+		default:
+			w.WriteHeader(400)
+		}
+	})
+
+	report, err := c.TasksReportFormat(context.Background(), 42, ReportHTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.Close()
+
+	if _, err := c.TasksReportFormat(context.Background(), 42, ReportFormat("pdf")); err == nil || err.Error() != "invalid report format" {
+		t.Errorf("expected invalid report format, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+// Package attack maps the ATT&CK techniques of cuckoo signatures to tactics and exports them as ATT&CK Navigator layers
+package attack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// Summary is the ATT&CK techniques seen in one or more tasks, grouped by tactic
+type Summary struct {
+	// IDs of the summarized tasks
+	Tasks   []int     `json:"tasks"`
+	Tactics []*Tactic `json:"tactics"`
+}
+
+// Tactic is an ATT&CK tactic and the techniques seen for it
+type Tactic struct {
+	Name       string       `json:"name"`
+	Techniques []*Technique `json:"techniques"`
+}
+
+// Technique is an ATT&CK technique and the signatures that matched it
+type Technique struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// IDs of the tasks the technique was seen in
+	Tasks    []int       `json:"tasks"`
+	Evidence []*Evidence `json:"evidence"`
+}
+
+// Evidence is a signature that matched a technique in a task
+type Evidence struct {
+	TaskID      int            `json:"task_id"`
+	Signature   string         `json:"signature"`
+	Description string         `json:"description,omitempty"`
+	Severity    int            `json:"severity"`
+	Marks       []*cuckoo.Mark `json:"marks,omitempty"`
+}
+
+// Options control the mapping
+type Options struct {
+	// Tactics of techniques missing from the built in table, or overriding it, keyed by technique ID
+	Tactics map[string][]string
+	// Drop the marks of the evidence, they can be large
+	NoMarks bool
+}
+
+// Aggregator builds a summary over any number of tasks
+type Aggregator struct {
+	opts       *Options
+	tasks      []int
+	seen       map[int]bool
+	techniques map[string]*Technique
+	// Tasks and signatures already recorded per technique, so adding a task again changes nothing
+	techniqueTasks map[techniqueTask]bool
+	evidence       map[evidenceKey]bool
+}
+
+type techniqueTask struct {
+	technique string
+	task      int
+}
+
+type evidenceKey struct {
+	techniqueTask
+	signature string
+}
+
+// NewAggregator Returns an empty aggregator, opts may be nil
+func NewAggregator(opts *Options) *Aggregator {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &Aggregator{
+		opts:           opts,
+		seen:           map[int]bool{},
+		techniques:     map[string]*Technique{},
+		techniqueTasks: map[techniqueTask]bool{},
+		evidence:       map[evidenceKey]bool{},
+	}
+}
+
+// Add Adds the techniques of the signatures of a task.  Adding a task again only adds the signatures it did not
+// have yet.
+func (a *Aggregator) Add(taskID int, signatures []*cuckoo.Signature) {
+	if !a.seen[taskID] {
+		a.seen[taskID] = true
+		a.tasks = append(a.tasks, taskID)
+	}
+
+	for _, signature := range signatures {
+		for id, ttp := range signature.TTP {
+			technique, ok := a.techniques[id]
+			if !ok {
+				technique = &Technique{ID: id, Tasks: []int{}, Evidence: []*Evidence{}}
+				a.techniques[id] = technique
+			}
+			if technique.Name == "" && ttp != nil {
+				technique.Name = ttp.Short
+			}
+			key := evidenceKey{techniqueTask{id, taskID}, signature.Name}
+			if !a.techniqueTasks[key.techniqueTask] {
+				a.techniqueTasks[key.techniqueTask] = true
+				technique.Tasks = append(technique.Tasks, taskID)
+			}
+			if a.evidence[key] {
+				continue
+			}
+			a.evidence[key] = true
+
+			evidence := &Evidence{
+				TaskID:      taskID,
+				Signature:   signature.Name,
+				Description: signature.Description,
+				Severity:    signature.Severity,
+			}
+			if !a.opts.NoMarks {
+				evidence.Marks = signature.Marks
+			}
+			technique.Evidence = append(technique.Evidence, evidence)
+		}
+	}
+}
+
+// AddReport Adds the signatures of a report, see Add
+func (a *Aggregator) AddReport(report *cuckoo.Report) {
+	taskID := 0
+	if report.Info != nil {
+		taskID = report.Info.ID
+	}
+	a.Add(taskID, report.Signatures)
+}
+
+// Summary Returns the summary of the tasks added so far.
+//
+// Tactics are in kill chain order and techniques are sorted by ID.  A technique with several tactics is listed
+// under each of them, and techniques whose tactic is not known are listed under TacticUnknown.
+func (a *Aggregator) Summary() *Summary {
+	byTactic := map[string][]*Technique{}
+	for id, technique := range a.techniques {
+		for _, tactic := range tacticsOf(id, a.opts.Tactics) {
+			byTactic[tactic] = append(byTactic[tactic], technique)
+		}
+	}
+
+	summary := &Summary{Tasks: append([]int{}, a.tasks...), Tactics: []*Tactic{}}
+	sort.Ints(summary.Tasks)
+	for _, name := range append(append([]string{}, Tactics...), TacticUnknown) {
+		techniques, ok := byTactic[name]
+		if !ok {
+			continue
+		}
+		sort.Slice(techniques, func(i, j int) bool { return techniques[i].ID < techniques[j].ID })
+		summary.Tactics = append(summary.Tactics, &Tactic{Name: name, Techniques: techniques})
+	}
+	return summary
+}
+
+// Summarize Returns the ATT&CK summary of a single report
+func Summarize(report *cuckoo.Report, opts *Options) *Summary {
+	a := NewAggregator(opts)
+	a.AddReport(report)
+	return a.Summary()
+}
+
+// SummarizeTask Returns the ATT&CK summary of the specified task ID.  Only the signatures of the report are decoded.
+func SummarizeTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*Summary, error) {
+	a := NewAggregator(opts)
+	if err := addTask(ctx, c, a, taskID); err != nil {
+		return nil, err
+	}
+	return a.Summary(), nil
+}
+
+// SummarizeAllTasks Returns the ATT&CK summary aggregated over every reported task on cuckoo, see
+// cuckoo.Client.ListAllTasks.
+//
+// include (optional) selects the tasks to summarize, e.g. by date or tags.
+func SummarizeAllTasks(ctx context.Context, c *cuckoo.Client, include func(task *cuckoo.Task) bool, opts *Options) (*Summary, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tasksChan := make(chan *cuckoo.Task)
+	listErr := make(chan error, 1)
+	go func() {
+		listErr <- c.ListAllTasks(ctx, tasksChan)
+	}()
+
+	a := NewAggregator(opts)
+	for task := range tasksChan {
+		if task.Status != cuckoo.StatusReported || (include != nil && !include(task)) {
+			continue
+		}
+		if err := addTask(ctx, c, a, task.ID); err != nil {
+			// Stop listing and wait for the channel to be closed
+			cancel()
+			for range tasksChan {
+			}
+			<-listErr
+			return nil, fmt.Errorf("error summarizing task %d: %w", task.ID, err)
+		}
+	}
+	if err := <-listErr; err != nil {
+		return nil, err
+	}
+
+	return a.Summary(), nil
+}
+
+// addTask adds the signatures of a task to the aggregator
+func addTask(ctx context.Context, c *cuckoo.Client, a *Aggregator, taskID int) error {
+	signatures := []*cuckoo.Signature{}
+	err := c.TasksReportWalk(ctx, taskID, map[string]cuckoo.SectionHandler{
+		"signatures": func(path string, dec *json.Decoder) error {
+			if err := dec.Decode(&signatures); err != nil {
+				return err
+			}
+			return cuckoo.ErrStopWalk
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	a.Add(taskID, signatures)
+	return nil
+}
+
+// Technique Returns the technique with the given ID, or nil if it was not seen
+func (s *Summary) Technique(id string) *Technique {
+	for _, tactic := range s.Tactics {
+		for _, technique := range tactic.Techniques {
+			if strings.EqualFold(technique.ID, id) {
+				return technique
+			}
+		}
+	}
+	return nil
+}
+
+// maxSeverity returns the highest severity of the evidence of a technique
+func (t *Technique) maxSeverity() int {
+	severity := 0
+	for _, evidence := range t.Evidence {
+		if evidence.Severity > severity {
+			severity = evidence.Severity
+		}
+	}
+	return severity
+}
+
+// signatures returns the distinct names of the signatures that matched the technique
+func (t *Technique) signatures() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, evidence := range t.Evidence {
+		if !seen[evidence.Signature] {
+			seen[evidence.Signature] = true
+			names = append(names, evidence.Signature)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
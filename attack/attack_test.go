@@ -0,0 +1,171 @@
+package attack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+func tacticNames(summary *Summary) []string {
+	names := []string{}
+	for _, tactic := range summary.Tactics {
+		names = append(names, tactic.Name)
+	}
+	return names
+}
+
+func TestSummarize(t *testing.T) {
+	summary := Summarize(testutil.LoadReport(t), nil)
+
+	if !reflect.DeepEqual(summary.Tasks, []int{42}) {
+		t.Errorf("unexpected tasks %v", summary.Tasks)
+	}
+	expected := []string{"persistence", "privilege-escalation", "defense-evasion", "command-and-control"}
+	if !reflect.DeepEqual(tacticNames(summary), expected) {
+		t.Errorf("expected tactics %v, got %v", expected, tacticNames(summary))
+	}
+
+	injection := summary.Technique("T1055")
+	if injection == nil || injection.Name != "Process Injection" || len(injection.Evidence) != 1 {
+		t.Fatalf("unexpected technique %+v", injection)
+	}
+	evidence := injection.Evidence[0]
+	if evidence.TaskID != 42 || evidence.Signature != "injection_createremotethread" || evidence.Severity != 3 || len(evidence.Marks) != 1 {
+		t.Errorf("unexpected evidence %+v", evidence)
+	}
+
+	if summary.Technique("T1071") == nil {
+		t.Errorf("technique from a list of IDs missing")
+	}
+}
+
+func TestSummarizeOptions(t *testing.T) {
+	report := testutil.LoadReport(t)
+	report.Signatures[0].TTP["T9999.001"] = nil
+	report.Signatures[1].TTP["T1547.001"] = &cuckoo.TTP{Short: "Registry Run Keys / Startup Folder"}
+
+	summary := Summarize(report, &Options{NoMarks: true, Tactics: map[string][]string{"T1071": {"exfiltration"}}})
+
+	expected := []string{"persistence", "privilege-escalation", "defense-evasion", "exfiltration", TacticUnknown}
+	if !reflect.DeepEqual(tacticNames(summary), expected) {
+		t.Errorf("expected tactics %v, got %v", expected, tacticNames(summary))
+	}
+	if summary.Technique("T1055").Evidence[0].Marks != nil {
+		t.Errorf("expected marks to be dropped")
+	}
+	// Sub-techniques take the tactics of their parent
+	persistence := summary.Tactics[0]
+	if len(persistence.Techniques) != 2 || persistence.Techniques[1].ID != "T1547.001" {
+		t.Errorf("unexpected persistence techniques %+v", persistence.Techniques)
+	}
+}
+
+func TestAggregatorAddAgain(t *testing.T) {
+	signatures := testutil.LoadReport(t).Signatures
+	a := NewAggregator(nil)
+	a.Add(1, signatures)
+	a.Add(2, signatures)
+	a.Add(1, signatures)
+
+	summary := a.Summary()
+	if !reflect.DeepEqual(summary.Tasks, []int{1, 2}) {
+		t.Errorf("unexpected tasks %v", summary.Tasks)
+	}
+	injection := summary.Technique("T1055")
+	if !reflect.DeepEqual(injection.Tasks, []int{1, 2}) || len(injection.Evidence) != 2 {
+		t.Errorf("unexpected technique %+v", injection)
+	}
+}
+
+func TestLayer(t *testing.T) {
+	layer := Summarize(testutil.LoadReport(t), nil).Layer("task 42")
+
+	if layer.Domain != "enterprise-attack" || layer.Versions.Layer != layerVersion {
+		t.Errorf("unexpected layer %+v", layer)
+	}
+	// T1055 is listed under both of its tactics
+	if len(layer.Techniques) != 4 {
+		t.Fatalf("expected 4 techniques, got %d", len(layer.Techniques))
+	}
+	for _, technique := range layer.Techniques {
+		if technique.TechniqueID == "T1071" {
+			if technique.Tactic != "command-and-control" || technique.Score != 2 || technique.Metadata[0].Value != "network_http" {
+				t.Errorf("unexpected technique %+v", technique)
+			}
+		}
+	}
+	if layer.Gradient.MaxValue != 3 {
+		t.Errorf("expected a max score of 3, got %d", layer.Gradient.MaxValue)
+	}
+
+	data, err := json.Marshal(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded["techniques"].([]interface{})[0].(map[string]interface{})["techniqueID"]; !ok {
+		t.Errorf("techniqueID missing from %s", data)
+	}
+}
+
+func TestSummarizeAllTasks(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/list/10/0":
+			fmt.Fprint(w, `{"tasks": [{"id": 1, "status": "reported"}, {"id": 2, "status": "running"}, {"id": 3, "status": "reported"}, {"id": 4, "status": "reported"}]}`)
+		case "/tasks/list/10/4":
+			fmt.Fprint(w, `{"tasks": []}`)
+		case "/tasks/report/1/json", "/tasks/report/4/json":
+			_, _ = w.Write(data)
+		case "/tasks/report/3/json":
+			fmt.Fprint(w, `{"signatures": [{"name": "network_http", "severity": 2, "ttp": ["T1071"]}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	summary, err := SummarizeAllTasks(context.Background(), c, func(task *cuckoo.Task) bool { return task.ID != 4 }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(summary.Tasks, []int{1, 3}) {
+		t.Errorf("unexpected tasks %v", summary.Tasks)
+	}
+	if tasks := summary.Technique("T1071").Tasks; !reflect.DeepEqual(tasks, []int{1, 3}) {
+		t.Errorf("unexpected T1071 tasks %v", tasks)
+	}
+
+	layer := summary.Layer("all tasks")
+	for _, technique := range layer.Techniques {
+		if technique.TechniqueID == "T1071" && (technique.Score != 2 || technique.Metadata[0].Value != "1,3") {
+			t.Errorf("unexpected technique %+v", technique)
+		}
+	}
+
+	// A failing report stops the aggregation
+	failing := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tasks/list/10/0" {
+			fmt.Fprint(w, `{"tasks": [{"id": 5, "status": "reported"}]}`)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	if _, err := SummarizeAllTasks(context.Background(), failing, nil, nil); err == nil {
+		t.Errorf("expected an error for a missing report")
+	}
+}
@@ -0,0 +1,122 @@
+package attack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Versions written in the layers
+const (
+	layerVersion     = "4.2"
+	navigatorVersion = "4.3"
+	attackVersion    = "8"
+)
+
+// Layer is an ATT&CK Navigator layer
+type Layer struct {
+	Name        string            `json:"name"`
+	Versions    *LayerVersions    `json:"versions"`
+	Domain      string            `json:"domain"`
+	Description string            `json:"description"`
+	Techniques  []*LayerTechnique `json:"techniques"`
+	Gradient    *LayerGradient    `json:"gradient"`
+	Metadata    []*LayerMetadata  `json:"metadata,omitempty"`
+}
+
+// LayerVersions are the versions of the layer format, the Navigator and ATT&CK
+type LayerVersions struct {
+	Attack    string `json:"attack"`
+	Navigator string `json:"navigator"`
+	Layer     string `json:"layer"`
+}
+
+// LayerTechnique is the annotation of a technique in a layer
+type LayerTechnique struct {
+	TechniqueID string           `json:"techniqueID"`
+	Tactic      string           `json:"tactic,omitempty"`
+	Score       int              `json:"score"`
+	Comment     string           `json:"comment,omitempty"`
+	Enabled     bool             `json:"enabled"`
+	Metadata    []*LayerMetadata `json:"metadata,omitempty"`
+}
+
+// LayerGradient colors the techniques by score
+type LayerGradient struct {
+	Colors   []string `json:"colors"`
+	MinValue int      `json:"minValue"`
+	MaxValue int      `json:"maxValue"`
+}
+
+// LayerMetadata is a name/value pair shown by the Navigator
+type LayerMetadata struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Layer Returns the summary as an ATT&CK Navigator layer.
+//
+// For a single task techniques are scored with the highest severity of their signatures, for several tasks
+// with the number of tasks they were seen in.  The signatures are listed in the metadata of each technique.
+func (s *Summary) Layer(name string) *Layer {
+	aggregated := len(s.Tasks) > 1
+	layer := &Layer{
+		Name:        name,
+		Versions:    &LayerVersions{Attack: attackVersion, Navigator: navigatorVersion, Layer: layerVersion},
+		Domain:      "enterprise-attack",
+		Description: s.description(),
+		Techniques:  []*LayerTechnique{},
+		Gradient:    &LayerGradient{Colors: []string{"#ffe766", "#ff6666"}, MinValue: 0},
+	}
+	if len(s.Tasks) == 1 {
+		layer.Metadata = []*LayerMetadata{{Name: "task", Value: strconv.Itoa(s.Tasks[0])}}
+	}
+
+	for _, tactic := range s.Tactics {
+		for _, technique := range tactic.Techniques {
+			annotation := &LayerTechnique{
+				TechniqueID: technique.ID,
+				Score:       technique.maxSeverity(),
+				Comment:     technique.Name,
+				Enabled:     true,
+				Metadata:    []*LayerMetadata{},
+			}
+			if tactic.Name != TacticUnknown {
+				annotation.Tactic = tactic.Name
+			}
+			if aggregated {
+				annotation.Score = len(technique.Tasks)
+				annotation.Metadata = append(annotation.Metadata, &LayerMetadata{Name: "tasks", Value: joinInts(technique.Tasks)})
+			}
+			for _, signature := range technique.signatures() {
+				annotation.Metadata = append(annotation.Metadata, &LayerMetadata{Name: "signature", Value: signature})
+			}
+			if annotation.Score > layer.Gradient.MaxValue {
+				layer.Gradient.MaxValue = annotation.Score
+			}
+			layer.Techniques = append(layer.Techniques, annotation)
+		}
+	}
+
+	return layer
+}
+
+// description describes the tasks the summary covers
+func (s *Summary) description() string {
+	switch len(s.Tasks) {
+	case 0:
+		return "No cuckoo tasks"
+	case 1:
+		return fmt.Sprintf("Techniques seen in cuckoo task %d", s.Tasks[0])
+	default:
+		return fmt.Sprintf("Techniques seen in %d cuckoo tasks", len(s.Tasks))
+	}
+}
+
+func joinInts(values []int) string {
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		strs = append(strs, strconv.Itoa(value))
+	}
+	return strings.Join(strs, ",")
+}
@@ -0,0 +1,112 @@
+package attack
+
+import "strings"
+
+// Tactics of the enterprise ATT&CK matrix, in kill chain order, as named by ATT&CK Navigator
+var Tactics = []string{
+	"reconnaissance",
+	"resource-development",
+	"initial-access",
+	"execution",
+	"persistence",
+	"privilege-escalation",
+	"defense-evasion",
+	"credential-access",
+	"discovery",
+	"lateral-movement",
+	"collection",
+	"command-and-control",
+	"exfiltration",
+	"impact",
+}
+
+// TacticUnknown groups the techniques whose tactics are not known
+const TacticUnknown = "unknown"
+
+// techniqueTactics maps the techniques referenced by the cuckoo community signatures to their tactics.  Cuckoo
+// still uses the technique IDs from before sub-techniques, so some of these are deprecated in ATT&CK today.
+var techniqueTactics = map[string][]string{
+	"T1002": {"exfiltration"},
+	"T1003": {"credential-access"},
+	"T1005": {"collection"},
+	"T1007": {"discovery"},
+	"T1008": {"command-and-control"},
+	"T1010": {"discovery"},
+	"T1012": {"discovery"},
+	"T1014": {"defense-evasion"},
+	"T1016": {"discovery"},
+	"T1018": {"discovery"},
+	"T1022": {"exfiltration"},
+	"T1027": {"defense-evasion"},
+	"T1031": {"persistence"},
+	"T1033": {"discovery"},
+	"T1036": {"defense-evasion"},
+	"T1041": {"exfiltration"},
+	"T1043": {"command-and-control"},
+	"T1045": {"defense-evasion"},
+	"T1047": {"execution"},
+	"T1048": {"exfiltration"},
+	"T1050": {"persistence", "privilege-escalation"},
+	"T1053": {"execution", "persistence", "privilege-escalation"},
+	"T1055": {"defense-evasion", "privilege-escalation"},
+	"T1056": {"collection", "credential-access"},
+	"T1057": {"discovery"},
+	"T1059": {"execution"},
+	"T1060": {"persistence"},
+	"T1063": {"discovery"},
+	"T1064": {"defense-evasion", "execution"},
+	"T1065": {"command-and-control"},
+	"T1070": {"defense-evasion"},
+	"T1071": {"command-and-control"},
+	"T1074": {"collection"},
+	"T1076": {"lateral-movement"},
+	"T1082": {"discovery"},
+	"T1083": {"discovery"},
+	"T1085": {"defense-evasion", "execution"},
+	"T1086": {"execution"},
+	"T1088": {"defense-evasion", "privilege-escalation"},
+	"T1089": {"defense-evasion"},
+	"T1090": {"command-and-control", "defense-evasion"},
+	"T1093": {"defense-evasion"},
+	"T1094": {"command-and-control"},
+	"T1095": {"command-and-control"},
+	"T1102": {"command-and-control", "defense-evasion"},
+	"T1105": {"command-and-control", "lateral-movement"},
+	"T1106": {"execution"},
+	"T1107": {"defense-evasion"},
+	"T1112": {"defense-evasion"},
+	"T1113": {"collection"},
+	"T1114": {"collection"},
+	"T1115": {"collection"},
+	"T1120": {"discovery"},
+	"T1124": {"discovery"},
+	"T1130": {"defense-evasion"},
+	"T1132": {"command-and-control"},
+	"T1134": {"defense-evasion", "privilege-escalation"},
+	"T1140": {"defense-evasion"},
+	"T1143": {"defense-evasion"},
+	"T1158": {"defense-evasion", "persistence"},
+	"T1179": {"collection", "credential-access", "persistence", "privilege-escalation"},
+	"T1204": {"execution"},
+	"T1219": {"command-and-control"},
+	"T1486": {"impact"},
+	"T1490": {"impact"},
+	"T1497": {"defense-evasion", "discovery"},
+	"T1543": {"persistence", "privilege-escalation"},
+	"T1547": {"persistence", "privilege-escalation"},
+	"T1562": {"defense-evasion"},
+	"T1566": {"initial-access"},
+}
+
+// tacticsOf returns the tactics of a technique, looking up the parent of sub-techniques (T1547.001) as well
+func tacticsOf(id string, extra map[string][]string) []string {
+	for _, candidate := range []string{id, strings.SplitN(id, ".", 2)[0]} {
+		if tactics, ok := extra[candidate]; ok {
+			return tactics
+		}
+		if tactics, ok := techniqueTactics[candidate]; ok {
+			return tactics
+		}
+	}
+	return []string{TacticUnknown}
+}
@@ -0,0 +1,387 @@
+package cuckoo
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheChecksumSuffix = ".sha256"
+	cacheTempPrefix     = ".tmp-"
+)
+
+// Cache is an on-disk cache of the artifacts of finished tasks: reports, PCAPs, screenshots and sample files.
+//
+// Set it on the Config or the Client to have TasksReport, TasksReportFormat, PcapGet, TasksScreenshots and FilesGet
+// served from disk once they have been downloaded.  Entries are written as they are read by the caller and only
+// kept if the whole body was read.  The artifacts of a task are only stored once the task is reported, since they
+// change while it runs; its status is looked up with TasksView the first time one of them is downloaded.  Every
+// entry is checked against its SHA256 before it is served, and a corrupted entry is dropped and downloaded again.
+//
+// The cache is bounded in size, evicting the least recently used entries.  The entries of a task are dropped by
+// TasksReReport and TasksDelete, downloads of the task in flight at that time are not stored.  A Cache is safe for
+// concurrent use, but a directory must not be shared by two caches.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	// reported holds the tasks known to be reported
+	reported map[int]bool
+	// generations counts the invalidations of each task
+	generations map[int]int
+}
+
+// cacheEntry is a committed entry of the cache
+type cacheEntry struct {
+	name   string
+	size   int64
+	sha256 string
+}
+
+// NewCache Opens a cache in dir, creating it if needed, holding at most maxSize bytes.
+//
+// Entries left by a previous cache in dir are kept, ordered by the last time they were used.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("cuckoo: cache size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{dir: dir, maxSize: maxSize, lru: list.New(), entries: map[string]*list.Element{},
+		reported: map[int]bool{}, generations: map[int]int{}}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+// load indexes the entries already in the cache directory
+func (c *Cache) load() error {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		entry *cacheEntry
+		used  time.Time
+	}
+	found := []*loaded{}
+	for _, info := range infos {
+		name := info.Name()
+		path := filepath.Join(c.dir, name)
+		switch {
+		case info.IsDir(), strings.HasSuffix(name, cacheChecksumSuffix):
+			continue
+		case strings.HasPrefix(name, cacheTempPrefix):
+			// Left over by an interrupted download
+			os.Remove(path)
+			continue
+		}
+
+		checksum, err := ioutil.ReadFile(path + cacheChecksumSuffix)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		found = append(found, &loaded{
+			entry: &cacheEntry{name: name, size: info.Size(), sha256: string(bytes.TrimSpace(checksum))},
+			used:  info.ModTime(),
+		})
+	}
+
+	// Most recently used first
+	sort.Slice(found, func(i, j int) bool { return found[i].used.After(found[j].used) })
+	for _, l := range found {
+		c.entries[l.entry.name] = c.lru.PushBack(l.entry)
+		c.size += l.entry.size
+	}
+	return nil
+}
+
+// Size returns the number of bytes held by the cache
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// InvalidateTask Drops every entry of the specified task ID
+func (c *Cache) InvalidateTask(taskID int) {
+	if c == nil {
+		return
+	}
+	prefix := fmt.Sprintf("task-%d-", taskID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.reported, taskID)
+	c.generations[taskID]++
+	for name, element := range c.entries {
+		if strings.HasPrefix(name, prefix) {
+			c.remove(element)
+		}
+	}
+}
+
+// get opens the entry name if it is in the cache and intact
+func (c *Cache) get(name string) (io.ReadCloser, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	element, ok := c.entries[name]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	c.lru.MoveToFront(element)
+	c.mu.Unlock()
+
+	path := filepath.Join(c.dir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		c.drop(name)
+		return nil, false
+	}
+
+	// Verify the whole entry before handing it out, the caller can't undo what it already read
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil || hex.EncodeToString(h.Sum(nil)) != entry.sha256 {
+		f.Close()
+		c.drop(name)
+		return nil, false
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return f, true
+}
+
+// put returns body, writing what is read from it into the entry name.  The entry is committed once body has been
+// read to the end, if expectedSHA256 is set it must match the content.  taskID is the task the entry belongs to, or
+// -1, the entry is not committed if the task is invalidated in the meantime.
+func (c *Cache) put(name string, taskID int, body io.ReadCloser, expectedSHA256 string) io.ReadCloser {
+	if c == nil {
+		return body
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, cacheTempPrefix)
+	if err != nil {
+		return body
+	}
+	c.mu.Lock()
+	generation := c.generations[taskID]
+	c.mu.Unlock()
+	return &cacheWriter{
+		cache:      c,
+		name:       name,
+		task:       taskID,
+		generation: generation,
+		body:       body,
+		tmp:        tmp,
+		hash:       sha256.New(),
+		expected:   expectedSHA256,
+	}
+}
+
+// putTask returns body, writing it into the entry name if the task is reported
+func (c *Client) putTask(ctx context.Context, taskID int, name string, body io.ReadCloser) io.ReadCloser {
+	if c.Cache == nil || !c.taskReported(ctx, taskID) {
+		return body
+	}
+	return c.Cache.put(name, taskID, body, "")
+}
+
+// taskReported returns whether the task is reported, remembering the tasks that are
+func (c *Client) taskReported(ctx context.Context, taskID int) bool {
+	c.Cache.mu.Lock()
+	reported := c.Cache.reported[taskID]
+	c.Cache.mu.Unlock()
+	if reported {
+		return true
+	}
+
+	task, err := c.TasksView(ctx, taskID)
+	if err != nil || task.Status != StatusReported {
+		return false
+	}
+	c.Cache.mu.Lock()
+	c.Cache.reported[taskID] = true
+	c.Cache.mu.Unlock()
+	return true
+}
+
+// commit moves a fully written entry into the cache, unless its task was invalidated since generation
+func (c *Cache) commit(name, tmp string, size int64, sum string, taskID, generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if taskID >= 0 && c.generations[taskID] != generation {
+		os.Remove(tmp)
+		return
+	}
+
+	path := filepath.Join(c.dir, name)
+	if err := ioutil.WriteFile(path+cacheChecksumSuffix, []byte(sum), 0600); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		os.Remove(path + cacheChecksumSuffix)
+		return
+	}
+	// The modification time records the last use, set it like get does since the filesystem may be less precise
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	if element, ok := c.entries[name]; ok {
+		old := element.Value.(*cacheEntry)
+		c.size -= old.size
+		c.lru.Remove(element)
+	}
+	c.entries[name] = c.lru.PushFront(&cacheEntry{name: name, size: size, sha256: sum})
+	c.size += size
+	c.evict()
+}
+
+// drop removes the entry name
+func (c *Cache) drop(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[name]; ok {
+		c.remove(element)
+	}
+}
+
+// remove deletes an entry, c.mu must be held
+func (c *Cache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+
+	path := filepath.Join(c.dir, entry.name)
+	os.Remove(path)
+	os.Remove(path + cacheChecksumSuffix)
+}
+
+// evict removes the least recently used entries until the cache fits, c.mu must be held
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// cacheWriter copies a response body into the cache as it is read
+type cacheWriter struct {
+	cache *Cache
+	name  string
+	task  int
+	// Invalidations of the task when the download started
+	generation int
+	body       io.ReadCloser
+	tmp        *os.File
+	hash       hash.Hash
+	expected   string
+	size       int64
+	failed     bool
+	done       bool
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.body.Read(p)
+	if n > 0 && !w.failed {
+		if _, writeErr := w.tmp.Write(p[:n]); writeErr != nil {
+			w.failed = true
+		}
+		w.hash.Write(p[:n])
+		w.size += int64(n)
+	}
+	if err == io.EOF && !w.done {
+		w.done = true
+		w.finish()
+	}
+	return n, err
+}
+
+// finish commits the entry if it is complete and intact
+func (w *cacheWriter) finish() {
+	closeErr := w.tmp.Close()
+	sum := hex.EncodeToString(w.hash.Sum(nil))
+	if w.failed || closeErr != nil || (w.expected != "" && !strings.EqualFold(w.expected, sum)) {
+		os.Remove(w.tmp.Name())
+		return
+	}
+	w.cache.commit(w.name, w.tmp.Name(), w.size, sum, w.task, w.generation)
+}
+
+// Close closes the response, discarding the entry if it was not read to the end
+func (w *cacheWriter) Close() error {
+	if !w.done {
+		w.done = true
+		w.tmp.Close()
+		os.Remove(w.tmp.Name())
+	}
+	return w.body.Close()
+}
+
+// Names of the cache entries
+
+// reportCacheName returns the entry of a report, or false if the format is not one cuckoo serves
+func reportCacheName(taskID int, format ReportFormat) (string, bool) {
+	switch format {
+	case ReportJSON, ReportHTML, ReportAll, ReportDropped, ReportPackageFiles:
+		return fmt.Sprintf("task-%d-report-%s", taskID, format), true
+	}
+	return "", false
+}
+
+func pcapCacheName(taskID int) string {
+	return fmt.Sprintf("task-%d-pcap", taskID)
+}
+
+func screenshotsCacheName(taskID, screenshotNumber int) string {
+	if screenshotNumber == -1 {
+		return fmt.Sprintf("task-%d-screenshots-all", taskID)
+	}
+	return fmt.Sprintf("task-%d-screenshots-%d", taskID, screenshotNumber)
+}
+
+// fileCacheName returns the entry of a sample, or false if sha256 is not a valid hash
+func fileCacheName(sha256 string) (string, bool) {
+	decoded, err := hex.DecodeString(sha256)
+	if err != nil || len(decoded) != 32 {
+		return "", false
+	}
+	return "file-" + strings.ToLower(sha256), true
+}
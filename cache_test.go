@@ -0,0 +1,290 @@
+package cuckoo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// cachedMockClient returns a client with a cache in dir, counting the requests made for each path.  Tasks are
+// reported unless content holds their view.
+func cachedMockClient(t *testing.T, dir string, maxSize int64, content map[string]string) (*Client, map[string]int) {
+	cache, err := NewCache(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu := &sync.Mutex{}
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/tasks/rereport/"):
+			fmt.Fprint(w, `{"success": true}`)
+		case strings.HasPrefix(r.URL.Path, "/tasks/delete/"):
+			fmt.Fprint(w, `{"status": "OK"}`)
+		case strings.HasPrefix(r.URL.Path, "/tasks/view/") && content[r.URL.Path] == "":
+			fmt.Fprint(w, `{"task": {"status": "reported"}}`)
+		default:
+			body, ok := content[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, body)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return New(&Config{BaseURL: server.URL, Cache: cache}), requests
+}
+
+func readAll(t *testing.T, body io.ReadCloser, err error) string {
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	c, requests := cachedMockClient(t, t.TempDir(), 1024, map[string]string{
+		"/tasks/report/42/json":    `{"info": {"id": 42}}`,
+		"/pcap/get/42":             "pcap data",
+		"/tasks/screenshots/42":    "zip data",
+		"/tasks/screenshots/42/1":  "zip data 1",
+		"/tasks/report/42/dropped": "tarball",
+	})
+
+	for i := 0; i < 3; i++ {
+		body, err := c.TasksReport(ctx, 42)
+		if content := readAll(t, body, err); content != `{"info": {"id": 42}}` {
+			t.Fatalf("unexpected report %s", content)
+		}
+		body, err = c.PcapGet(ctx, 42)
+		if content := readAll(t, body, err); content != "pcap data" {
+			t.Fatalf("unexpected pcap %s", content)
+		}
+		body, err = c.TasksScreenshots(ctx, 42, -1)
+		readAll(t, body, err)
+		body, err = c.TasksScreenshots(ctx, 42, 1)
+		if content := readAll(t, body, err); content != "zip data 1" {
+			t.Fatalf("unexpected screenshot %s", content)
+		}
+	}
+
+	for path, count := range requests {
+		if count != 1 {
+			t.Errorf("%s requested %d times", path, count)
+		}
+	}
+	if size := c.Cache.Size(); size != int64(len(`{"info": {"id": 42}}`)+len("pcap data")+len("zip data")+len("zip data 1")) {
+		t.Errorf("unexpected cache size %d", size)
+	}
+
+	// A body closed before the end is not cached
+	body, err := c.TasksReportFormat(ctx, 42, ReportDropped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Read(make([]byte, 2))
+	body.Close()
+	body, err = c.TasksReportFormat(ctx, 42, ReportDropped)
+	readAll(t, body, err)
+	if requests["/tasks/report/42/dropped"] != 2 {
+		t.Errorf("expected the partially read archive to be downloaded again")
+	}
+}
+
+func TestCacheRunningTask(t *testing.T) {
+	ctx := context.Background()
+	c, requests := cachedMockClient(t, t.TempDir(), 1024, map[string]string{
+		"/tasks/view/42": `{"task": {"id": 42, "status": "running"}}`,
+		"/pcap/get/42":   "partial pcap",
+		"/pcap/get/43":   "pcap",
+	})
+
+	for i := 0; i < 2; i++ {
+		for _, id := range []int{42, 43} {
+			body, err := c.PcapGet(ctx, id)
+			readAll(t, body, err)
+		}
+	}
+	if requests["/pcap/get/42"] != 2 {
+		t.Errorf("the pcap of a running task was cached: %v", requests)
+	}
+	if requests["/pcap/get/43"] != 1 || requests["/tasks/view/43"] != 1 {
+		t.Errorf("unexpected requests for a reported task: %v", requests)
+	}
+	if size := c.Cache.Size(); size != int64(len("pcap")) {
+		t.Errorf("unexpected cache size %d", size)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	c, requests := cachedMockClient(t, t.TempDir(), 1024, map[string]string{
+		"/tasks/report/42/json": "report 42",
+		"/tasks/report/43/json": "report 43",
+		"/pcap/get/42":          "pcap 42",
+	})
+	fetch := func() {
+		for _, id := range []int{42, 43} {
+			body, err := c.TasksReport(ctx, id)
+			readAll(t, body, err)
+		}
+		body, err := c.PcapGet(ctx, 42)
+		readAll(t, body, err)
+	}
+
+	fetch()
+	if err := c.TasksReReport(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	fetch()
+	if requests["/tasks/report/42/json"] != 2 || requests["/pcap/get/42"] != 2 || requests["/tasks/report/43/json"] != 1 {
+		t.Errorf("unexpected requests after rereport %v", requests)
+	}
+
+	if err := c.TasksDelete(ctx, 43); err != nil {
+		t.Fatal(err)
+	}
+	fetch()
+	if requests["/tasks/report/42/json"] != 2 || requests["/tasks/report/43/json"] != 2 {
+		t.Errorf("unexpected requests after delete %v", requests)
+	}
+}
+
+func TestCacheInvalidationInFlight(t *testing.T) {
+	ctx := context.Background()
+	c, requests := cachedMockClient(t, t.TempDir(), 1024, map[string]string{
+		"/tasks/report/42/json": "report before the rereport",
+	})
+
+	// The task is rereported while its report is downloaded
+	body, err := c.TasksReport(ctx, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Read(make([]byte, 2))
+	if err := c.TasksReReport(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, body, nil)
+
+	body, err = c.TasksReport(ctx, 42)
+	readAll(t, body, err)
+	if requests["/tasks/report/42/json"] != 2 {
+		t.Errorf("the report downloaded before the rereport was cached: %v", requests)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c, requests := cachedMockClient(t, dir, 20, map[string]string{
+		"/tasks/report/1/json": "0123456789",
+		"/tasks/report/2/json": "0123456789",
+		"/tasks/report/3/json": "0123456789",
+	})
+	fetch := func(id int) {
+		body, err := c.TasksReport(ctx, id)
+		readAll(t, body, err)
+	}
+
+	fetch(1)
+	fetch(2)
+	fetch(1) // 2 is now the least recently used
+	fetch(3)
+	fetch(1)
+	fetch(2)
+	if requests["/tasks/report/1/json"] != 1 || requests["/tasks/report/2/json"] != 2 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if c.Cache.Size() > 20 {
+		t.Errorf("cache exceeds its size: %d", c.Cache.Size())
+	}
+
+	// Entries survive a restart, the least recently used are evicted first when the cache shrinks
+	reopened, err := NewCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Size() != 10 {
+		t.Errorf("expected a single entry, got %d bytes", reopened.Size())
+	}
+	if _, ok := reopened.get(testReportCacheName(t, 2)); !ok {
+		t.Errorf("expected the most recently used entry to be kept")
+	}
+}
+
+func testReportCacheName(t *testing.T, id int) string {
+	name, ok := reportCacheName(id, ReportJSON)
+	if !ok {
+		t.Fatal("json reports are cacheable")
+	}
+	return name
+}
+
+func TestCacheIntegrity(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	sample := "MZ sample"
+	sum := sha256.Sum256([]byte(sample))
+	hash := hex.EncodeToString(sum[:])
+	wrong := strings.Repeat("0", 64)
+
+	c, requests := cachedMockClient(t, dir, 1024, map[string]string{
+		"/tasks/report/42/json": "report",
+		"/files/get/" + hash:    sample,
+		"/files/get/" + wrong:   sample,
+	})
+
+	for i := 0; i < 2; i++ {
+		body, err := c.FilesGet(ctx, hash)
+		if content := readAll(t, body, err); content != sample {
+			t.Fatalf("unexpected sample %s", content)
+		}
+		body, err = c.FilesGet(ctx, wrong)
+		readAll(t, body, err)
+	}
+	if requests["/files/get/"+hash] != 1 || requests["/files/get/"+wrong] != 2 {
+		t.Errorf("a file not matching its hash was cached: %v", requests)
+	}
+
+	// A corrupted entry is downloaded again
+	body, err := c.TasksReport(ctx, 42)
+	readAll(t, body, err)
+	if err := ioutil.WriteFile(filepath.Join(dir, testReportCacheName(t, 42)), []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	body, err = c.TasksReport(ctx, 42)
+	if content := readAll(t, body, err); content != "report" {
+		t.Errorf("served a corrupted entry: %s", content)
+	}
+	if requests["/tasks/report/42/json"] != 2 {
+		t.Errorf("expected the report to be downloaded again")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, testReportCacheName(t, 42))); err != nil {
+		t.Errorf("expected the report to be cached again: %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+// Package carve carves the PE images found in the process memory dumps of cuckoo analyses.  Images mapped by the
+// loader are rebuilt into files, so unpacked payloads that only live in memory can be analyzed again.
+package carve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/binfile"
+)
+
+const (
+	defaultMaxImageSize = 16 * 1024 * 1024
+	readSize            = 1024 * 1024
+
+	// Each region of a cuckoo dump starts with its address, size, state, type and protection
+	regionHeaderSize = 24
+
+	memCommit  = 0x1000
+	memPrivate = 0x20000
+	memMapped  = 0x40000
+	memImage   = 0x1000000
+)
+
+// Format is the format of a memory dump
+type Format int
+
+// Formats of memory dumps
+const (
+	// FormatAuto detects cuckoo dumps from the header of their first region, anything else is read as raw memory
+	FormatAuto Format = iota
+	// FormatCuckoo is the dump written by the cuckoo monitor, a list of regions each preceded by a header
+	FormatCuckoo
+	// FormatRaw is a flat copy of memory
+	FormatRaw
+)
+
+// Layout is the layout of an image where it was found
+type Layout string
+
+// Layouts of images
+const (
+	// LayoutFile is an image laid out like on disk, e.g. a payload decrypted into a buffer
+	LayoutFile Layout = "file"
+	// LayoutMapped is an image mapped by a loader, each section at its virtual address
+	LayoutMapped Layout = "mapped"
+)
+
+// Image is a PE image carved from a dump
+type Image struct {
+	PID int `json:"pid"`
+	// Address of the image in the process, only known for cuckoo dumps
+	Address uint64 `json:"address,omitempty"`
+	// Offset of the image in the dump
+	Offset int64 `json:"offset"`
+	// Set for images found in memory allocated by the process rather than in a module mapped from a file, only
+	// known for cuckoo dumps
+	Private bool   `json:"private"`
+	Layout  Layout `json:"layout"`
+	// Set when the section table of a mapped image did not describe its sections and the file was rebuilt with each
+	// section at its virtual address
+	Realigned bool `json:"realigned,omitempty"`
+	// Set when the dump ended before the image, the missing bytes are zero
+	Truncated bool `json:"truncated,omitempty"`
+
+	Machine string `json:"machine"`
+	DLL     bool   `json:"dll"`
+	// Size of the carved file
+	Size     int        `json:"size"`
+	MD5      string     `json:"md5"`
+	SHA1     string     `json:"sha1"`
+	SHA256   string     `json:"sha256"`
+	Imphash  string     `json:"imphash,omitempty"`
+	Sections []*Section `json:"sections"`
+
+	// Path of the carved file when Options.Dir is set
+	File string `json:"file,omitempty"`
+	// ID of the task the image was resubmitted as, see Options.Resubmit
+	TaskID int `json:"task_id,omitempty"`
+
+	// The carved file
+	Data []byte `json:"-"`
+}
+
+// Section is a section of a carved image, as laid out in the carved file
+type Section struct {
+	Name            string  `json:"name"`
+	VirtualAddress  uint32  `json:"virtual_address"`
+	VirtualSize     uint32  `json:"virtual_size"`
+	RawOffset       uint32  `json:"raw_offset"`
+	RawSize         uint32  `json:"raw_size"`
+	Characteristics uint32  `json:"characteristics"`
+	Entropy         float64 `json:"entropy"`
+}
+
+// Options configure the carving of a dump
+type Options struct {
+	Format Format
+	// Largest image carved, in bytes, defaults to 16MB.  Up to twice as many bytes of the dump are held in memory.
+	MaxImageSize int
+	// Only carve the images found in private memory, leaving out the modules loaded from files.  Images of raw dumps
+	// are all carved.
+	PrivateOnly bool
+
+	// Optional, directory the images are written into as "<sha256>.bin", read only
+	Dir string
+
+	// Resubmit the carved images as new tasks, only used by CarveTask.  Each image is submitted once, named after
+	// its SHA256 with a .dll or .exe extension for cuckoo to pick the analysis package.
+	Resubmit bool
+	// Options of the resubmitted tasks, may be nil
+	TaskOptions *cuckoo.TaskOptions
+
+	// CarveTask gives up on a download when no data is received for this long, defaults to 2 minutes
+	StallTimeout time.Duration
+}
+
+// Carve Reads the dump of the process pid from r and calls fn for every PE image found in it, in the order of the
+// dump.  Returning an error from fn stops the carving.
+//
+// Candidates are found from their DOS and PE headers and validated with debug/pe.  Whether an image is mapped is
+// told from where the data of its first section is, mapped images are rebuilt into files from their section table.
+// Contiguous regions of cuckoo dumps are joined, so images mapped across several regions are carved whole.
+func Carve(r io.Reader, pid int, opts *Options, fn func(image *Image) error) error {
+	c := &carver{pid: pid, fn: fn}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.MaxImageSize <= 0 {
+		c.opts.MaxImageSize = defaultMaxImageSize
+	}
+
+	br := bufio.NewReaderSize(r, readSize)
+	format := c.opts.Format
+	if format == FormatAuto {
+		format = FormatRaw
+		if header, err := br.Peek(regionHeaderSize); err == nil && isRegionHeader(header) {
+			format = FormatCuckoo
+		}
+	}
+
+	if format == FormatCuckoo {
+		c.cuckoo = true
+		return c.readRegions(br)
+	}
+	return c.readRaw(br)
+}
+
+// CarveAll Carves a dump and returns all of its images, see Carve
+func CarveAll(r io.Reader, pid int, opts *Options) ([]*Image, error) {
+	images := []*Image{}
+	err := Carve(r, pid, opts, func(image *Image) error {
+		images = append(images, image)
+		return nil
+	})
+	return images, err
+}
+
+// CarveTask Downloads the memory dump of the process pid of the specified task ID with MemoryStream and carves it,
+// see Carve.
+//
+// With opts.Resubmit, every image is submitted as a new task before fn is called with it.
+func CarveTask(ctx context.Context, c *cuckoo.Client, taskID, pid int, opts *Options, fn func(image *Image) error) error {
+	var stallTimeout time.Duration
+	if opts != nil {
+		stallTimeout = opts.StallTimeout
+	}
+	body, err := c.MemoryStream(ctx, taskID, pid, stallTimeout)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if opts == nil || !opts.Resubmit {
+		return Carve(body, pid, opts, fn)
+	}
+
+	submitted := map[string]int{}
+	return Carve(body, pid, opts, func(image *Image) error {
+		id, ok := submitted[image.SHA256]
+		if !ok {
+			name := image.SHA256 + ".exe"
+			if image.DLL {
+				name = image.SHA256 + ".dll"
+			}
+			id, err = c.TasksCreateFile(ctx, name, bytes.NewReader(image.Data), opts.TaskOptions)
+			if err != nil {
+				return fmt.Errorf("error resubmitting the image at offset %d: %w", image.Offset, err)
+			}
+			submitted[image.SHA256] = id
+		}
+		image.TaskID = id
+		return fn(image)
+	})
+}
+
+// isRegionHeader returns true if header looks like the header of a committed region of a cuckoo dump
+func isRegionHeader(header []byte) bool {
+	address := binary.LittleEndian.Uint64(header)
+	size := binary.LittleEndian.Uint32(header[8:])
+	state := binary.LittleEndian.Uint32(header[12:])
+	typ := binary.LittleEndian.Uint32(header[16:])
+	return address%0x1000 == 0 && size > 0 && state == memCommit && (typ == memPrivate || typ == memMapped || typ == memImage)
+}
+
+// carver is the state of the carving of a dump
+type carver struct {
+	opts   Options
+	pid    int
+	fn     func(image *Image) error
+	cuckoo bool
+
+	// Contiguous memory being scanned.  buf starts at address base and is scanned for images starting before
+	// 2*MaxImageSize, so every candidate has MaxImageSize bytes after it unless the memory ends.
+	started bool
+	base    uint64
+	buf     []byte
+	scanned int
+	regions []*region
+}
+
+// region is a part of the memory being scanned
+type region struct {
+	address uint64
+	offset  int64
+	size    uint64
+	private bool
+}
+
+func (c *carver) readRaw(r io.Reader) error {
+	buf := make([]byte, readSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if addErr := c.add(uint64(offset), offset, buf[:n], false); addErr != nil {
+				return addErr
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return c.flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *carver) readRegions(r io.Reader) error {
+	header := make([]byte, regionHeaderSize)
+	buf := make([]byte, readSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return c.flush()
+		} else if err != nil {
+			return fmt.Errorf("carve: truncated region header at offset %d", offset)
+		}
+		offset += regionHeaderSize
+
+		address := binary.LittleEndian.Uint64(header)
+		size := int64(binary.LittleEndian.Uint32(header[8:]))
+		private := binary.LittleEndian.Uint32(header[16:]) == memPrivate
+		for size > 0 {
+			n := size
+			if n > readSize {
+				n = readSize
+			}
+			if _, err := io.ReadFull(r, buf[:n]); err != nil {
+				return fmt.Errorf("carve: truncated region at address %#x", address)
+			}
+			if err := c.add(address, offset, buf[:n], private); err != nil {
+				return err
+			}
+			address += uint64(n)
+			offset += n
+			size -= n
+		}
+	}
+}
+
+// add appends the next bytes of the dump, found at address.  Memory that does not follow the previous bytes ends
+// what is being scanned.
+func (c *carver) add(address uint64, offset int64, data []byte, private bool) error {
+	if c.started && address != c.base+uint64(len(c.buf)) {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	if !c.started {
+		c.started = true
+		c.base = address
+	}
+
+	last := len(c.regions) - 1
+	if last >= 0 && c.regions[last].private == private && c.regions[last].offset+int64(c.regions[last].size) == offset {
+		c.regions[last].size += uint64(len(data))
+	} else {
+		c.regions = append(c.regions, &region{address: address, offset: offset, size: uint64(len(data)), private: private})
+	}
+
+	window := 2 * c.opts.MaxImageSize
+	for len(data) > 0 {
+		n := window - len(c.buf)
+		if n > len(data) {
+			n = len(data)
+		}
+		c.buf = append(c.buf, data[:n]...)
+		data = data[n:]
+
+		if len(c.buf) == window {
+			if err := c.scan(c.opts.MaxImageSize); err != nil {
+				return err
+			}
+			c.shift(c.opts.MaxImageSize)
+		}
+	}
+	return nil
+}
+
+// flush scans what is left of the memory being scanned
+func (c *carver) flush() error {
+	err := c.scan(len(c.buf))
+	c.started = false
+	c.buf = c.buf[:0]
+	c.scanned = 0
+	c.regions = nil
+	return err
+}
+
+// shift drops the first n bytes of the memory being scanned
+func (c *carver) shift(n int) {
+	c.buf = c.buf[:copy(c.buf, c.buf[n:])]
+	c.base += uint64(n)
+	c.scanned -= n
+	if c.scanned < 0 {
+		c.scanned = 0
+	}
+
+	kept := c.regions[:0]
+	for _, r := range c.regions {
+		if r.address+r.size > c.base {
+			kept = append(kept, r)
+		}
+	}
+	c.regions = kept
+}
+
+// scan carves the images starting before limit that were not scanned yet
+func (c *carver) scan(limit int) error {
+	pos := c.scanned
+	c.scanned = limit
+	for pos < limit {
+		end := limit + len(mzMagic) - 1
+		if end > len(c.buf) {
+			end = len(c.buf)
+		}
+		i := bytes.Index(c.buf[pos:end], mzMagic)
+		if i < 0 {
+			return nil
+		}
+		start := pos + i
+		pos = start + 1
+
+		end = start + c.opts.MaxImageSize
+		if end > len(c.buf) {
+			end = len(c.buf)
+		}
+		image := carveImage(c.buf[start:end], c.opts.MaxImageSize)
+		if image == nil {
+			continue
+		}
+
+		address := c.base + uint64(start)
+		r := c.region(address)
+		if r == nil {
+			continue
+		}
+		if c.cuckoo {
+			image.Address = address
+			image.Private = r.private
+			if c.opts.PrivateOnly && !image.Private {
+				continue
+			}
+		}
+		image.PID = c.pid
+		image.Offset = r.offset + int64(address-r.address)
+
+		if c.opts.Dir != "" {
+			file, err := binfile.Write(c.opts.Dir, image.SHA256, image.Data)
+			if err != nil {
+				return fmt.Errorf("error writing the image at offset %d: %w", image.Offset, err)
+			}
+			image.File = file
+		}
+		if err := c.fn(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// region returns the region holding address
+func (c *carver) region(address uint64) *region {
+	i := sort.Search(len(c.regions), func(i int) bool { return c.regions[i].address+c.regions[i].size > address })
+	if i == len(c.regions) || c.regions[i].address > address {
+		return nil
+	}
+	return c.regions[i]
+}
@@ -0,0 +1,356 @@
+package carve
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+// Layout of the test image: headers, .text and .idata importing CreateFileA by name and WSAStartup by ordinal
+const (
+	testSizeOfImage = 0x3000
+	testFileSize    = 0x600
+	testSectionHdrs = 0x138
+	testIAT         = 0x2140
+)
+
+var testSections = []struct {
+	name                          string
+	va, virtualSize, offset, size uint32
+}{
+	{".text", 0x1000, 0x180, 0x200, 0x200},
+	{".idata", 0x2000, 0x1c0, 0x400, 0x200},
+}
+
+// testPE builds the test image laid out as a file
+func testPE() []byte {
+	file := make([]byte, testFileSize)
+	le := binary.LittleEndian
+	copy(file, "MZ")
+	le.PutUint32(file[0x3c:], 0x40)
+	copy(file[0x40:], "PE\x00\x00")
+	le.PutUint16(file[0x44:], pe.IMAGE_FILE_MACHINE_I386)
+	le.PutUint16(file[0x46:], uint16(len(testSections)))
+	le.PutUint16(file[0x54:], 0xe0)
+	le.PutUint16(file[0x56:], pe.IMAGE_FILE_EXECUTABLE_IMAGE|pe.IMAGE_FILE_32BIT_MACHINE)
+
+	optional := file[0x58:]
+	le.PutUint16(optional, 0x10b)
+	le.PutUint32(optional[16:], 0x1000)
+	le.PutUint32(optional[28:], 0x400000)
+	le.PutUint32(optional[32:], 0x1000)
+	le.PutUint32(optional[36:], 0x200)
+	le.PutUint32(optional[56:], testSizeOfImage)
+	le.PutUint32(optional[60:], 0x200)
+	le.PutUint16(optional[68:], 2)
+	le.PutUint32(optional[92:], 16)
+	le.PutUint32(optional[104:], 0x2000)
+	le.PutUint32(optional[108:], 0x3c)
+
+	for i, s := range testSections {
+		header := file[testSectionHdrs+i*sectionHeaderSize:]
+		copy(header, s.name)
+		le.PutUint32(header[8:], s.virtualSize)
+		le.PutUint32(header[12:], s.va)
+		le.PutUint32(header[16:], s.size)
+		le.PutUint32(header[20:], s.offset)
+	}
+
+	for i := 0; i < 0x180; i++ {
+		file[0x200+i] = byte(i*7 + 1)
+	}
+
+	// .idata, at RVA 0x2000
+	at := func(rva uint32) []byte { return file[0x400+rva-0x2000:] }
+	for i, d := range [][3]uint32{{0x2100, 0x2180, 0x2140}, {0x2110, 0x2190, 0x2150}} {
+		descriptor := at(0x2000 + uint32(i)*20)
+		le.PutUint32(descriptor, d[0])
+		le.PutUint32(descriptor[12:], d[1])
+		le.PutUint32(descriptor[16:], d[2])
+	}
+	for _, thunks := range []uint32{0x2100, 0x2140} {
+		le.PutUint32(at(thunks), 0x21a0)
+		le.PutUint32(at(thunks+0x10), 0x80000000|115)
+	}
+	copy(at(0x2180), "KERNEL32.dll")
+	copy(at(0x2190), "WS2_32.dll")
+	copy(at(0x21a2), "CreateFileA")
+	return file
+}
+
+// mapImage lays out an image like the loader, with its import address table resolved
+func mapImage(file []byte) []byte {
+	mapped := make([]byte, testSizeOfImage)
+	copy(mapped, file[:0x200])
+	for _, s := range testSections {
+		copy(mapped[s.va:], file[s.offset:s.offset+s.size])
+	}
+	binary.LittleEndian.PutUint32(mapped[testIAT:], 0x7c801a28)
+	binary.LittleEndian.PutUint32(mapped[testIAT+0x10:], 0x71ab6a55)
+	return mapped
+}
+
+// unmappedPE is the file carved from mapImage(testPE())
+func unmappedPE() []byte {
+	file := testPE()
+	binary.LittleEndian.PutUint32(file[0x400+testIAT-0x2000:], 0x7c801a28)
+	binary.LittleEndian.PutUint32(file[0x400+testIAT-0x2000+0x10:], 0x71ab6a55)
+	return file
+}
+
+// testRegion returns a region of a cuckoo dump
+func testRegion(address uint64, typ uint32, data []byte) []byte {
+	header := make([]byte, regionHeaderSize)
+	binary.LittleEndian.PutUint64(header, address)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[12:], memCommit)
+	binary.LittleEndian.PutUint32(header[16:], typ)
+	binary.LittleEndian.PutUint32(header[20:], 0x40)
+	return append(header, data...)
+}
+
+func testImphash() string {
+	sum := md5.Sum([]byte("kernel32.createfilea,ws2_32.wsastartup"))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCarveRaw(t *testing.T) {
+	file := testPE()
+	fake := append([]byte("MZ"), make([]byte, 0x3e)...)
+	fake[0x3c] = 0x80
+	dump := testutil.Dump(0x10000, 0xcc, map[int][]byte{
+		0x1234: file,
+		0x5000: fake,
+		0x8000: mapImage(file),
+	})
+
+	images, err := CarveAll(iotest.HalfReader(bytes.NewReader(dump)), 1234, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(images))
+	}
+
+	for i, expected := range []struct {
+		offset int64
+		layout Layout
+		data   []byte
+	}{
+		{0x1234, LayoutFile, file},
+		{0x8000, LayoutMapped, unmappedPE()},
+	} {
+		image := images[i]
+		if image.PID != 1234 || image.Offset != expected.offset || image.Address != 0 || image.Layout != expected.layout ||
+			image.Realigned || image.Truncated || image.Private {
+			t.Errorf("unexpected image %+v", image)
+		}
+		if image.Machine != "i386" || image.DLL || image.Size != testFileSize || !bytes.Equal(image.Data, expected.data) ||
+			image.SHA256 != testutil.SHA256Hex(expected.data) || len(image.MD5) != 32 || len(image.SHA1) != 40 {
+			t.Errorf("unexpected image %+v", image)
+		}
+		if image.Imphash != testImphash() {
+			t.Errorf("unexpected imphash %s", image.Imphash)
+		}
+		if len(image.Sections) != 2 {
+			t.Fatalf("expected 2 sections, got %d", len(image.Sections))
+		}
+		if text := image.Sections[0]; text.Name != ".text" || text.VirtualAddress != 0x1000 || text.VirtualSize != 0x180 ||
+			text.RawOffset != 0x200 || text.RawSize != 0x200 || text.Entropy <= 0 || text.Entropy > 8 {
+			t.Errorf("unexpected section %+v", text)
+		}
+	}
+}
+
+func TestCarveCuckoo(t *testing.T) {
+	file := testPE()
+	mapped := mapImage(file)
+	var dump []byte
+	// A module mapped from a file, then a payload mapped into private memory across two regions
+	dump = append(dump, testRegion(0x400000, memImage, mapped)...)
+	dump = append(dump, testRegion(0x10000000, memPrivate, bytes.Repeat([]byte{0xcc}, 0x1000))...)
+	payload := len(dump) + regionHeaderSize
+	dump = append(dump, testRegion(0x20000000, memPrivate, mapped[:0x1000])...)
+	dump = append(dump, testRegion(0x20001000, memPrivate, mapped[0x1000:])...)
+
+	images, err := CarveAll(bytes.NewReader(dump), 1234, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(images))
+	}
+	if module := images[0]; module.Address != 0x400000 || module.Offset != regionHeaderSize || module.Private {
+		t.Errorf("unexpected module %+v", module)
+	}
+
+	images, err = CarveAll(bytes.NewReader(dump), 1234, &Options{PrivateOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("expected 1 image, got %d", len(images))
+	}
+	image := images[0]
+	if image.Address != 0x20000000 || image.Offset != int64(payload) || !image.Private || image.Layout != LayoutMapped ||
+		!bytes.Equal(image.Data, unmappedPE()) {
+		t.Errorf("unexpected payload %+v", image)
+	}
+
+	// The same dump read as raw memory, the headers of the regions shift the sections of the payload
+	images, err = CarveAll(bytes.NewReader(dump), 1234, &Options{Format: FormatRaw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].Offset != regionHeaderSize || images[0].Address != 0 ||
+		images[1].Offset != int64(payload) || bytes.Equal(images[1].Data, unmappedPE()) {
+		t.Errorf("unexpected images of the raw dump %+v", images)
+	}
+}
+
+func TestCarveRealigned(t *testing.T) {
+	// Packed like UPX, .text has no raw data and is unpacked in memory
+	mapped := mapImage(testPE())
+	text := mapped[testSectionHdrs:]
+	binary.LittleEndian.PutUint32(text[16:], 0)
+	binary.LittleEndian.PutUint32(text[20:], 0)
+
+	images, err := CarveAll(bytes.NewReader(mapped), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("expected 1 image, got %d", len(images))
+	}
+	image := images[0]
+	if image.Layout != LayoutMapped || !image.Realigned || image.Size != testSizeOfImage || image.Imphash != testImphash() {
+		t.Errorf("unexpected image %+v", image)
+	}
+
+	f, err := pe.NewFile(bytes.NewReader(image.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range f.Sections {
+		data, err := s.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Offset != s.VirtualAddress || s.Size != s.VirtualSize || !bytes.Equal(data, mapped[s.VirtualAddress:s.VirtualAddress+s.Size]) {
+			t.Errorf("unexpected section %+v", s.SectionHeader)
+		}
+	}
+}
+
+func TestCarveWindow(t *testing.T) {
+	file := testPE()
+	for _, offset := range []int{0, 0x3f00, 0x4000, 0x7ffe, 0x8001, 0xbd00} {
+		dump := testutil.Dump(0x10000, 0xcc, map[int][]byte{offset: file})
+		images, err := CarveAll(bytes.NewReader(dump), 1, &Options{MaxImageSize: 0x4000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(images) != 1 || images[0].Offset != int64(offset) || !bytes.Equal(images[0].Data, file) {
+			t.Errorf("expected the image at offset %#x, got %+v", offset, images)
+		}
+	}
+
+	images, err := CarveAll(bytes.NewReader(testutil.Dump(0x10000, 0xcc, map[int][]byte{0xfd00: file})), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || !images[0].Truncated || !bytes.Equal(images[0].Data[:0x300], file[:0x300]) {
+		t.Errorf("expected a truncated image, got %+v", images)
+	}
+
+	// Larger than MaxImageSize
+	images, err = CarveAll(bytes.NewReader(mapImage(file)), 1, &Options{MaxImageSize: 0x2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 0 {
+		t.Errorf("expected no image, got %+v", images)
+	}
+}
+
+func TestCarveTask(t *testing.T) {
+	file := testPE()
+	dump := testutil.Dump(0x4000, 0xcc, map[int][]byte{0x100: file, 0x2000: file})
+	submitted := []string{}
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/memory/get/42/1234":
+			// The download takes longer than the timeout of the client
+			testutil.WriteSlowly(w, dump, 80*time.Millisecond)
+		case "/tasks/create/file":
+			sample, header, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(sample)
+			if !bytes.Equal(data, file) || r.FormValue("priority") != "3" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			submitted = append(submitted, header.Filename)
+			fmt.Fprintf(w, `{"task_id": %d}`, 42+len(submitted))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c.Client.Timeout = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	opts := &Options{Dir: dir, Resubmit: true, TaskOptions: &cuckoo.TaskOptions{Priority: 3}}
+	images := []*Image{}
+	err := CarveTask(context.Background(), c, 42, 1234, opts, func(image *Image) error {
+		images = append(images, image)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := testutil.SHA256Hex(file)
+	if len(submitted) != 1 || submitted[0] != sum+".exe" {
+		t.Errorf("expected the image to be submitted once, got %v", submitted)
+	}
+	if len(images) != 2 || images[0].TaskID != 43 || images[1].TaskID != 43 {
+		t.Fatalf("unexpected images %+v", images)
+	}
+	path := filepath.Join(dir, sum+".bin")
+	if images[0].File != path || images[1].File != path {
+		t.Errorf("unexpected files %s %s", images[0].File, images[1].File)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); info.Mode().Perm() != 0400 || !bytes.Equal(data, file) {
+		t.Errorf("unexpected carved file %v", info.Mode())
+	}
+
+	stop := errors.New("stop")
+	if err := CarveTask(context.Background(), c, 42, 1234, nil, func(*Image) error { return stop }); err != stop {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+	if err := CarveTask(context.Background(), c, 42, 1, nil, func(*Image) error { return nil }); err == nil {
+		t.Errorf("expected an error for a missing dump")
+	}
+}
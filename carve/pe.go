@@ -0,0 +1,463 @@
+package carve
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// Furthest e_lfanew accepted, and largest headers
+	maxHeaderOffset = 0x1000
+	maxHeaderSize   = 0x10000
+	maxSections     = 96
+
+	sectionHeaderSize = 40
+	// Bytes compared to tell whether an image is mapped
+	layoutSample = 0x200
+
+	// Most imports read for the imphash, and longest name
+	maxImports    = 0x10000
+	maxImportName = 0x200
+)
+
+var (
+	mzMagic = []byte("MZ")
+	peMagic = []byte("PE\x00\x00")
+)
+
+// machines are the names of the architectures carved
+var machines = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "i386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+	pe.IMAGE_FILE_MACHINE_ARM:   "arm",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "armnt",
+	pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+}
+
+// headers are the headers of an image
+type headers struct {
+	file    *pe.File
+	machine string
+	dll     bool
+	is64    bool
+
+	sizeOfImage      uint32
+	sizeOfHeaders    uint32
+	sectionAlignment uint32
+	importRVA        uint32
+
+	// Offsets of the optional header and of the section table
+	optionalHeader int
+	sectionTable   int
+	// Names of the sections as they are in the section table
+	sectionNames []string
+}
+
+// parseHeaders validates the headers of the image at the start of data
+func parseHeaders(data []byte, maxSize int) (*headers, error) {
+	if len(data) < 0x40 || !bytes.HasPrefix(data, mzMagic) {
+		return nil, fmt.Errorf("no dos header")
+	}
+	lfanew := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	if lfanew < 0x40 || lfanew > maxHeaderOffset || lfanew+24 > len(data) || !bytes.Equal(data[lfanew:lfanew+4], peMagic) {
+		return nil, fmt.Errorf("no pe header")
+	}
+	sections := int(binary.LittleEndian.Uint16(data[lfanew+6:]))
+	optionalSize := int(binary.LittleEndian.Uint16(data[lfanew+20:]))
+	tableEnd := lfanew + 24 + optionalSize + sections*sectionHeaderSize
+	if sections == 0 || sections > maxSections || tableEnd > len(data) || tableEnd > maxHeaderSize {
+		return nil, fmt.Errorf("bad section table")
+	}
+
+	// debug/pe follows the file pointers to the symbols, the string table of long section names and the
+	// relocations, which mean nothing in memory
+	header := append([]byte{}, data[:tableEnd]...)
+	binary.LittleEndian.PutUint64(header[lfanew+12:], 0)
+	table := lfanew + 24 + optionalSize
+	names := make([]string, sections)
+	for i := range names {
+		s := table + i*sectionHeaderSize
+		names[i] = string(bytes.TrimRight(header[s:s+8], "\x00"))
+		header[s] = 0
+		binary.LittleEndian.PutUint32(header[s+24:], 0)
+		binary.LittleEndian.PutUint16(header[s+32:], 0)
+	}
+	f, err := pe.NewFile(bytes.NewReader(header))
+	if err != nil {
+		return nil, err
+	}
+
+	h := &headers{
+		file:           f,
+		sectionNames:   names,
+		dll:            f.Characteristics&pe.IMAGE_FILE_DLL != 0,
+		optionalHeader: lfanew + 24,
+		sectionTable:   table,
+	}
+	var ok bool
+	if h.machine, ok = machines[f.Machine]; !ok {
+		return nil, fmt.Errorf("unknown machine %#x", f.Machine)
+	}
+
+	var directories []pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		h.sizeOfImage, h.sizeOfHeaders, h.sectionAlignment = oh.SizeOfImage, oh.SizeOfHeaders, oh.SectionAlignment
+		directories = oh.DataDirectory[:directoryCount(oh.NumberOfRvaAndSizes)]
+	case *pe.OptionalHeader64:
+		h.is64 = true
+		h.sizeOfImage, h.sizeOfHeaders, h.sectionAlignment = oh.SizeOfImage, oh.SizeOfHeaders, oh.SectionAlignment
+		directories = oh.DataDirectory[:directoryCount(oh.NumberOfRvaAndSizes)]
+	default:
+		return nil, fmt.Errorf("no optional header")
+	}
+	if len(directories) > pe.IMAGE_DIRECTORY_ENTRY_IMPORT {
+		h.importRVA = directories[pe.IMAGE_DIRECTORY_ENTRY_IMPORT].VirtualAddress
+	}
+
+	if int64(h.sizeOfImage) < int64(tableEnd) || int64(h.sizeOfImage) > int64(maxSize) || h.sizeOfHeaders == 0 ||
+		h.sizeOfHeaders > h.sizeOfImage {
+		return nil, fmt.Errorf("bad image size")
+	}
+	for _, s := range f.Sections {
+		if s.VirtualAddress >= h.sizeOfImage || uint64(s.VirtualAddress)+uint64(s.VirtualSize) > uint64(h.sizeOfImage) {
+			return nil, fmt.Errorf("section %q outside of the image", s.Name)
+		}
+	}
+	if h.fileSize() > int64(maxSize) {
+		return nil, fmt.Errorf("bad file size")
+	}
+	return h, nil
+}
+
+// directoryCount returns the number of data directories read by debug/pe
+func directoryCount(n uint32) int {
+	if n > 16 {
+		return 16
+	}
+	return int(n)
+}
+
+// fileSize returns the size of the image laid out as a file, without overlay
+func (h *headers) fileSize() int64 {
+	size := int64(h.sizeOfHeaders)
+	for _, s := range h.file.Sections {
+		if end := int64(s.Offset) + int64(s.Size); s.Size > 0 && end > size {
+			size = end
+		}
+	}
+	return size
+}
+
+// isMapped tells the layout of an image from the data of its first section laid out differently in a file
+func (h *headers) isMapped(data []byte) bool {
+	for _, s := range h.file.Sections {
+		if s.Size == 0 || s.Offset == s.VirtualAddress {
+			continue
+		}
+		return isZero(slice(data, s.Offset, layoutSample)) && !isZero(slice(data, s.VirtualAddress, layoutSample))
+	}
+	return false
+}
+
+// unmap rebuilds the file of a mapped image.  If the section table does not describe where the data of the
+// sections is, the headers are rewritten for each section to be read at its virtual address instead.
+func (h *headers) unmap(mapped []byte) ([]byte, bool) {
+	sections := h.file.Sections
+	consistent := true
+	for i, s := range sections {
+		if s.Size == 0 {
+			// Typical of packers unpacking into an empty section
+			if s.VirtualSize > 0 && !isZero(slice(mapped, s.VirtualAddress, s.VirtualSize)) {
+				consistent = false
+			}
+			continue
+		}
+		if s.Offset < h.sizeOfHeaders {
+			consistent = false
+		}
+		for _, other := range sections[:i] {
+			if other.Size > 0 && s.Offset < other.Offset+other.Size && other.Offset < s.Offset+s.Size {
+				consistent = false
+			}
+		}
+	}
+
+	if consistent {
+		file := make([]byte, h.fileSize())
+		copy(file, slice(mapped, 0, h.sizeOfHeaders))
+		for _, s := range sections {
+			copy(file[s.Offset:s.Offset+s.Size], slice(mapped, s.VirtualAddress, s.Size))
+		}
+		return file, false
+	}
+
+	for i, s := range sections {
+		size := s.VirtualSize
+		if size == 0 {
+			size = s.Size
+		}
+		if size > h.sizeOfImage-s.VirtualAddress {
+			size = h.sizeOfImage - s.VirtualAddress
+		}
+		header := mapped[h.sectionTable+i*sectionHeaderSize:]
+		binary.LittleEndian.PutUint32(header[16:], size)
+		binary.LittleEndian.PutUint32(header[20:], s.VirtualAddress)
+	}
+	// FileAlignment, the sections are now aligned like in memory
+	binary.LittleEndian.PutUint32(mapped[h.optionalHeader+36:], h.sectionAlignment)
+	return mapped, true
+}
+
+// carveImage carves the image at the start of data, or returns nil if there is no valid image
+func carveImage(data []byte, maxSize int) *Image {
+	h, err := parseHeaders(data, maxSize)
+	if err != nil {
+		return nil
+	}
+
+	image := &Image{Machine: h.machine, DLL: h.dll, Layout: LayoutFile}
+	var file []byte
+	if h.isMapped(data) {
+		image.Layout = LayoutMapped
+		var mapped []byte
+		mapped, image.Truncated = extend(data, int64(h.sizeOfImage))
+		file, image.Realigned = h.unmap(mapped)
+	} else {
+		file, image.Truncated = extend(data, h.fileSize())
+	}
+
+	// Describe the file as it was carved
+	if h, err = parseHeaders(file, maxSize); err != nil {
+		return nil
+	}
+	for i, s := range h.file.Sections {
+		image.Sections = append(image.Sections, &Section{
+			Name:            h.sectionNames[i],
+			VirtualAddress:  s.VirtualAddress,
+			VirtualSize:     s.VirtualSize,
+			RawOffset:       s.Offset,
+			RawSize:         s.Size,
+			Characteristics: s.Characteristics,
+			Entropy:         entropy(slice(file, s.Offset, s.Size)),
+		})
+	}
+	image.Imphash = h.imphash(file)
+
+	md5Sum := md5.Sum(file)
+	sha1Sum := sha1.Sum(file)
+	sha256Sum := sha256.Sum256(file)
+	image.MD5 = hex.EncodeToString(md5Sum[:])
+	image.SHA1 = hex.EncodeToString(sha1Sum[:])
+	image.SHA256 = hex.EncodeToString(sha256Sum[:])
+	image.Size = len(file)
+	image.Data = file
+	return image
+}
+
+// imphash returns the hash of the imports of a file like pefile computes it, or "" if it imports nothing
+func (h *headers) imphash(file []byte) string {
+	if h.importRVA == 0 {
+		return ""
+	}
+	thunkSize := uint32(4)
+	ordinalFlag := uint64(1) << 31
+	if h.is64 {
+		thunkSize = 8
+		ordinalFlag = 1 << 63
+	}
+
+	imports := []string{}
+	for descriptor := h.importRVA; len(imports) < maxImports; descriptor += 20 {
+		d := h.read(file, descriptor, 20)
+		if d == nil || isZero(d) {
+			break
+		}
+		library, ok := h.name(file, binary.LittleEndian.Uint32(d[12:]))
+		if !ok {
+			break
+		}
+		library = strings.ToLower(library)
+		// The import address table is overwritten by the loader, the lookup table is only left out by old linkers
+		thunk := binary.LittleEndian.Uint32(d)
+		if thunk == 0 {
+			thunk = binary.LittleEndian.Uint32(d[16:])
+		}
+
+		for ; len(imports) < maxImports; thunk += thunkSize {
+			t := h.read(file, thunk, thunkSize)
+			if t == nil {
+				break
+			}
+			var value uint64
+			if h.is64 {
+				value = binary.LittleEndian.Uint64(t)
+			} else {
+				value = uint64(binary.LittleEndian.Uint32(t))
+			}
+			if value == 0 {
+				break
+			}
+
+			var function string
+			if value&ordinalFlag != 0 {
+				function = ordinalName(library, uint16(value))
+			} else if function, ok = h.name(file, uint32(value)+2); !ok {
+				break
+			}
+			imports = append(imports, libraryName(library)+"."+strings.ToLower(function))
+		}
+	}
+	if len(imports) == 0 {
+		return ""
+	}
+	sum := md5.Sum([]byte(strings.Join(imports, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// read returns size bytes of a file at a relative virtual address, or nil if they are not in the file
+func (h *headers) read(file []byte, rva, size uint32) []byte {
+	offset, ok := h.offset(rva)
+	if !ok || uint64(offset)+uint64(size) > uint64(len(file)) {
+		return nil
+	}
+	return file[offset : offset+size]
+}
+
+// name returns the NUL terminated name at a relative virtual address
+func (h *headers) name(file []byte, rva uint32) (string, bool) {
+	offset, ok := h.offset(rva)
+	if !ok || int64(offset) >= int64(len(file)) {
+		return "", false
+	}
+	b := slice(file, offset, maxImportName)
+	end := bytes.IndexByte(b, 0)
+	if end <= 0 {
+		return "", false
+	}
+	return string(b[:end]), true
+}
+
+// offset maps a relative virtual address to its offset in the file
+func (h *headers) offset(rva uint32) (uint32, bool) {
+	if rva < h.sizeOfHeaders {
+		return rva, true
+	}
+	for _, s := range h.file.Sections {
+		size := s.VirtualSize
+		if size < s.Size {
+			size = s.Size
+		}
+		if rva >= s.VirtualAddress && rva-s.VirtualAddress < size {
+			if rva-s.VirtualAddress >= s.Size {
+				return 0, false
+			}
+			return s.Offset + rva - s.VirtualAddress, true
+		}
+	}
+	return 0, false
+}
+
+// libraryName returns the name of a library in the imphash, without its extension
+func libraryName(library string) string {
+	if i := strings.LastIndexByte(library, '.'); i >= 0 {
+		switch library[i+1:] {
+		case "dll", "ocx", "sys":
+			return library[:i]
+		}
+	}
+	return library
+}
+
+// ordinalName returns the name of a function imported by ordinal, resolved like pefile for the common functions of
+// the libraries it knows
+func ordinalName(library string, ordinal uint16) string {
+	if names, ok := ordinals[library]; ok {
+		if name, ok := names[ordinal]; ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("ord%d", ordinal)
+}
+
+// winsockOrdinals are the ordinals of the Windows Sockets 1.1 functions
+var winsockOrdinals = map[uint16]string{
+	1: "accept", 2: "bind", 3: "closesocket", 4: "connect", 5: "getpeername", 6: "getsockname", 7: "getsockopt",
+	8: "htonl", 9: "htons", 10: "ioctlsocket", 11: "inet_addr", 12: "inet_ntoa", 13: "listen", 14: "ntohl",
+	15: "ntohs", 16: "recv", 17: "recvfrom", 18: "select", 19: "send", 20: "sendto", 21: "setsockopt",
+	22: "shutdown", 23: "socket",
+	51: "gethostbyaddr", 52: "gethostbyname", 53: "getprotobyname", 54: "getprotobynumber", 55: "getservbyname",
+	56: "getservbyport", 57: "gethostname",
+	101: "WSAAsyncSelect", 102: "WSAAsyncGetHostByAddr", 103: "WSAAsyncGetHostByName",
+	104: "WSAAsyncGetProtoByNumber", 105: "WSAAsyncGetProtoByName", 106: "WSAAsyncGetServByPort",
+	107: "WSAAsyncGetServByName", 108: "WSACancelAsyncRequest", 109: "WSASetBlockingHook",
+	110: "WSAUnhookBlockingHook", 111: "WSAGetLastError", 112: "WSASetLastError", 113: "WSACancelBlockingCall",
+	114: "WSAIsBlocking", 115: "WSAStartup", 116: "WSACleanup",
+	151: "__WSAFDIsSet", 500: "WEP",
+}
+
+// ordinals are the functions of the libraries commonly imported by ordinal
+var ordinals = map[string]map[uint16]string{
+	"ws2_32.dll":  winsockOrdinals,
+	"wsock32.dll": winsockOrdinals,
+	"oleaut32.dll": {
+		2: "SysAllocString", 3: "SysReAllocString", 4: "SysAllocStringLen", 5: "SysReAllocStringLen",
+		6: "SysFreeString", 7: "SysStringLen", 8: "VariantInit", 9: "VariantClear", 10: "VariantCopy",
+		11: "VariantCopyInd", 12: "VariantChangeType", 149: "SysStringByteLen", 150: "SysAllocStringByteLen",
+	},
+}
+
+// extend returns a copy of the first size bytes of data, zero filled if data is shorter
+func extend(data []byte, size int64) ([]byte, bool) {
+	out := make([]byte, size)
+	n := copy(out, data)
+	return out, int64(n) < size
+}
+
+// slice returns up to size bytes of data at offset
+func slice(data []byte, offset, size uint32) []byte {
+	if int64(offset) >= int64(len(data)) {
+		return nil
+	}
+	end := int64(offset) + int64(size)
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return data[offset:end]
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// entropy returns the Shannon entropy of data in bits per byte
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	e := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
@@ -19,6 +19,9 @@ type Client struct {
 
 	// Client used for requests
 	Client *http.Client
+
+	// Optional cache of the artifacts of finished tasks
+	Cache *Cache
 }
 
 // Config is the configuration required to create a client
@@ -28,6 +31,8 @@ type Config struct {
 	// Optional, if nil a new client will be created
 	// with a defaultTimeout
 	Client *http.Client
+	// Optional, caches reports, PCAPs, screenshots and samples on disk, see NewCache
+	Cache *Cache
 }
 
 // New Creates a new client based on the provided API Key
@@ -43,6 +48,7 @@ func New(c *Config) *Client {
 		APIKey:  c.APIKey,
 		BaseURL: c.BaseURL,
 		Client:  client,
+		Cache:   c.Cache,
 	}
 }
 
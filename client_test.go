@@ -2,6 +2,8 @@ package cuckoo
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 )
@@ -10,6 +12,13 @@ func getTestingClient() *Client {
 	return New(&Config{APIKey: os.Getenv("API_KEY"), BaseURL: os.Getenv("BASEURL")})
 }
 
+// getMockClient returns a client pointed at a local server running handler
+func getMockClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(&Config{APIKey: "test", BaseURL: server.URL})
+}
+
 func TestClient(t *testing.T) {
 	c := getTestingClient()
 
@@ -0,0 +1,267 @@
+package cuckoo
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/godaddy/go-cukoo/internal/binfile"
+)
+
+// HashMismatchError is returned when the content of a dropped file does not match the hashes of the report
+type HashMismatchError struct {
+	Name     string
+	Expected string
+	Actual   string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("cuckoo: dropped file %s hash mismatch: expected %s, got %s", e.Name, e.Expected, e.Actual)
+}
+
+// DroppedFiles iterates over the dropped files bundle of a task, like tar.Reader
+type DroppedFiles struct {
+	archive *ReportArchive
+	dropped []*DroppedFile
+}
+
+// DroppedFileReader is a file of the dropped files bundle.  Reading it returns the content of the file.
+//
+// When the file is found in the dropped section of the report its hashes are known upfront and the content is
+// verified against them: the last Read returns a *HashMismatchError if it differs.  Once the file has been read
+// to the end and verified, the hashes are those of the content, lowercase.
+type DroppedFileReader struct {
+	// Name of the file in the bundle
+	Name string
+	// Path of the file on the analysis machine, empty if the file is not in the report
+	Path string
+	Size int64
+
+	MD5    string
+	SHA1   string
+	SHA256 string
+
+	// The entry of the report for this file, nil if it is not in the report
+	Report *DroppedFile
+
+	r      io.Reader
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
+	done   bool
+}
+
+// NewDroppedFiles Iterates over a dropped files archive, cross-referencing its entries with the dropped section
+// of the report.  dropped may be nil.
+func NewDroppedFiles(archive *ReportArchive, dropped []*DroppedFile) *DroppedFiles {
+	return &DroppedFiles{archive: archive, dropped: dropped}
+}
+
+// TasksDroppedFiles Downloads the dropped files of the specified task ID along with the dropped section of its
+// report.
+//
+// The returned DroppedFiles must be closed once done.
+func (c *Client) TasksDroppedFiles(ctx context.Context, taskID int) (*DroppedFiles, error) {
+	dropped := []*DroppedFile{}
+	if err := c.TasksReportSection(ctx, taskID, "dropped", &dropped); err != nil {
+		return nil, fmt.Errorf("error getting dropped files of task %d: %w", taskID, err)
+	}
+
+	archive, err := c.TasksReportArchive(ctx, taskID, ReportDropped)
+	if err != nil {
+		return nil, err
+	}
+	return NewDroppedFiles(archive, dropped), nil
+}
+
+// Next advances to the next dropped file.  It returns io.EOF at the end.
+func (d *DroppedFiles) Next() (*DroppedFileReader, error) {
+	for {
+		header, err := d.archive.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		return d.newReader(header), nil
+	}
+}
+
+// Walk calls fn for every dropped file.  Returning an error from fn stops the walk.
+func (d *DroppedFiles) Walk(fn func(file *DroppedFileReader) error) error {
+	for {
+		file, err := d.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the underlying response
+func (d *DroppedFiles) Close() error {
+	return d.archive.Close()
+}
+
+// newReader returns the reader of the current archive entry, matched with its report entry
+func (d *DroppedFiles) newReader(header *tar.Header) *DroppedFileReader {
+	f := &DroppedFileReader{
+		Name:   header.Name,
+		Size:   header.Size,
+		r:      d.archive.tar,
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+	}
+
+	// The report has the path of the file in the storage of the analysis, which ends with the archive name
+	name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+	for _, dropped := range d.dropped {
+		storagePath := filepath.ToSlash(dropped.Path)
+		if storagePath == name || strings.HasSuffix(storagePath, "/"+name) {
+			f.Report = dropped
+			f.Path = dropped.Filepath
+			f.MD5, f.SHA1, f.SHA256 = dropped.MD5, dropped.SHA1, dropped.SHA256
+			break
+		}
+	}
+
+	return f
+}
+
+// Read reads the content of the file, verifying it at the end
+func (f *DroppedFileReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.md5.Write(p[:n])
+	f.sha1.Write(p[:n])
+	f.sha256.Write(p[:n])
+
+	if err == io.EOF && !f.done {
+		f.done = true
+		if verifyErr := f.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+// verify compares the hashes of the content with the strongest hash the report has, then sets every hash from the
+// content so they are all known even if the report left some out
+func (f *DroppedFileReader) verify() error {
+	actual := map[string]string{
+		"md5":    hex.EncodeToString(f.md5.Sum(nil)),
+		"sha1":   hex.EncodeToString(f.sha1.Sum(nil)),
+		"sha256": hex.EncodeToString(f.sha256.Sum(nil)),
+	}
+
+	for _, expected := range [][2]string{{"sha256", f.SHA256}, {"sha1", f.SHA1}, {"md5", f.MD5}} {
+		if expected[1] == "" {
+			continue
+		}
+		if !strings.EqualFold(expected[1], actual[expected[0]]) {
+			return &HashMismatchError{Name: f.Name, Expected: expected[1], Actual: actual[expected[0]]}
+		}
+		break
+	}
+
+	f.MD5, f.SHA1, f.SHA256 = actual["md5"], actual["sha1"], actual["sha256"]
+	return nil
+}
+
+// QuarantinedFile is a dropped file written to a quarantine directory
+type QuarantinedFile struct {
+	// Path of the file in the quarantine directory
+	QuarantinePath string `json:"quarantine_path"`
+	// Name of the file in the bundle
+	Name string `json:"name"`
+	// Path of the file on the analysis machine
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	PIDs   []int  `json:"pids,omitempty"`
+}
+
+// Quarantine Writes the file into dir without ever using its original name.
+//
+// The file is named after its SHA256 with a ".bin" extension so it cannot be run by mistake, it is read only, and
+// it only appears once fully written and verified.  Its metadata is written next to it as "<sha256>.json".
+// Files already in quarantine are not written again.
+func (f *DroppedFileReader) Quarantine(dir string) (*QuarantinedFile, error) {
+	tmp, err := binfile.Create(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Discard()
+
+	size, err := io.Copy(tmp, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if !f.done {
+		return nil, fmt.Errorf("%s was not read to the end", f.Name)
+	}
+
+	// Named after the hash of the content, the report may not have one
+	path, err := tmp.Commit(f.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	quarantined := &QuarantinedFile{
+		QuarantinePath: path,
+		Name:           f.Name,
+		Path:           f.Path,
+		Size:           size,
+		MD5:            f.MD5,
+		SHA1:           f.SHA1,
+		SHA256:         f.SHA256,
+	}
+	if f.Report != nil {
+		quarantined.PIDs = f.Report.PIDs
+	}
+
+	metadata, err := json.MarshalIndent(quarantined, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	metadataPath := strings.TrimSuffix(quarantined.QuarantinePath, ".bin") + ".json"
+	if err := ioutil.WriteFile(metadataPath, metadata, 0600); err != nil {
+		return nil, err
+	}
+
+	return quarantined, nil
+}
+
+// Quarantine Writes every remaining dropped file into dir, see DroppedFileReader.Quarantine
+func (d *DroppedFiles) Quarantine(dir string) ([]*QuarantinedFile, error) {
+	quarantined := []*QuarantinedFile{}
+	err := d.Walk(func(file *DroppedFileReader) error {
+		q, err := file.Quarantine(dir)
+		if err != nil {
+			return fmt.Errorf("error quarantining %s: %w", file.Name, err)
+		}
+		quarantined = append(quarantined, q)
+		return nil
+	})
+	return quarantined, err
+}
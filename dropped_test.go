@@ -0,0 +1,220 @@
+package cuckoo
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDroppedFile returns the report entry of a dropped file with the hashes of content
+func testDroppedFile(name, content string) *DroppedFile {
+	md5Sum := md5.Sum([]byte(content))
+	sha1Sum := sha1.Sum([]byte(content))
+	sha256Sum := sha256.Sum256([]byte(content))
+	return &DroppedFile{
+		ReportFile: ReportFile{
+			Name:   filepath.Base(name),
+			Path:   "/home/cuckoo/.cuckoo/storage/analyses/42/" + name,
+			Size:   int64(len(content)),
+			MD5:    hex.EncodeToString(md5Sum[:]),
+			SHA1:   hex.EncodeToString(sha1Sum[:]),
+			SHA256: hex.EncodeToString(sha256Sum[:]),
+		},
+		Filepath: `C:\Users\cuckoo\AppData\Roaming\` + filepath.Base(name),
+		PIDs:     []int{2048},
+	}
+}
+
+func droppedMockClient(t *testing.T, files map[string]string, dropped []*DroppedFile) *Client {
+	tarball := testTarball(t, files)
+	report, err := json.Marshal(map[string]interface{}{"dropped": dropped})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/report/42/json":
+			w.Write(report)
+		case "/tasks/report/42/dropped":
+			w.Write(tarball)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestTasksDroppedFiles(t *testing.T) {
+	updater := testDroppedFile("files/3c1c6d2b/updater.exe", "MZ updater")
+	c := droppedMockClient(t, map[string]string{
+		"files/3c1c6d2b/updater.exe": "MZ updater",
+		"files/0000/unknown.txt":     "not in the report",
+	}, []*DroppedFile{updater})
+
+	dropped, err := c.TasksDroppedFiles(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dropped.Close()
+
+	files := map[string]*DroppedFileReader{}
+	err = dropped.Walk(func(file *DroppedFileReader) error {
+		files[file.Name] = file
+		content, err := ioutil.ReadAll(file)
+		if int64(len(content)) != file.Size {
+			t.Errorf("%s: expected %d bytes, read %d", file.Name, file.Size, len(content))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := files["files/3c1c6d2b/updater.exe"]
+	if file == nil || file.Report == nil || file.Report.SHA256 != updater.SHA256 || file.Path != updater.Filepath || file.SHA256 != updater.SHA256 {
+		t.Errorf("unexpected matched file %+v", file)
+	}
+	unknown := files["files/0000/unknown.txt"]
+	if unknown == nil || unknown.Report != nil || unknown.Path != "" {
+		t.Fatalf("unexpected unmatched file %+v", unknown)
+	}
+	if expected := testDroppedFile("", "not in the report").SHA256; unknown.SHA256 != expected {
+		t.Errorf("expected the hash of the content %s, got %s", expected, unknown.SHA256)
+	}
+}
+
+func TestDroppedFilesHashMismatch(t *testing.T) {
+	updater := testDroppedFile("files/3c1c6d2b/updater.exe", "MZ updater")
+	c := droppedMockClient(t, map[string]string{"files/3c1c6d2b/updater.exe": "tampered"}, []*DroppedFile{updater})
+
+	dropped, err := c.TasksDroppedFiles(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dropped.Close()
+
+	dir := t.TempDir()
+	_, err = dropped.Quarantine(dir)
+	mismatch := &HashMismatchError{}
+	if !errors.As(err, &mismatch) || mismatch.Expected != updater.SHA256 {
+		t.Fatalf("expected a hash mismatch, got %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected nothing in quarantine, got %d files", len(entries))
+	}
+}
+
+func TestDroppedFilesQuarantine(t *testing.T) {
+	updater := testDroppedFile("files/3c1c6d2b/updater.exe", "MZ updater")
+	c := droppedMockClient(t, map[string]string{
+		"files/3c1c6d2b/updater.exe": "MZ updater",
+		"../../etc/evil.sh":          "#!/bin/sh",
+	}, []*DroppedFile{updater})
+
+	dropped, err := c.TasksDroppedFiles(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dropped.Close()
+
+	dir := t.TempDir()
+	quarantined, err := dropped.Quarantine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(quarantined))
+	}
+
+	for _, q := range quarantined {
+		if filepath.Dir(q.QuarantinePath) != dir || filepath.Base(q.QuarantinePath) != q.SHA256+".bin" {
+			t.Errorf("unexpected quarantine path %s", q.QuarantinePath)
+		}
+		info, err := os.Stat(q.QuarantinePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0400 {
+			t.Errorf("expected a read only file, got %v", info.Mode())
+		}
+	}
+
+	metadata, err := ioutil.ReadFile(filepath.Join(dir, updater.SHA256+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &QuarantinedFile{}
+	if err := json.Unmarshal(metadata, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Path != updater.Filepath || decoded.Size != 10 || fmt.Sprint(decoded.PIDs) != "[2048]" {
+		t.Errorf("unexpected metadata %+v", decoded)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("expected 2 files and their metadata, got %d entries", len(entries))
+	}
+}
+
+func TestDroppedFilesQuarantineWithoutHashes(t *testing.T) {
+	first := testDroppedFile("files/1111/first.exe", "MZ first")
+	second := testDroppedFile("files/2222/second.exe", "MZ second")
+	for _, file := range []*DroppedFile{first, second} {
+		file.MD5, file.SHA1, file.SHA256 = "", "", ""
+	}
+	c := droppedMockClient(t, map[string]string{
+		"files/1111/first.exe":  "MZ first",
+		"files/2222/second.exe": "MZ second",
+	}, []*DroppedFile{first, second})
+
+	dropped, err := c.TasksDroppedFiles(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dropped.Close()
+
+	dir := t.TempDir()
+	quarantined, err := dropped.Quarantine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(quarantined))
+	}
+	byName := map[string]*QuarantinedFile{}
+	for _, q := range quarantined {
+		byName[q.Name] = q
+	}
+	for name, content := range map[string]string{"files/1111/first.exe": "MZ first", "files/2222/second.exe": "MZ second"} {
+		expected := testDroppedFile("", content)
+		q := byName[name]
+		if q == nil {
+			t.Fatalf("%s was not quarantined", name)
+		}
+		if q.SHA256 != expected.SHA256 || q.MD5 != expected.MD5 || filepath.Base(q.QuarantinePath) != expected.SHA256+".bin" {
+			t.Errorf("unexpected quarantined file %+v", q)
+		}
+		if data, err := ioutil.ReadFile(q.QuarantinePath); err != nil || string(data) != content {
+			t.Errorf("unexpected content %q %v", data, err)
+		}
+	}
+}
@@ -75,6 +75,13 @@ func (c *Client) FilesView(ctx context.Context, fileID *FileID) (*Sample, error)
 
 // FilesGet Returns the binary content of the file matching the specified SHA256 hash.
 func (c *Client) FilesGet(ctx context.Context, sha256 string) (io.ReadCloser, error) {
+	cacheName, cacheable := fileCacheName(sha256)
+	if cacheable {
+		if cached, ok := c.Cache.get(cacheName); ok {
+			return cached, nil
+		}
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/files/get/%s", c.BaseURL, sha256), nil)
 	if err != nil {
 		return nil, err
@@ -93,5 +100,9 @@ func (c *Client) FilesGet(ctx context.Context, sha256 string) (io.ReadCloser, er
 		return nil, fmt.Errorf("bad response code: %d", resp.StatusCode)
 	}
 
+	if cacheable {
+		// The content must match its hash to be cached
+		return c.Cache.put(cacheName, -1, resp.Body, sha256), nil
+	}
 	return resp.Body, nil
 }
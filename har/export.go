@@ -0,0 +1,523 @@
+package har
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/binfile"
+	"github.com/godaddy/go-cukoo/pcap"
+)
+
+const (
+	creatorName = "go-cuckoo"
+	// creatorVersion is the version of the exporter, bumped when the entries it writes change
+	creatorVersion = "1.0"
+
+	defaultMaxBodySize   = 1024 * 1024
+	defaultMaxStreamSize = 64 * 1024 * 1024
+)
+
+// Options of the export
+type Options struct {
+	// Machines whose resultserver traffic is left out, see pcap.Summarize
+	pcap.Options
+
+	// Bodies larger than this are left out of the archive, defaults to 1MB.  Negative leaves every body out.
+	MaxBodySize int
+	// Bytes kept of each direction of a connection, defaults to 64MB.  Decoded bodies are capped to it too.
+	MaxStreamSize int
+	// Optional, directory the response bodies are carved out into.  Each body is written once, decoded, as
+	// "<sha256>.bin" and its content is annotated with the path and hashes of the file.
+	CarveDir string
+}
+
+// Export Reads a capture and rebuilds its HTTP/1.x requests and responses.
+//
+// Connections are recognized by their content so HTTP on any port is found.  Chunked bodies are reassembled and
+// gzip and deflate bodies are decoded.  Entries are sorted by the time their request started.
+func Export(r io.Reader, opts *Options) (*HAR, error) {
+	e := newExporter(opts)
+	if err := pcap.Assemble(r, &e.opts.Options, e); err != nil {
+		return nil, err
+	}
+	return e.archive()
+}
+
+// ExportTask Downloads the capture of the specified task ID with pcap.AssembleTask and rebuilds its HTTP traffic,
+// see Export.
+func ExportTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*HAR, error) {
+	e := newExporter(opts)
+	if err := pcap.AssembleTask(ctx, c, taskID, &e.opts.Options, e); err != nil {
+		return nil, err
+	}
+	return e.archive()
+}
+
+// exporter is the pcap.StreamHandler collecting the HTTP connections of a capture
+type exporter struct {
+	opts    Options
+	conns   map[*pcap.Conn]*httpConn
+	entries []*Entry
+	// First error carving a body
+	err error
+}
+
+// httpConn holds both directions of a connection until it is closed
+type httpConn struct {
+	streams [2]*stream
+}
+
+// stream is the data of a direction of a connection, with the time each part of it was captured
+type stream struct {
+	data      []byte
+	chunks    []*chunk
+	truncated bool
+}
+
+type chunk struct {
+	offset    int
+	timestamp time.Time
+}
+
+func newExporter(opts *Options) *exporter {
+	e := &exporter{conns: map[*pcap.Conn]*httpConn{}, entries: []*Entry{}}
+	if opts != nil {
+		e.opts = *opts
+	}
+	if e.opts.MaxBodySize == 0 {
+		e.opts.MaxBodySize = defaultMaxBodySize
+	}
+	if e.opts.MaxStreamSize <= 0 {
+		e.opts.MaxStreamSize = defaultMaxStreamSize
+	}
+	return e
+}
+
+func (e *exporter) archive() (*HAR, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	sort.SliceStable(e.entries, func(i, j int) bool {
+		return e.entries[i].StartedDateTime.Before(e.entries[j].StartedDateTime)
+	})
+	return &HAR{Log: &Log{
+		Version: Version,
+		Creator: &Creator{Name: creatorName, Version: creatorVersion},
+		Entries: e.entries,
+	}}, nil
+}
+
+func (e *exporter) Data(conn *pcap.Conn, direction pcap.Direction, data []byte, timestamp time.Time) {
+	c, ok := e.conns[conn]
+	if !ok {
+		// Only follow connections whose client starts with a request line
+		if direction != pcap.ClientToServer || !isRequestLine(data) {
+			e.conns[conn] = nil
+			return
+		}
+		c = &httpConn{streams: [2]*stream{{}, {}}}
+		e.conns[conn] = c
+	}
+	if c == nil {
+		return
+	}
+
+	s := c.streams[direction]
+	if left := e.opts.MaxStreamSize - len(s.data); len(data) > left {
+		s.truncated = true
+		data = data[:left]
+	}
+	if len(data) == 0 {
+		return
+	}
+	s.chunks = append(s.chunks, &chunk{offset: len(s.data), timestamp: timestamp})
+	s.data = append(s.data, data...)
+}
+
+func (e *exporter) Close(conn *pcap.Conn) {
+	c := e.conns[conn]
+	delete(e.conns, conn)
+	if c != nil {
+		e.parse(conn, c)
+	}
+}
+
+// isRequestLine returns true if data starts like an HTTP request line
+func isRequestLine(data []byte) bool {
+	for i, b := range data {
+		if b == ' ' {
+			return i > 0
+		}
+		if b < 'A' || b > 'Z' || i >= 16 {
+			return false
+		}
+	}
+	return len(data) > 0
+}
+
+// timeAt returns when the byte at offset was captured
+func (s *stream) timeAt(offset int) time.Time {
+	i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].offset > offset })
+	if i == 0 {
+		i = 1
+	}
+	return s.chunks[i-1].timestamp
+}
+
+// messageReader reads the messages of a stream, keeping track of where they are
+type messageReader struct {
+	r  *bytes.Reader
+	br *bufio.Reader
+}
+
+func newMessageReader(data []byte) *messageReader {
+	r := bytes.NewReader(data)
+	return &messageReader{r: r, br: bufio.NewReader(r)}
+}
+
+// offset returns the offset of the next byte the messages will read
+func (m *messageReader) offset() int {
+	return int(m.r.Size()) - m.r.Len() - m.br.Buffered()
+}
+
+func (m *messageReader) more() bool {
+	_, err := m.br.Peek(1)
+	return err == nil
+}
+
+// parse pairs the requests of a connection with its responses, in order
+func (e *exporter) parse(conn *pcap.Conn, c *httpConn) {
+	client, server := c.streams[pcap.ClientToServer], c.streams[pcap.ServerToClient]
+	requests, responses := newMessageReader(client.data), newMessageReader(server.data)
+
+	for requests.more() {
+		start := requests.offset()
+		req, err := http.ReadRequest(requests.br)
+		if err != nil {
+			return
+		}
+		headersEnd := requests.offset()
+		body, bodyErr := ioutil.ReadAll(req.Body)
+		end := requests.offset()
+
+		entry := &Entry{
+			StartedDateTime: client.timeAt(start),
+			Request:         e.request(req, conn.Server, body, headersEnd-start, end-headersEnd),
+			Cache:           &Cache{},
+			Timings:         &Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+			Connection:      conn.Client.String(),
+			ServerIPAddress: conn.Server.IP,
+		}
+		if bodyErr != nil {
+			entry.Request.Comment = "body truncated"
+		}
+		sent := client.timeAt(end - 1)
+		entry.Timings.Send = milliseconds(sent.Sub(entry.StartedDateTime))
+
+		// Interim responses come before the final one
+		var resp *http.Response
+		var respStart int
+		for responses.more() {
+			respStart = responses.offset()
+			resp, err = http.ReadResponse(responses.br, req)
+			if err != nil {
+				resp = nil
+				break
+			}
+			if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+				break
+			}
+			resp = nil
+		}
+		if resp == nil {
+			entry.Response = noResponse()
+			entry.Time = entry.Timings.Send
+			e.entries = append(e.entries, entry)
+			continue
+		}
+
+		respHeadersEnd := responses.offset()
+		respBody, respBodyErr := ioutil.ReadAll(resp.Body)
+		respEnd := responses.offset()
+		entry.Response = e.response(resp, respBody, respHeadersEnd-respStart, respEnd-respHeadersEnd)
+		if respBodyErr != nil {
+			entry.Response.Comment = "body truncated"
+		}
+
+		received := server.timeAt(respStart)
+		entry.Timings.Wait = milliseconds(received.Sub(sent))
+		if respEnd > respStart {
+			entry.Timings.Receive = milliseconds(server.timeAt(respEnd - 1).Sub(received))
+		}
+		entry.Time = entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+		e.entries = append(e.entries, entry)
+
+		// What follows is not HTTP anymore
+		if req.Method == http.MethodConnect || resp.StatusCode == http.StatusSwitchingProtocols {
+			return
+		}
+	}
+}
+
+func (e *exporter) request(req *http.Request, server pcap.Endpoint, body []byte, headersSize, bodySize int) *Request {
+	// The parser moves these headers out of req.Header
+	header := req.Header.Clone()
+	if req.Host != "" {
+		header.Set("Host", req.Host)
+	}
+	if len(req.TransferEncoding) > 0 {
+		header.Set("Transfer-Encoding", strings.Join(req.TransferEncoding, ", "))
+	}
+
+	r := &Request{
+		Method:      req.Method,
+		URL:         requestURL(req, server),
+		HTTPVersion: req.Proto,
+		Cookies:     []*Cookie{},
+		Headers:     headers(header),
+		QueryString: queryString(req.URL.RawQuery),
+		HeadersSize: int64(headersSize),
+		BodySize:    int64(bodySize),
+	}
+	for _, cookie := range req.Cookies() {
+		r.Cookies = append(r.Cookies, &Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	if len(body) > 0 {
+		r.PostData = &PostData{MimeType: header.Get("Content-Type")}
+		if e.opts.MaxBodySize >= 0 && len(body) <= e.opts.MaxBodySize {
+			r.PostData.Text, r.PostData.Encoding = bodyText(r.PostData.MimeType, body)
+		}
+	}
+	return r
+}
+
+func (e *exporter) response(resp *http.Response, body []byte, headersSize, bodySize int) *Response {
+	header := resp.Header.Clone()
+	if len(resp.TransferEncoding) > 0 {
+		header.Set("Transfer-Encoding", strings.Join(resp.TransferEncoding, ", "))
+	}
+
+	r := &Response{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []*Cookie{},
+		Headers:     headers(header),
+		RedirectURL: header.Get("Location"),
+		HeadersSize: int64(headersSize),
+		BodySize:    int64(bodySize),
+	}
+	for _, cookie := range resp.Cookies() {
+		c := &Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HTTPOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			expires := cookie.Expires
+			c.Expires = &expires
+		}
+		r.Cookies = append(r.Cookies, c)
+	}
+
+	decoded, err := e.decodeBody(header.Get("Content-Encoding"), body)
+	content := &Content{Size: int64(len(decoded)), MimeType: header.Get("Content-Type")}
+	if err != nil {
+		content.Comment = fmt.Sprintf("body left encoded: %s", err)
+	}
+	if saved := len(decoded) - len(body); err == nil && saved > 0 {
+		content.Compression = int64(saved)
+	}
+	if content.MimeType == "" && len(decoded) > 0 {
+		content.MimeType = http.DetectContentType(decoded)
+	}
+
+	switch {
+	case len(decoded) == 0:
+	case e.opts.MaxBodySize >= 0 && len(decoded) <= e.opts.MaxBodySize:
+		content.Text, content.Encoding = bodyText(content.MimeType, decoded)
+	default:
+		content.Comment = fmt.Sprintf("body of %d bytes left out", len(decoded))
+	}
+
+	if e.opts.CarveDir != "" && len(decoded) > 0 {
+		if err := e.carve(content, decoded); err != nil && e.err == nil {
+			e.err = fmt.Errorf("error carving response body: %w", err)
+		}
+	}
+
+	r.Content = content
+	return r
+}
+
+// noResponse is the response of a request that was not answered in the capture
+func noResponse() *Response {
+	return &Response{
+		Cookies:     []*Cookie{},
+		Headers:     []*NameValue{},
+		Content:     &Content{MimeType: "x-unknown"},
+		HeadersSize: -1,
+		BodySize:    -1,
+		Comment:     "no response captured",
+	}
+}
+
+// decodeBody undoes the content encoding of a body, returning the body as is if it can't
+func (e *exporter) decodeBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return body, err
+		}
+		r = gz
+	case "deflate":
+		// Servers send both zlib wrapped and raw deflate
+		z, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			r = flate.NewReader(bytes.NewReader(body))
+		} else {
+			r = z
+		}
+	default:
+		return body, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(r, int64(e.opts.MaxStreamSize)))
+	if err != nil {
+		return body, err
+	}
+	return decoded, nil
+}
+
+// carve writes a body into the carve directory
+func (e *exporter) carve(content *Content, body []byte) error {
+	md5Sum := md5.Sum(body)
+	sha1Sum := sha1.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	content.MD5 = hex.EncodeToString(md5Sum[:])
+	content.SHA1 = hex.EncodeToString(sha1Sum[:])
+	content.SHA256 = hex.EncodeToString(sha256Sum[:])
+
+	var err error
+	content.File, err = binfile.Write(e.opts.CarveDir, content.SHA256, body)
+	return err
+}
+
+// requestURL returns the absolute URL of a request
+func requestURL(req *http.Request, server pcap.Endpoint) string {
+	if req.Method == http.MethodConnect {
+		return req.RequestURI
+	}
+	if req.URL.IsAbs() {
+		// Sent to a proxy
+		return req.URL.String()
+	}
+
+	host := req.Host
+	if host == "" {
+		host = server.String()
+	} else if _, _, err := net.SplitHostPort(host); err != nil && server.Port != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(server.Port))
+	}
+	return "http://" + host + req.URL.RequestURI()
+}
+
+// headers returns the headers sorted by name
+func headers(header http.Header) []*NameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := []*NameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			values = append(values, &NameValue{Name: name, Value: value})
+		}
+	}
+	return values
+}
+
+// queryString returns the parameters of a query in order
+func queryString(rawQuery string) []*NameValue {
+	params := []*NameValue{}
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, value := param, ""
+		if i := strings.Index(param, "="); i >= 0 {
+			name, value = param[:i], param[i+1:]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		params = append(params, &NameValue{Name: name, Value: value})
+	}
+	return params
+}
+
+// bodyText returns a body as text, or base64 encoded with "base64" if it is not text
+func bodyText(mimeType string, body []byte) (string, string) {
+	if isText(mimeType) && utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func isText(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/x-javascript", "application/xml",
+		"application/x-www-form-urlencoded", "image/svg+xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func milliseconds(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d) / float64(time.Millisecond)
+}
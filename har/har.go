@@ -0,0 +1,143 @@
+// Package har rebuilds the HTTP traffic of cuckoo analyses from their packet captures and exports it as HAR 1.2,
+// the format browser devtools and proxy tools import.
+//
+// See http://www.softwareishard.com/blog/har-12-spec/ for the format.  Fields starting with an underscore are
+// custom fields added by this package.
+package har
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Version of the HAR format written
+const Version = "1.2"
+
+// HAR is an HTTP archive
+type HAR struct {
+	Log *Log `json:"log"`
+}
+
+// Log is the root of an HTTP archive
+type Log struct {
+	Version string   `json:"version"`
+	Creator *Creator `json:"creator"`
+	Entries []*Entry `json:"entries"`
+	Comment string   `json:"comment,omitempty"`
+}
+
+// Creator is the application that wrote the archive
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is a request and its response
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Total time of the request in milliseconds
+	Time     float64   `json:"time"`
+	Request  *Request  `json:"request"`
+	Response *Response `json:"response"`
+	Cache    *Cache    `json:"cache"`
+	Timings  *Timings  `json:"timings"`
+	// Address and port of the client of the TCP connection, the same for requests sharing a connection
+	Connection      string `json:"connection,omitempty"`
+	ServerIPAddress string `json:"serverIPAddress,omitempty"`
+	Comment         string `json:"comment,omitempty"`
+}
+
+// Request is an HTTP request
+type Request struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []*Cookie    `json:"cookies"`
+	Headers     []*NameValue `json:"headers"`
+	QueryString []*NameValue `json:"queryString"`
+	PostData    *PostData    `json:"postData,omitempty"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+	Comment     string       `json:"comment,omitempty"`
+}
+
+// Response is an HTTP response, its status is 0 if it was not captured
+type Response struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []*Cookie    `json:"cookies"`
+	Headers     []*NameValue `json:"headers"`
+	Content     *Content     `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+	Comment     string       `json:"comment,omitempty"`
+}
+
+// NameValue is a header or a query string parameter
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Cookie is a cookie sent with a request or set by a response
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+// PostData is the body of a request
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// "base64" when the body is not text
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+// Content is the body of a response, decoded from its transfer and content encodings
+type Content struct {
+	// Size of the decoded body
+	Size int64 `json:"size"`
+	// Bytes saved by the content encoding
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	// The body, left out when it is larger than Options.MaxBodySize
+	Text string `json:"text,omitempty"`
+	// "base64" when the body is not text
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+
+	// Set when the body was carved out, see Options.CarveDir
+	File   string `json:"_file,omitempty"`
+	MD5    string `json:"_md5,omitempty"`
+	SHA1   string `json:"_sha1,omitempty"`
+	SHA256 string `json:"_sha256,omitempty"`
+}
+
+// Cache is the browser cache state, always empty for captured traffic
+type Cache struct{}
+
+// Timings are the phases of a request in milliseconds, -1 when they do not apply
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// Write writes the archive as indented JSON
+func (h *HAR) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(h)
+}
@@ -0,0 +1,67 @@
+// Package binfile writes samples to disk as read only "<sha256>.bin" files, so they cannot be run by mistake, that
+// only appear once fully written
+package binfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// File is a temporary file in a directory, moved to its final name by Commit
+type File struct {
+	*os.File
+	dir string
+}
+
+// Create Creates a temporary file in dir, creating dir if needed.  The file must be committed or discarded.
+func Create(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: tmp, dir: dir}, nil
+}
+
+// Commit Closes the file and makes it read only as "<sha256>.bin", returning its path.  A file already there is
+// kept as is.
+func (f *File) Commit(sha256 string) (string, error) {
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	path := filepath.Join(f.dir, sha256+".bin")
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return path, err
+	}
+	if err := os.Chmod(f.Name(), 0400); err != nil {
+		return "", err
+	}
+	return path, os.Rename(f.Name(), path)
+}
+
+// Discard Removes the file if it was not committed
+func (f *File) Discard() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// Write Writes data into dir as "<sha256>.bin" and returns its path, see Commit
+func Write(dir, sha256 string, data []byte) (string, error) {
+	path := filepath.Join(dir, sha256+".bin")
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return path, err
+	}
+
+	f, err := Create(dir)
+	if err != nil {
+		return "", err
+	}
+	defer f.Discard()
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+	return f.Commit(sha256)
+}
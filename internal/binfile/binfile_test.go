@@ -0,0 +1,55 @@
+package binfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "samples")
+
+	path, err := Write(dir, "abc", []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "abc.bin") {
+		t.Errorf("unexpected path %s", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0400 {
+		t.Errorf("file is not read only: %v", info.Mode())
+	}
+
+	// Files already there are kept
+	if _, err := Write(dir, "abc", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "first" {
+		t.Errorf("file was overwritten with %q", data)
+	}
+
+	// Nothing else is left behind
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only abc.bin, got %d files", len(entries))
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("partial"))
+	f.Discard()
+
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("discarded file was left behind")
+	}
+}
@@ -0,0 +1,31 @@
+// Package reporttime picks the time an analysis is exported at, shared by the exports so they agree
+package reporttime
+
+import (
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// taskTimeLayout is the layout of the times of tasks
+const taskTimeLayout = "2006-01-02 15:04:05"
+
+// Analysis Returns a stable time for the analysis of a task: the end of the analysis, else when the task completed,
+// else when it was added, else the unix epoch.  It never depends on the current time, so exporting a task again
+// gives the same result.
+func Analysis(task *cuckoo.Task, report *cuckoo.Report) time.Time {
+	if report != nil && report.Info != nil && !report.Info.Ended.IsZero() {
+		return report.Info.Ended.Time
+	}
+	if task != nil {
+		if completed, ok := task.CompletedOn.(string); ok {
+			if parsed, err := time.Parse(taskTimeLayout, completed); err == nil {
+				return parsed
+			}
+		}
+		if parsed, err := time.Parse(taskTimeLayout, task.AddedOn); err == nil {
+			return parsed
+		}
+	}
+	return time.Unix(0, 0).UTC()
+}
@@ -0,0 +1,30 @@
+package reporttime
+
+import (
+	"testing"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+func TestAnalysis(t *testing.T) {
+	ended := time.Date(2020, 2, 11, 16, 48, 35, 0, time.UTC)
+	report := &cuckoo.Report{Info: &cuckoo.ReportInfo{Ended: cuckoo.Timestamp{Time: ended}}}
+	task := &cuckoo.Task{AddedOn: "2020-02-11 16:40:00", CompletedOn: "2020-02-11 16:50:00"}
+
+	for _, test := range []struct {
+		task     *cuckoo.Task
+		report   *cuckoo.Report
+		expected time.Time
+	}{
+		{task, report, ended},
+		{task, &cuckoo.Report{}, time.Date(2020, 2, 11, 16, 50, 0, 0, time.UTC)},
+		{&cuckoo.Task{AddedOn: "2020-02-11 16:40:00"}, &cuckoo.Report{}, time.Date(2020, 2, 11, 16, 40, 0, 0, time.UTC)},
+		{&cuckoo.Task{}, &cuckoo.Report{}, time.Unix(0, 0)},
+		{nil, nil, time.Unix(0, 0)},
+	} {
+		if got := Analysis(test.task, test.report); !got.Equal(test.expected) {
+			t.Errorf("expected %v, got %v", test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package testutil holds the fixtures and mocks shared by the tests of the subpackages
+package testutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// LoadReport Returns the report of testdata/report.json at the root of the module
+func LoadReport(t *testing.T) *cuckoo.Report {
+	_, file, _, _ := runtime.Caller(0)
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "testdata", "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := &cuckoo.Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// MockClient Returns a client of a test server calling handler, the server is closed at the end of the test
+func MockClient(t *testing.T, handler http.HandlerFunc) *cuckoo.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return cuckoo.New(&cuckoo.Config{BaseURL: server.URL})
+}
+
+// ServeMachine Serves the view of the machine win7-1 the test tasks ran on, it returns false for other requests
+func ServeMachine(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != "/machines/view/win7-1" {
+		return false
+	}
+	fmt.Fprint(w, `{"machine": {"name": "win7-1", "ip": "192.168.56.101", "resultserver_ip": "192.168.56.1", "resultserver_port": "2042"}}`)
+	return true
+}
+
+// WriteSlowly Writes data in two halves, pausing for delay in between
+func WriteSlowly(w http.ResponseWriter, data []byte, delay time.Duration) {
+	w.Write(data[:len(data)/2])
+	w.(http.Flusher).Flush()
+	time.Sleep(delay)
+	w.Write(data[len(data)/2:])
+}
+
+// Dump Returns a memory dump of size bytes of fill, with parts copied at their offsets
+func Dump(size int, fill byte, parts map[int][]byte) []byte {
+	dump := bytes.Repeat([]byte{fill}, size)
+	for offset, data := range parts {
+		copy(dump[offset:], data)
+	}
+	return dump
+}
+
+// SHA256Hex Returns the hex encoded SHA256 of data
+func SHA256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,41 @@
+// Package uuid generates the name based UUIDs used for deterministic identifiers in exports
+package uuid
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UUID is an RFC 4122 UUID
+type UUID [16]byte
+
+// MustParse parses a UUID in its canonical form and panics if it is invalid.  It is meant for constants.
+func MustParse(s string) UUID {
+	var u UUID
+	decoded, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(decoded) != len(u) || len(s) != 36 {
+		panic(fmt.Sprintf("uuid: invalid uuid %q", s))
+	}
+	copy(u[:], decoded)
+	return u
+}
+
+// V5 returns the name based UUID (version 5) of name within namespace
+func V5(namespace UUID, name string) UUID {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+
+	var u UUID
+	copy(u[:], h.Sum(nil))
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+// String returns the canonical form of the UUID
+func (u UUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
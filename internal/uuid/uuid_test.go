@@ -0,0 +1,14 @@
+package uuid
+
+import "testing"
+
+func TestV5(t *testing.T) {
+	// Checked against python's uuid.uuid5
+	namespace := MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+	if namespace.String() != "00abedb4-aa42-466c-9c01-fed23315a9b7" {
+		t.Errorf("namespace did not round trip: %s", namespace)
+	}
+	if u := V5(namespace, `{"value":"185.100.87.202"}`).String(); u != "346d0d43-03f7-5188-a697-debbcc8eb06f" {
+		t.Errorf("unexpected uuid %s", u)
+	}
+}
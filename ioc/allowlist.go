@@ -0,0 +1,234 @@
+package ioc
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// Allowlist is a list of values that are never reported as indicators.
+//
+// It can be loaded from JSON with LoadAllowlist.
+type Allowlist struct {
+	// IPs or CIDR ranges
+	IPs []string `json:"ips"`
+	// Domains, their subdomains are allowed as well
+	Domains []string `json:"domains"`
+	// Leftmost labels of domains allowed under any parent, e.g. "wpad" allows wpad.localdomain
+	DomainLabels []string `json:"domain_labels"`
+	// URL prefixes.  URLs on an allowed domain are dropped too
+	URLs []string `json:"urls"`
+	// Exact user agents
+	UserAgents []string `json:"user_agents"`
+	// MD5, SHA1 or SHA256 hashes of dropped files
+	Hashes []string `json:"hashes"`
+	// Exact mutex names, case insensitive
+	Mutexes []string `json:"mutexes"`
+	// Registry key prefixes, case insensitive
+	RegistryKeys []string `json:"registry_keys"`
+	// File path prefixes, case insensitive
+	Files []string `json:"files"`
+	// Process names, case insensitive
+	Processes []string `json:"processes"`
+}
+
+// DefaultAllowlist Returns the background noise of a typical windows analysis machine
+func DefaultAllowlist() *Allowlist {
+	return &Allowlist{
+		IPs: []string{"255.255.255.255", "239.255.255.250"},
+		Domains: []string{
+			"msftncsi.com",
+			"teredo.ipv6.microsoft.com",
+			"time.windows.com",
+			"windowsupdate.com",
+			"update.microsoft.com",
+			"crl.microsoft.com",
+			"ctldl.windowsupdate.com",
+		},
+		// Proxy auto-discovery under the search domains of the machine
+		DomainLabels: []string{"wpad"},
+		Mutexes: []string{
+			"Global\\ZonesCacheCounterMutex",
+			"Global\\ZonesLockedCacheCounterMutex",
+			"Local\\ZonesCacheCounterMutex",
+			"Local\\ZonesLockedCacheCounterMutex",
+			"RasPbFile",
+		},
+		RegistryKeys: []string{
+			"HKEY_CURRENT_USER\\Software\\Microsoft\\Windows\\CurrentVersion\\Internet Settings\\ZoneMap",
+			"HKEY_CURRENT_USER\\Software\\Microsoft\\Windows\\CurrentVersion\\Internet Settings\\Connections",
+			"HKEY_LOCAL_MACHINE\\SOFTWARE\\Microsoft\\Tracing",
+		},
+	}
+}
+
+// LoadAllowlist Decodes an allowlist from JSON
+func LoadAllowlist(r io.Reader) (*Allowlist, error) {
+	allowlist := &Allowlist{}
+	if err := json.NewDecoder(r).Decode(allowlist); err != nil {
+		return nil, err
+	}
+	return allowlist, nil
+}
+
+// Merge returns a new allowlist with the entries of both lists.  other may be nil.
+func (a *Allowlist) Merge(other *Allowlist) *Allowlist {
+	merged := *a
+	if other == nil {
+		return &merged
+	}
+
+	merged.IPs = append(append([]string{}, a.IPs...), other.IPs...)
+	merged.Domains = append(append([]string{}, a.Domains...), other.Domains...)
+	merged.DomainLabels = append(append([]string{}, a.DomainLabels...), other.DomainLabels...)
+	merged.URLs = append(append([]string{}, a.URLs...), other.URLs...)
+	merged.UserAgents = append(append([]string{}, a.UserAgents...), other.UserAgents...)
+	merged.Hashes = append(append([]string{}, a.Hashes...), other.Hashes...)
+	merged.Mutexes = append(append([]string{}, a.Mutexes...), other.Mutexes...)
+	merged.RegistryKeys = append(append([]string{}, a.RegistryKeys...), other.RegistryKeys...)
+	merged.Files = append(append([]string{}, a.Files...), other.Files...)
+	merged.Processes = append(append([]string{}, a.Processes...), other.Processes...)
+	return &merged
+}
+
+// compiledAllowlist is an allowlist prepared for lookups
+type compiledAllowlist struct {
+	ips          []net.IP
+	networks     []*net.IPNet
+	domains      []string
+	domainLabels map[string]bool
+	urls         []string
+	userAgents   map[string]bool
+	hashes       map[string]bool
+	mutexes      map[string]bool
+	registryKeys []string
+	files        []string
+	processes    map[string]bool
+}
+
+func (a *Allowlist) compile() *compiledAllowlist {
+	c := &compiledAllowlist{
+		urls:         a.URLs,
+		domainLabels: map[string]bool{},
+		userAgents:   map[string]bool{},
+		hashes:       map[string]bool{},
+		mutexes:      map[string]bool{},
+		processes:    map[string]bool{},
+	}
+
+	for _, value := range a.IPs {
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			c.networks = append(c.networks, network)
+		} else if ip := net.ParseIP(value); ip != nil {
+			c.ips = append(c.ips, ip)
+		}
+	}
+	for _, domain := range a.Domains {
+		c.domains = append(c.domains, strings.TrimSuffix(strings.ToLower(domain), "."))
+	}
+	for _, label := range a.DomainLabels {
+		c.domainLabels[strings.ToLower(label)] = true
+	}
+	for _, userAgent := range a.UserAgents {
+		c.userAgents[userAgent] = true
+	}
+	for _, hash := range a.Hashes {
+		c.hashes[strings.ToLower(hash)] = true
+	}
+	for _, mutex := range a.Mutexes {
+		c.mutexes[strings.ToLower(mutex)] = true
+	}
+	for _, key := range a.RegistryKeys {
+		c.registryKeys = append(c.registryKeys, strings.ToLower(key))
+	}
+	for _, file := range a.Files {
+		c.files = append(c.files, strings.ToLower(file))
+	}
+	for _, process := range a.Processes {
+		c.processes[strings.ToLower(process)] = true
+	}
+
+	return c
+}
+
+func (c *compiledAllowlist) ip(ip net.IP) bool {
+	for _, allowed := range c.ips {
+		if allowed.Equal(ip) {
+			return true
+		}
+	}
+	for _, network := range c.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledAllowlist) domain(domain string) bool {
+	if i := strings.IndexByte(domain, '.'); i > 0 && c.domainLabels[domain[:i]] {
+		return true
+	}
+	for _, allowed := range c.domains {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledAllowlist) url(u *url.URL) bool {
+	if c.domain(strings.ToLower(u.Hostname())) {
+		return true
+	}
+	for _, prefix := range c.urls {
+		if strings.HasPrefix(u.String(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledAllowlist) userAgent(userAgent string) bool {
+	return c.userAgents[userAgent]
+}
+
+func (c *compiledAllowlist) hash(file *cuckoo.DroppedFile) bool {
+	for _, hash := range []string{file.MD5, file.SHA1, file.SHA256} {
+		if c.hashes[strings.ToLower(hash)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledAllowlist) mutex(mutex string) bool {
+	return c.mutexes[strings.ToLower(mutex)]
+}
+
+func (c *compiledAllowlist) registryKey(key string) bool {
+	return hasPrefixFold(key, c.registryKeys)
+}
+
+func (c *compiledAllowlist) file(path string) bool {
+	return hasPrefixFold(path, c.files)
+}
+
+func (c *compiledAllowlist) process(name string) bool {
+	return c.processes[strings.ToLower(name)]
+}
+
+// hasPrefixFold returns true if value starts with one of the lower cased prefixes, ignoring case
+func hasPrefixFold(value string, prefixes []string) bool {
+	value = strings.ToLower(value)
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,440 @@
+// Package ioc extracts indicators of compromise from cuckoo analysis reports
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// Type is the kind of an indicator
+type Type string
+
+// Indicator types
+const (
+	TypeIP          Type = "ip"
+	TypeDomain      Type = "domain"
+	TypeURL         Type = "url"
+	TypeUserAgent   Type = "user_agent"
+	TypeFile        Type = "file"
+	TypeMutex       Type = "mutex"
+	TypeRegistryKey Type = "registry_key"
+	TypeFilePath    Type = "file_path"
+	TypeProcess     Type = "process"
+)
+
+// IOC is a single indicator, as returned by Set.All
+type IOC struct {
+	Type  Type
+	Value string
+}
+
+// Set is the deduplicated set of indicators found in a report.  Every list is sorted.
+type Set struct {
+	IPs          []string   `json:"ips"`
+	Domains      []string   `json:"domains"`
+	URLs         []string   `json:"urls"`
+	UserAgents   []string   `json:"user_agents"`
+	DroppedFiles []*File    `json:"dropped_files"`
+	Mutexes      []string   `json:"mutexes"`
+	RegistryKeys []string   `json:"registry_keys"`
+	FilesCreated []string   `json:"files_created"`
+	Processes    []*Process `json:"processes"`
+}
+
+// File is a file dropped during the analysis
+type File struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Type   string `json:"type"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
+// Process is a process spawned during the analysis
+type Process struct {
+	PID         int    `json:"pid"`
+	PPID        int    `json:"ppid"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	CommandLine string `json:"command_line"`
+}
+
+// Options control what Extract filters out
+type Options struct {
+	// Analysis machines whose guest and resultserver addresses are sandbox noise
+	Machines []*cuckoo.Machine
+	// Extra indicators to drop, on top of DefaultAllowlist
+	Allowlist *Allowlist
+	// Do not apply DefaultAllowlist
+	NoDefaultAllowlist bool
+}
+
+// Extract Returns the indicators found in report.  opts may be nil to only apply DefaultAllowlist.
+func Extract(report *cuckoo.Report, opts *Options) *Set {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	allowlist := &Allowlist{}
+	if !opts.NoDefaultAllowlist {
+		allowlist = allowlist.Merge(DefaultAllowlist())
+	}
+	allowlist = allowlist.Merge(opts.Allowlist)
+	for _, machine := range opts.Machines {
+		allowlist.IPs = append(allowlist.IPs, machine.IP, machine.ResultserverIP)
+	}
+
+	e := &extractor{
+		allowlist: allowlist.compile(),
+		seen:      map[string]bool{},
+		set: &Set{
+			IPs:          []string{},
+			Domains:      []string{},
+			URLs:         []string{},
+			UserAgents:   []string{},
+			DroppedFiles: []*File{},
+			Mutexes:      []string{},
+			RegistryKeys: []string{},
+			FilesCreated: []string{},
+			Processes:    []*Process{},
+		},
+	}
+
+	if report.Target != nil && report.Target.URL != "" {
+		e.addURL(report.Target.URL)
+	}
+	if report.Network != nil {
+		e.network(report.Network)
+	}
+	if report.Behavior != nil {
+		e.behavior(report.Behavior)
+	}
+	for _, dropped := range report.Dropped {
+		e.addFile(dropped)
+	}
+
+	e.set.sort()
+	return e.set
+}
+
+// ExtractTask Fetches the report of a task with TasksReportParsed and extracts its indicators.
+//
+// The machine the task ran on is looked up so its addresses are filtered out along with opts.Machines.
+func ExtractTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*Set, error) {
+	report, err := c.TasksReportParsed(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err = WithReportMachine(ctx, c, report, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return Extract(report, opts), nil
+}
+
+// WithReportMachine Returns a copy of opts (which may be nil) with the machine the report ran on added
+// to Machines, so its guest and resultserver addresses are filtered out.
+func WithReportMachine(ctx context.Context, c *cuckoo.Client, report *cuckoo.Report, opts *Options) (*Options, error) {
+	withMachine := &Options{}
+	if opts != nil {
+		*withMachine = *opts
+	}
+	if report.Info == nil || report.Info.Machine == nil || report.Info.Machine.Name == "" {
+		return withMachine, nil
+	}
+
+	machine, err := c.MachinesView(ctx, report.Info.Machine.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up machine %s: %w", report.Info.Machine.Name, err)
+	}
+	withMachine.Machines = append([]*cuckoo.Machine{machine}, withMachine.Machines...)
+
+	return withMachine, nil
+}
+
+// TaskData is a task fetched by FetchTask
+type TaskData struct {
+	Task *cuckoo.Task
+	// Sample of a file task, nil for URL tasks
+	Sample *cuckoo.Sample
+	Report *cuckoo.Report
+	// Options given to FetchTask with the machine of the task added, see WithReportMachine
+	Options *Options
+}
+
+// FetchTask Fetches a task, the sample of a file task and the parsed report of the task, for exports needing all
+// three.  opts may be nil.
+func FetchTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*TaskData, error) {
+	task, err := c.TasksView(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sample *cuckoo.Sample
+	if sampleID, ok := task.SampleID.(float64); ok && task.Category != "url" {
+		sample, err = c.FilesView(ctx, &cuckoo.FileID{ID: int(sampleID)})
+		if err != nil {
+			return nil, fmt.Errorf("error getting sample %d: %w", int(sampleID), err)
+		}
+	}
+
+	report, err := c.TasksReportParsed(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err = WithReportMachine(ctx, c, report, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TaskData{Task: task, Sample: sample, Report: report, Options: opts}, nil
+}
+
+// All returns every indicator of the set as a flat list
+func (s *Set) All() []*IOC {
+	all := []*IOC{}
+	add := func(t Type, values []string) {
+		for _, value := range values {
+			all = append(all, &IOC{Type: t, Value: value})
+		}
+	}
+
+	add(TypeIP, s.IPs)
+	add(TypeDomain, s.Domains)
+	add(TypeURL, s.URLs)
+	add(TypeUserAgent, s.UserAgents)
+	for _, file := range s.DroppedFiles {
+		all = append(all, &IOC{Type: TypeFile, Value: file.SHA256})
+	}
+	add(TypeMutex, s.Mutexes)
+	add(TypeRegistryKey, s.RegistryKeys)
+	add(TypeFilePath, s.FilesCreated)
+	for _, process := range s.Processes {
+		all = append(all, &IOC{Type: TypeProcess, Value: process.CommandLine})
+	}
+
+	return all
+}
+
+// sort orders every list of the set
+func (s *Set) sort() {
+	for _, list := range [][]string{s.IPs, s.Domains, s.URLs, s.UserAgents, s.Mutexes, s.RegistryKeys, s.FilesCreated} {
+		sort.Strings(list)
+	}
+	sort.Slice(s.DroppedFiles, func(i, j int) bool { return s.DroppedFiles[i].SHA256 < s.DroppedFiles[j].SHA256 })
+	sort.Slice(s.Processes, func(i, j int) bool { return s.Processes[i].PID < s.Processes[j].PID })
+}
+
+// extractor collects indicators into a set, dropping duplicates and allowlisted values
+type extractor struct {
+	allowlist *compiledAllowlist
+	seen      map[string]bool
+	set       *Set
+}
+
+// first returns true the first time a value of the given type is seen
+func (e *extractor) first(t Type, value string) bool {
+	key := string(t) + "\x00" + value
+	if e.seen[key] {
+		return false
+	}
+	e.seen[key] = true
+	return true
+}
+
+func (e *extractor) network(network *cuckoo.Network) {
+	// Addresses only reached through an allowed domain are noise as well
+	for _, domain := range network.Domains {
+		if e.allowlist.domain(strings.ToLower(domain.Domain)) {
+			e.allowlist.ips = append(e.allowlist.ips, net.ParseIP(domain.IP))
+		}
+	}
+	for _, request := range network.DNS {
+		if !e.allowlist.domain(strings.ToLower(request.Request)) {
+			continue
+		}
+		for _, answer := range request.Answers {
+			if ip := net.ParseIP(answer.Data); ip != nil {
+				e.allowlist.ips = append(e.allowlist.ips, ip)
+			}
+		}
+	}
+
+	for _, host := range network.Hosts {
+		e.addIP(host.IP)
+	}
+	for _, domain := range network.Domains {
+		e.addDomain(domain.Domain)
+		e.addIP(domain.IP)
+	}
+	for _, request := range network.DNS {
+		e.addDomain(request.Request)
+		for _, answer := range request.Answers {
+			switch answer.Type {
+			case "A", "AAAA":
+				e.addIP(answer.Data)
+			case "CNAME":
+				e.addDomain(answer.Data)
+			}
+		}
+	}
+	for _, connections := range [][]*cuckoo.Connection{network.TCP, network.UDP} {
+		for _, connection := range connections {
+			e.addIP(connection.Dst)
+		}
+	}
+	for _, request := range network.HTTP {
+		e.addHost(request.Host)
+		e.addURL(request.URI)
+		e.addUserAgent(request.UserAgent)
+	}
+	for _, requests := range [][]*cuckoo.HTTPEx{network.HTTPEx, network.HTTPSEx} {
+		for _, request := range requests {
+			e.addHost(request.Host)
+			e.addIP(request.Dst)
+			if request.Host != "" && request.Protocol != "" {
+				e.addURL(fmt.Sprintf("%s://%s%s", request.Protocol, request.Host, request.URI))
+			}
+		}
+	}
+}
+
+func (e *extractor) behavior(behavior *cuckoo.Behavior) {
+	if summary := behavior.Summary; summary != nil {
+		for _, ip := range summary.ConnectsIP {
+			e.addIP(ip)
+		}
+		for _, hosts := range [][]string{summary.ResolvesHost, summary.ConnectsHost} {
+			for _, host := range hosts {
+				e.addHost(host)
+			}
+		}
+		for _, u := range summary.FetchesURL {
+			e.addURL(u)
+		}
+		for _, mutex := range summary.Mutex {
+			e.addString(TypeMutex, mutex, &e.set.Mutexes, e.allowlist.mutex)
+		}
+		for _, key := range summary.RegkeyWritten {
+			e.addString(TypeRegistryKey, key, &e.set.RegistryKeys, e.allowlist.registryKey)
+		}
+		for _, path := range summary.FileCreated {
+			e.addString(TypeFilePath, path, &e.set.FilesCreated, e.allowlist.file)
+		}
+	}
+
+	for _, process := range behavior.Processes {
+		if e.allowlist.process(process.ProcessName) || !e.first(TypeProcess, fmt.Sprint(process.PID)) {
+			continue
+		}
+		e.set.Processes = append(e.set.Processes, &Process{
+			PID:         process.PID,
+			PPID:        process.PPID,
+			Name:        process.ProcessName,
+			Path:        process.ProcessPath,
+			CommandLine: process.CommandLine,
+		})
+	}
+}
+
+// addString adds a case insensitive windows value such as a mutex or registry key
+func (e *extractor) addString(t Type, value string, list *[]string, allowed func(string) bool) {
+	if value == "" || allowed(value) || !e.first(t, strings.ToLower(value)) {
+		return
+	}
+	*list = append(*list, value)
+}
+
+func (e *extractor) addIP(value string) {
+	ip := net.ParseIP(value)
+	if ip == nil || isNoiseIP(ip) || e.allowlist.ip(ip) || !e.first(TypeIP, ip.String()) {
+		return
+	}
+	e.set.IPs = append(e.set.IPs, ip.String())
+}
+
+// addHost adds a host that is either an IP or a domain, with an optional port
+func (e *extractor) addHost(host string) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		e.addIP(host)
+		return
+	}
+	e.addDomain(host)
+}
+
+func (e *extractor) addDomain(value string) {
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), ".")
+	if domain == "" || !strings.Contains(domain, ".") || net.ParseIP(domain) != nil {
+		return
+	}
+	if e.allowlist.domain(domain) || !e.first(TypeDomain, domain) {
+		return
+	}
+	e.set.Domains = append(e.set.Domains, domain)
+}
+
+func (e *extractor) addURL(value string) {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil || parsed.Host == "" {
+		return
+	}
+	if e.allowlist.url(parsed) {
+		return
+	}
+
+	// Record the host of the URL as well
+	e.addHost(parsed.Host)
+
+	parsed.Host = strings.ToLower(parsed.Host)
+	normalized := parsed.String()
+	if !e.first(TypeURL, normalized) {
+		return
+	}
+	e.set.URLs = append(e.set.URLs, normalized)
+}
+
+func (e *extractor) addUserAgent(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" || e.allowlist.userAgent(value) || !e.first(TypeUserAgent, value) {
+		return
+	}
+	e.set.UserAgents = append(e.set.UserAgents, value)
+}
+
+func (e *extractor) addFile(dropped *cuckoo.DroppedFile) {
+	hash := strings.ToLower(dropped.SHA256)
+	if hash == "" || e.allowlist.hash(dropped) || !e.first(TypeFile, hash) {
+		return
+	}
+	e.set.DroppedFiles = append(e.set.DroppedFiles, &File{
+		Name:   dropped.Name,
+		Path:   dropped.Filepath,
+		Size:   dropped.Size,
+		Type:   dropped.Type,
+		MD5:    dropped.MD5,
+		SHA1:   dropped.SHA1,
+		SHA256: hash,
+		SHA512: dropped.SHA512,
+	})
+}
+
+// isNoiseIP returns true for addresses that are never indicators
+func isNoiseIP(ip net.IP) bool {
+	return ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.Equal(net.IPv4bcast)
+}
@@ -0,0 +1,159 @@
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+func TestExtract(t *testing.T) {
+	set := Extract(testutil.LoadReport(t), &Options{
+		Machines: []*cuckoo.Machine{{IP: "192.168.56.101", ResultserverIP: "192.168.56.1"}},
+	})
+
+	expected := map[string][]string{
+		"ips":           {"185.100.87.202", "8.8.8.8"},
+		"domains":       {"evil.example.com"},
+		"urls":          {"http://evil.example.com/gate.php"},
+		"user agents":   {"Mozilla/4.0 (compatible; MSIE 8.0)"},
+		"mutexes":       {"Local\\zeus_1234"},
+		"registry keys": {"HKEY_CURRENT_USER\\Software\\Microsoft\\Windows\\CurrentVersion\\Run\\updater"},
+		"files created": {"C:\\Users\\cuckoo\\AppData\\Roaming\\updater.exe"},
+	}
+	actual := map[string][]string{
+		"ips":           set.IPs,
+		"domains":       set.Domains,
+		"urls":          set.URLs,
+		"user agents":   set.UserAgents,
+		"mutexes":       set.Mutexes,
+		"registry keys": set.RegistryKeys,
+		"files created": set.FilesCreated,
+	}
+	for name, values := range expected {
+		if !reflect.DeepEqual(actual[name], values) {
+			t.Errorf("%s: expected %v, got %v", name, values, actual[name])
+		}
+	}
+
+	if len(set.DroppedFiles) != 2 || set.DroppedFiles[0].Path == "" {
+		t.Errorf("unexpected dropped files %v", set.DroppedFiles)
+	}
+	if len(set.Processes) != 2 || set.Processes[1].CommandLine != "cmd.exe /c del invoice.exe" {
+		t.Errorf("unexpected processes %v", set.Processes)
+	}
+	if len(set.All()) != 12 {
+		t.Errorf("expected 12 indicators, got %d", len(set.All()))
+	}
+}
+
+func TestExtractAllowlist(t *testing.T) {
+	allowlist, err := LoadAllowlist(strings.NewReader(`{
+		"ips": ["8.8.8.0/24"],
+		"domains": ["example.com"],
+		"hashes": ["7215EE9C7D9DC229D2921A40E899EC5F"],
+		"processes": ["cmd.exe"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := Extract(testutil.LoadReport(t), &Options{Allowlist: allowlist})
+	if len(set.Domains) != 0 || len(set.URLs) != 0 {
+		t.Errorf("allowed domains were kept: %v %v", set.Domains, set.URLs)
+	}
+	// Without machines the resultserver is kept, the allowed range and the domain's address are not
+	if !reflect.DeepEqual(set.IPs, []string{"192.168.56.1"}) {
+		t.Errorf("unexpected ips %v", set.IPs)
+	}
+	if len(set.DroppedFiles) != 1 || len(set.Processes) != 1 {
+		t.Errorf("allowed files or processes were kept")
+	}
+
+	set = Extract(testutil.LoadReport(t), &Options{NoDefaultAllowlist: true})
+	if len(set.Mutexes) != 2 || len(set.Domains) != 2 {
+		t.Errorf("default allowlist was applied: %v %v", set.Mutexes, set.Domains)
+	}
+}
+
+func TestDefaultAllowlistWPAD(t *testing.T) {
+	allowlist := DefaultAllowlist().compile()
+	for _, domain := range []string{"wpad.localdomain", "wpad.corp.example.com"} {
+		if !allowlist.domain(domain) {
+			t.Errorf("%s was not allowed", domain)
+		}
+	}
+	for _, domain := range []string{"notwpad.localdomain", "evil.wpad.example"} {
+		if allowlist.domain(domain) {
+			t.Errorf("%s was allowed", domain)
+		}
+	}
+}
+
+func TestFetchTask(t *testing.T) {
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/view/42":
+			fmt.Fprint(w, `{"task": {"id": 42, "category": "file", "sample_id": 7}}`)
+		case "/tasks/view/43":
+			fmt.Fprint(w, `{"task": {"id": 43, "category": "url", "sample_id": null}}`)
+		case "/files/view/id/7":
+			fmt.Fprint(w, `{"sample": {"id": 7, "sha256": "abc"}}`)
+		case "/tasks/report/42/json", "/tasks/report/43/json":
+			http.ServeFile(w, r, "../testdata/report.json")
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	data, err := FetchTask(context.Background(), c, 42, &Options{Allowlist: &Allowlist{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Task.ID != 42 || data.Sample == nil || data.Sample.Sha256 != "abc" || data.Report == nil {
+		t.Errorf("unexpected task %+v", data)
+	}
+	if len(data.Options.Machines) != 1 || data.Options.Machines[0].Name != "win7-1" || data.Options.Allowlist == nil {
+		t.Errorf("expected the machine to be added to the options, got %+v", data.Options)
+	}
+
+	data, err = FetchTask(context.Background(), c, 43, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Sample != nil {
+		t.Errorf("a url task has no sample, got %+v", data.Sample)
+	}
+}
+
+func TestExtractTask(t *testing.T) {
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/report/42/json":
+			http.ServeFile(w, r, "../testdata/report.json")
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	set, err := ExtractTask(context.Background(), c, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range set.IPs {
+		if ip == "192.168.56.1" {
+			t.Errorf("resultserver ip was not filtered")
+		}
+	}
+}
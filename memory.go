@@ -49,7 +49,8 @@ func (c *Client) MemoryList(ctx context.Context, taskID int) ([]string, error) {
 // dumps. See this issue:
 // https://github.com/cuckoosandbox/cuckoo/issues/2327
 //
-// This function returns the direct reader from the cuckoo api.
+// This function returns the direct reader from the cuckoo api.  The client timeout applies to reading it, see
+// MemoryStream and MemoryDownload for large dumps.
 func (c *Client) MemoryGet(ctx context.Context, taskID int, pID int) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/memory/get/%d/%d", c.BaseURL, taskID, pID), nil)
 	if err != nil {
@@ -0,0 +1,552 @@
+package cuckoo
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMemoryConcurrency  = 4
+	defaultMemoryStallTimeout = 2 * time.Minute
+
+	// MemoryManifestName is the name of the manifest written by MemoryDownload
+	MemoryManifestName = "manifest.json"
+
+	memoryPartSuffix       = ".part"
+	memoryProgressInterval = 1024 * 1024
+)
+
+// MemoryDownloadOptions are the options of MemoryDownload
+type MemoryDownloadOptions struct {
+	// Names of the dumps to download, as returned by MemoryList.  Defaults to every dump of the task.
+	Names []string
+	// Dumps downloaded at once, defaults to 4
+	Concurrency int
+	// Compress the dumps with gzip as they are downloaded, they are written as "<name>.dmp.gz"
+	Gzip bool
+	// A download is given up on when no data is received for this long, defaults to 2 minutes.  The client timeout
+	// does not apply to the downloads, use ctx to bound them.
+	StallTimeout time.Duration
+	// Optional, called as the dumps are downloaded.  It is called from several goroutines at once.
+	Progress func(p *MemoryDumpProgress)
+}
+
+// MemoryDumpProgress is the progress of the download of a memory dump
+type MemoryDumpProgress struct {
+	Name string
+	// Bytes of the dump downloaded so far, including those of a previous attempt
+	Downloaded int64
+	// Size of the dump, -1 until it is known
+	Total int64
+	Done  bool
+	// Set when the download failed, it is then done
+	Err error
+}
+
+// MemoryManifest lists the memory dumps downloaded into a directory
+type MemoryManifest struct {
+	TaskID int           `json:"task_id"`
+	Dumps  []*MemoryDump `json:"dumps"`
+}
+
+// MemoryDump is a memory dump downloaded by MemoryDownload
+type MemoryDump struct {
+	Name string `json:"name"`
+	// Name of the file in the directory
+	File string `json:"file"`
+	// Size and hash of the dump, before compression
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Size of the file, smaller than Size when it is compressed
+	FileSize   int64 `json:"file_size"`
+	Compressed bool  `json:"compressed"`
+	// Set if the dump could not be downloaded
+	Error string `json:"error,omitempty"`
+}
+
+// MemoryDownload Downloads the memory dumps of the specified task ID into dir, several at once, and writes a
+// manifest of them in dir.
+//
+// Memory dumps are often too large to be downloaded within the timeout of the client, which is not applied here:
+// a download only fails when it stalls for opts.StallTimeout.  Failed downloads are kept as "<file>.part" and are
+// resumed by the next call with HTTP Range requests, falling back to downloading them again if the server
+// ignores the range.  Dumps listed without error in the manifest of dir are not downloaded again.
+//
+// The manifest is returned even if some dumps could not be downloaded, along with an error.
+func (c *Client) MemoryDownload(ctx context.Context, taskID int, dir string, opts *MemoryDownloadOptions) (*MemoryManifest, error) {
+	d := &memoryDownloader{taskID: taskID, dir: dir}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.Concurrency <= 0 {
+		d.opts.Concurrency = defaultMemoryConcurrency
+	}
+	if d.opts.StallTimeout <= 0 {
+		d.opts.StallTimeout = defaultMemoryStallTimeout
+	}
+
+	d.client = c.withoutTimeout()
+
+	names := d.opts.Names
+	if names == nil {
+		var err error
+		if names, err = c.MemoryList(ctx, taskID); err != nil {
+			return nil, fmt.Errorf("error listing memory dumps of task %d: %w", taskID, err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	previous := loadMemoryManifest(dir, taskID)
+
+	manifest := &MemoryManifest{TaskID: taskID, Dumps: make([]*MemoryDump, len(names))}
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < d.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				manifest.Dumps[i] = d.download(ctx, names[i], previous[names[i]])
+			}
+		}()
+	}
+	for i := range names {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err := writeMemoryManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+
+	failed := []string{}
+	for _, dump := range manifest.Dumps {
+		if dump.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", dump.Name, dump.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return manifest, fmt.Errorf("cuckoo: %d of %d memory dumps failed: %s", len(failed), len(names), strings.Join(failed, "; "))
+	}
+	return manifest, nil
+}
+
+// MemoryStream Returns one memory dump file associated with the specified task ID, like MemoryGet, for dumps too
+// large to be read within the timeout of the client.
+//
+// The client timeout does not apply: reading fails once no data is received for stallTimeout, which defaults to 2
+// minutes.  Use ctx to bound the download.
+func (c *Client) MemoryStream(ctx context.Context, taskID int, pID int, stallTimeout time.Duration) (io.ReadCloser, error) {
+	if stallTimeout <= 0 {
+		stallTimeout = defaultMemoryStallTimeout
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &stallReader{timeout: stallTimeout, cancel: cancel}
+	r.stall = time.AfterFunc(stallTimeout, func() {
+		atomic.StoreInt32(&r.stalled, 1)
+		cancel()
+	})
+
+	body, err := c.withoutTimeout().MemoryGet(ctx, taskID, pID)
+	if err != nil {
+		r.stall.Stop()
+		cancel()
+		return nil, r.err(err)
+	}
+	r.body = body
+	return r, nil
+}
+
+// withoutTimeout returns the same client without the overall timeout
+func (c *Client) withoutTimeout() *Client {
+	httpClient := *c.Client
+	httpClient.Timeout = 0
+	client := *c
+	client.Client = &httpClient
+	return &client
+}
+
+// stallReader reads a response, canceling it when no data is received for timeout
+type stallReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	stall   *time.Timer
+	stalled int32
+	cancel  context.CancelFunc
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.stall.Reset(r.timeout)
+	if err != nil && err != io.EOF {
+		err = r.err(err)
+	}
+	return n, err
+}
+
+// err replaces the error of a stalled request
+func (r *stallReader) err(err error) error {
+	if atomic.LoadInt32(&r.stalled) == 1 {
+		return fmt.Errorf("cuckoo: no data received for %s", r.timeout)
+	}
+	return err
+}
+
+func (r *stallReader) Close() error {
+	r.stall.Stop()
+	r.cancel()
+	return r.body.Close()
+}
+
+// loadMemoryManifest returns the dumps of the manifest of a previous download, by name
+func loadMemoryManifest(dir string, taskID int) map[string]*MemoryDump {
+	dumps := map[string]*MemoryDump{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, MemoryManifestName))
+	if err != nil {
+		return dumps
+	}
+	manifest := &MemoryManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil || manifest.TaskID != taskID {
+		return dumps
+	}
+	for _, dump := range manifest.Dumps {
+		if dump != nil {
+			dumps[dump.Name] = dump
+		}
+	}
+	return dumps
+}
+
+func writeMemoryManifest(dir string, manifest *MemoryManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".manifest-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, MemoryManifestName))
+}
+
+// memoryDownloader downloads the memory dumps of a task
+type memoryDownloader struct {
+	client *Client
+	taskID int
+	dir    string
+	opts   MemoryDownloadOptions
+}
+
+func (d *memoryDownloader) progress(p *MemoryDumpProgress) {
+	if d.opts.Progress != nil {
+		d.opts.Progress(p)
+	}
+}
+
+// download downloads a dump unless previous says it already was
+func (d *memoryDownloader) download(ctx context.Context, name string, previous *MemoryDump) *MemoryDump {
+	dump := &MemoryDump{Name: name, Compressed: d.opts.Gzip}
+
+	// The names come from the server, don't let them out of the directory
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		dump.Error = fmt.Sprintf("invalid memory dump name %q", name)
+		d.progress(&MemoryDumpProgress{Name: name, Total: -1, Done: true, Err: errors.New(dump.Error)})
+		return dump
+	}
+	dump.File = name + ".dmp"
+	if dump.Compressed {
+		dump.File += ".gz"
+	}
+	path := filepath.Join(d.dir, dump.File)
+
+	if previous != nil && previous.Error == "" && previous.File == dump.File {
+		if info, err := os.Stat(path); err == nil && info.Size() == previous.FileSize {
+			d.progress(&MemoryDumpProgress{Name: name, Downloaded: previous.Size, Total: previous.Size, Done: true})
+			return previous
+		}
+	}
+
+	if err := d.fetch(ctx, dump, path); err != nil {
+		dump.Error = err.Error()
+		d.progress(&MemoryDumpProgress{Name: name, Total: -1, Done: true, Err: err})
+	}
+	return dump
+}
+
+// fetch downloads a dump into path, resuming its partial download
+func (d *memoryDownloader) fetch(ctx context.Context, dump *MemoryDump, path string) error {
+	part, err := openMemoryPart(path+memoryPartSuffix, dump.Compressed)
+	if err != nil {
+		return err
+	}
+	defer part.close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stalled int32
+	stall := time.AfterFunc(d.opts.StallTimeout, func() {
+		atomic.StoreInt32(&stalled, 1)
+		cancel()
+	})
+	defer stall.Stop()
+	stallErr := func(err error) error {
+		if atomic.LoadInt32(&stalled) == 1 {
+			return fmt.Errorf("cuckoo: no data received for %s", d.opts.StallTimeout)
+		}
+		return err
+	}
+
+	var resp *http.Response
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/memory/get/%d/%s", d.client.BaseURL, d.taskID, url.PathEscape(dump.Name)), nil)
+		if err != nil {
+			return err
+		}
+		if part.size > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", part.size))
+		}
+
+		resp, err = d.client.MakeRequest(req)
+		if err != nil {
+			return stallErr(err)
+		}
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && part.size > 0 {
+			// The partial download does not fit the dump, start over
+			resp.Body.Close()
+			if err := part.reset(); err != nil {
+				return err
+			}
+			continue
+		}
+		break
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+	switch resp.StatusCode {
+	case 404:
+		return fmt.Errorf("Memory dump not found")
+	case 206:
+		start, length, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != part.size {
+			return fmt.Errorf("cuckoo: unexpected content range %q", resp.Header.Get("Content-Range"))
+		}
+		total = length
+	case 200:
+		// The range was ignored
+		if err := part.reset(); err != nil {
+			return err
+		}
+		total = resp.ContentLength
+	default:
+		return fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+
+	d.progress(&MemoryDumpProgress{Name: dump.Name, Downloaded: part.size, Total: total})
+	reported := part.size
+	buf := make([]byte, 256*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		stall.Reset(d.opts.StallTimeout)
+		if n > 0 {
+			if _, writeErr := part.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if part.size-reported >= memoryProgressInterval {
+				d.progress(&MemoryDumpProgress{Name: dump.Name, Downloaded: part.size, Total: total})
+				reported = part.size
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stallErr(err)
+		}
+	}
+	if total >= 0 && part.size != total {
+		return fmt.Errorf("cuckoo: memory dump truncated, got %d of %d bytes", part.size, total)
+	}
+
+	if err := part.finish(); err != nil {
+		return err
+	}
+	if err := os.Rename(part.file.Name(), path); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	dump.Size = part.size
+	dump.SHA256 = hex.EncodeToString(part.hash.Sum(nil))
+	dump.FileSize = info.Size()
+	d.progress(&MemoryDumpProgress{Name: dump.Name, Downloaded: part.size, Total: part.size, Done: true})
+	return nil
+}
+
+// parseContentRange returns the start and the complete length of a "bytes start-end/length" range, the length
+// is -1 if it is unknown
+func parseContentRange(contentRange string) (int64, int64, error) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid content range")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(contentRange, "bytes "), "/", 2)
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(parts) != 2 || len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("invalid content range")
+	}
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if parts[1] == "*" {
+		return start, -1, nil
+	}
+	length, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, length, nil
+}
+
+// memoryPart is the partial download of a dump, size and hash cover the dump before compression
+type memoryPart struct {
+	file *os.File
+	gz   *gzip.Writer
+	hash hash.Hash
+	size int64
+
+	closed bool
+}
+
+// openMemoryPart opens a partial download to continue it
+func openMemoryPart(path string, compressed bool) (*memoryPart, error) {
+	part := &memoryPart{hash: sha256.New()}
+	if !compressed {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		// Reading it leaves the file at its end
+		if part.size, err = io.Copy(part.hash, f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		part.file = f
+		return part, nil
+	}
+
+	// A gzip stream can't be continued, the dump downloaded so far is compressed again.  The previous part is kept
+	// as ".old" until this is done.
+	old := path + ".old"
+	if _, err := os.Stat(old); err == nil {
+		os.Remove(path)
+	} else if _, err := os.Stat(path); err == nil {
+		if err := os.Rename(path, old); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	part.file = f
+	part.gz = gzip.NewWriter(f)
+
+	if previous, err := os.Open(old); err == nil {
+		if zr, err := gzip.NewReader(previous); err == nil {
+			// The previous part ends abruptly, what was decompressed before is intact
+			part.size, _ = io.Copy(io.MultiWriter(part.gz, part.hash), zr)
+		}
+		previous.Close()
+		os.Remove(old)
+	}
+	return part, nil
+}
+
+func (p *memoryPart) Write(b []byte) (int, error) {
+	var n int
+	var err error
+	if p.gz != nil {
+		n, err = p.gz.Write(b)
+	} else {
+		n, err = p.file.Write(b)
+	}
+	p.hash.Write(b[:n])
+	p.size += int64(n)
+	return n, err
+}
+
+// reset discards what was downloaded
+func (p *memoryPart) reset() error {
+	if err := p.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if p.gz != nil {
+		p.gz.Reset(p.file)
+	}
+	p.hash.Reset()
+	p.size = 0
+	return nil
+}
+
+// finish completes the file of a complete download
+func (p *memoryPart) finish() error {
+	if p.gz != nil {
+		if err := p.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	p.closed = true
+	return p.file.Close()
+}
+
+// close keeps what was downloaded of an interrupted download
+func (p *memoryPart) close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	if p.gz != nil {
+		p.gz.Flush()
+	}
+	p.file.Close()
+}
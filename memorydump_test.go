@@ -0,0 +1,274 @@
+package cuckoo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryServer serves the dumps of task 42, recording the ranges asked for
+type memoryServer struct {
+	dumps       map[string][]byte
+	ignoreRange bool
+
+	mu       sync.Mutex
+	ranges   map[string]string
+	requests int
+}
+
+func (s *memoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/memory/list/42" {
+		json.NewEncoder(w).Encode(map[string][]string{"dump_files": {"1234-1", "2048"}})
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/memory/get/42/")
+	dump, ok := s.dumps[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	s.ranges[name] = r.Header.Get("Range")
+	s.requests++
+	s.mu.Unlock()
+
+	if s.ignoreRange {
+		r.Header.Del("Range")
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(dump))
+}
+
+func newMemoryServer() *memoryServer {
+	return &memoryServer{
+		dumps: map[string][]byte{
+			"1234-1": bytes.Repeat([]byte("MZ\x90\x00process 1234 "), 200000),
+			"2048":   bytes.Repeat([]byte{0xcc}, 3*memoryProgressInterval+17),
+		},
+		ranges: map[string]string{},
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMemoryDownload(t *testing.T) {
+	s := newMemoryServer()
+	c := getMockClient(t, s.ServeHTTP)
+	dir := t.TempDir()
+
+	progress := map[string]*MemoryDumpProgress{}
+	mu := sync.Mutex{}
+	manifest, err := c.MemoryDownload(context.Background(), 42, dir, &MemoryDownloadOptions{
+		Progress: func(p *MemoryDumpProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if last, ok := progress[p.Name]; ok && (last.Done || p.Downloaded < last.Downloaded) {
+				t.Errorf("progress went backwards %+v after %+v", p, last)
+			}
+			progress[p.Name] = p
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest.TaskID != 42 || len(manifest.Dumps) != 2 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	for _, dump := range manifest.Dumps {
+		expected := s.dumps[dump.Name]
+		data, err := ioutil.ReadFile(filepath.Join(dir, dump.Name+".dmp"))
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("unexpected content of %s: %v", dump.Name, err)
+		}
+		if dump.File != dump.Name+".dmp" || dump.Size != int64(len(expected)) || dump.FileSize != dump.Size ||
+			dump.SHA256 != sha256Hex(expected) || dump.Compressed || dump.Error != "" {
+			t.Errorf("unexpected dump %+v", dump)
+		}
+		if p := progress[dump.Name]; !p.Done || p.Downloaded != dump.Size || p.Total != dump.Size {
+			t.Errorf("unexpected progress %+v", p)
+		}
+	}
+
+	written := &MemoryManifest{}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, MemoryManifestName))
+	if err := json.Unmarshal(data, written); err != nil || written.Dumps[1].SHA256 != manifest.Dumps[1].SHA256 {
+		t.Errorf("unexpected manifest file %s: %v", data, err)
+	}
+
+	// Downloaded dumps are not downloaded again
+	requests := s.requests
+	if _, err := c.MemoryDownload(context.Background(), 42, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.requests != requests {
+		t.Errorf("dumps were downloaded again")
+	}
+}
+
+func TestMemoryDownloadResume(t *testing.T) {
+	s := newMemoryServer()
+	c := getMockClient(t, s.ServeHTTP)
+	dump := s.dumps["2048"]
+
+	for _, ignoreRange := range []bool{false, true} {
+		s.ignoreRange = ignoreRange
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(dir, "2048.dmp.part"), dump[:1000], 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		manifest, err := c.MemoryDownload(context.Background(), 42, dir, &MemoryDownloadOptions{Names: []string{"2048"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.ranges["2048"] != "bytes=1000-" {
+			t.Errorf("expected a range request, got %q", s.ranges["2048"])
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "2048.dmp"))
+		if !bytes.Equal(data, dump) || manifest.Dumps[0].SHA256 != sha256Hex(dump) {
+			t.Errorf("unexpected resumed dump of %d bytes, ignoring range %v", len(data), ignoreRange)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "2048.dmp.part")); !os.IsNotExist(err) {
+			t.Errorf("partial download should be gone: %v", err)
+		}
+	}
+}
+
+func TestMemoryDownloadGzip(t *testing.T) {
+	s := newMemoryServer()
+	c := getMockClient(t, s.ServeHTTP)
+	dir := t.TempDir()
+	dump := s.dumps["1234-1"]
+
+	// A compressed partial download cut in the middle of its stream
+	partial := &bytes.Buffer{}
+	gz := gzip.NewWriter(partial)
+	gz.Write(dump[:50000])
+	gz.Flush()
+	cut := partial.Len()
+	gz.Write(dump[50000:60000])
+	gz.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "1234-1.dmp.gz.part"), partial.Bytes()[:cut+10], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := c.MemoryDownload(context.Background(), 42, dir, &MemoryDownloadOptions{Names: []string{"1234-1"}, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.ranges["1234-1"], "bytes=50000-") {
+		t.Errorf("expected the intact part to be kept, got %q", s.ranges["1234-1"])
+	}
+
+	f, err := os.Open(filepath.Join(dir, "1234-1.dmp.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil || !bytes.Equal(data, dump) {
+		t.Errorf("unexpected decompressed dump of %d bytes: %v", len(data), err)
+	}
+	d := manifest.Dumps[0]
+	if !d.Compressed || d.File != "1234-1.dmp.gz" || d.Size != int64(len(dump)) || d.FileSize >= d.Size || d.SHA256 != sha256Hex(dump) {
+		t.Errorf("unexpected dump %+v", d)
+	}
+}
+
+func TestMemoryDownloadErrors(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/memory/get/42/stalled":
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("MZ"))
+			w.(http.Flusher).Flush()
+			<-block
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	dir := t.TempDir()
+
+	manifest, err := c.MemoryDownload(context.Background(), 42, dir, &MemoryDownloadOptions{
+		Names:        []string{"stalled", "missing", "../escape"},
+		StallTimeout: 50 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "3 of 3") {
+		t.Fatalf("expected every dump to fail, got %v", err)
+	}
+	if !strings.Contains(manifest.Dumps[0].Error, "no data received") || manifest.Dumps[1].Error == "" ||
+		!strings.Contains(manifest.Dumps[2].Error, "invalid") {
+		t.Errorf("unexpected errors %+v %+v %+v", manifest.Dumps[0], manifest.Dumps[1], manifest.Dumps[2])
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "stalled.dmp.part")); err != nil || string(data) != "MZ" {
+		t.Errorf("the partial download should be kept, got %q %v", data, err)
+	}
+}
+
+func TestMemoryStream(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/memory/get/42/1234":
+			// Slower than the client timeout as a whole, but never stalled
+			for i := 0; i < 5; i++ {
+				w.Write([]byte("MZ"))
+				w.(http.Flusher).Flush()
+				time.Sleep(30 * time.Millisecond)
+			}
+		case "/memory/get/42/2048":
+			w.Write([]byte("MZ"))
+			w.(http.Flusher).Flush()
+			<-block
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c.Client.Timeout = 100 * time.Millisecond
+
+	body, err := c.MemoryStream(context.Background(), 42, 1234, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "MZMZMZMZMZ" {
+		t.Errorf("unexpected dump %q %v", data, err)
+	}
+
+	body, err = c.MemoryStream(context.Background(), 42, 2048, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(body)
+	body.Close()
+	if err == nil || !strings.Contains(err.Error(), "no data received") || string(data) != "MZ" {
+		t.Errorf("expected the stalled dump to fail, got %q %v", data, err)
+	}
+
+	if _, err := c.MemoryStream(context.Background(), 42, 1, 0); err == nil {
+		t.Errorf("expected an error for a missing dump")
+	}
+}
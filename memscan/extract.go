@@ -0,0 +1,162 @@
+package memscan
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Built-in extractors, also the rule of their matches
+const (
+	ExtractURL      = "url"
+	ExtractIP       = "ip"
+	ExtractDomain   = "domain"
+	ExtractBitcoin  = "bitcoin"
+	ExtractEthereum = "ethereum"
+	ExtractMonero   = "monero"
+)
+
+// Extractors lists the built-in extractors
+var Extractors = []string{ExtractURL, ExtractIP, ExtractDomain, ExtractBitcoin, ExtractEthereum, ExtractMonero}
+
+// extractor finds indicators in the strings of a dump
+type extractor struct {
+	name  string
+	regex *regexp.Regexp
+	// Optional, drops false positives
+	valid func(s string, start, end int) bool
+}
+
+var extractors = map[string]*extractor{
+	ExtractURL: {
+		name:  ExtractURL,
+		regex: regexp.MustCompile(`(?i)\b(?:https?|ftp)://[a-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]+`),
+	},
+	ExtractIP: {
+		name:  ExtractIP,
+		regex: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\b`),
+		valid: validIP,
+	},
+	ExtractDomain: {
+		name:  ExtractDomain,
+		regex: regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,24}\b`),
+		valid: validDomain,
+	},
+	ExtractBitcoin: {
+		name:  ExtractBitcoin,
+		regex: regexp.MustCompile(`\b(?:[13][1-9A-HJ-NP-Za-km-z]{25,34}|bc1[02-9ac-hj-np-z]{11,71})\b`),
+		valid: validBitcoin,
+	},
+	ExtractEthereum: {
+		name:  ExtractEthereum,
+		regex: regexp.MustCompile(`\b0x[0-9a-fA-F]{40}\b`),
+	},
+	ExtractMonero: {
+		name:  ExtractMonero,
+		regex: regexp.MustCompile(`\b[48][0-9AB][1-9A-HJ-NP-Za-km-z]{93}\b`),
+	},
+}
+
+// validIP drops version numbers, like the start of 10.0.17763.1, and addresses that are never indicators
+func validIP(s string, start, end int) bool {
+	if start > 0 && s[start-1] == '.' || end < len(s) && s[end] == '.' && end+1 < len(s) && isDigit(s[end+1]) {
+		return false
+	}
+	ip := net.ParseIP(s[start:end])
+	return ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() && !ip.IsMulticast() && !ip.IsLinkLocalUnicast() &&
+		!ip.Equal(net.IPv4bcast)
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// topLevelDomains are the domains accepted by the domain extractor, memory is full of names like kernel32.dll
+// that only differ from domains by their suffix
+var topLevelDomains = map[string]bool{}
+
+func init() {
+	for _, tld := range strings.Fields(`
+		com net org info biz name pro mobi app dev io co me tv cc ws su ru ua by kz cn hk tw jp kr in ir pk vn id th my
+		sg ph au nz uk de fr it es pt nl be ch at se no dk fi pl cz sk hu ro bg gr tr il ae sa eg za ng ke br ar mx cl
+		pe ve us ca eu asia xyz top online site club shop store live tech space website icu buzz fun link click host
+		cloud digital network email onion bit`) {
+		topLevelDomains[tld] = true
+	}
+}
+
+func validDomain(s string, start, end int) bool {
+	// Part of an address or a longer name
+	if start > 0 && (s[start-1] == '@' || s[start-1] == '-' || s[start-1] == '.') {
+		return false
+	}
+	domain := strings.ToLower(s[start:end])
+	return topLevelDomains[domain[strings.LastIndex(domain, ".")+1:]]
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// validBitcoin checks the checksum of legacy and segwit addresses
+func validBitcoin(s string, start, end int) bool {
+	address := s[start:end]
+	if strings.HasPrefix(address, "bc1") {
+		return validBech32(address)
+	}
+
+	value := big.NewInt(0)
+	base := big.NewInt(58)
+	for i := 0; i < len(address); i++ {
+		value.Mul(value, base)
+		value.Add(value, big.NewInt(int64(strings.IndexByte(base58Alphabet, address[i]))))
+	}
+	decoded := value.Bytes()
+	for i := 0; i < len(address) && address[i] == '1'; i++ {
+		decoded = append([]byte{0}, decoded...)
+	}
+	if len(decoded) != 25 {
+		return false
+	}
+
+	first := sha256.Sum256(decoded[:21])
+	second := sha256.Sum256(first[:])
+	return string(second[:4]) == string(decoded[21:])
+}
+
+const bech32Alphabet = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// validBech32 checks the checksum of a bech32 or bech32m address
+func validBech32(address string) bool {
+	separator := strings.LastIndexByte(address, '1')
+	hrp, data := address[:separator], address[separator+1:]
+	if len(data) < 6 {
+		return false
+	}
+
+	values := []int{}
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, int(hrp[i]>>5))
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, int(hrp[i]&31))
+	}
+	for i := 0; i < len(data); i++ {
+		values = append(values, strings.IndexByte(bech32Alphabet, data[i]))
+	}
+
+	generator := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := 1
+	for _, v := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ v
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	// bech32 and bech32m
+	return checksum == 1 || checksum == 0x2bc830a3
+}
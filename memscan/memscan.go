@@ -0,0 +1,376 @@
+// Package memscan scans the process memory dumps of cuckoo analyses for strings, patterns and indicators as they
+// are downloaded, without writing them to disk
+package memscan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+const (
+	defaultMinLength       = 6
+	defaultWindow          = 4096
+	defaultMaxStringLength = 4096
+
+	chunkSize = 1024 * 1024
+)
+
+// Encoding is the encoding of the string a match was found in
+type Encoding string
+
+// Encodings of strings
+const (
+	EncodingASCII   Encoding = "ascii"
+	EncodingUTF16LE Encoding = "utf-16le"
+)
+
+// RuleStrings is the rule of the strings reported with Options.Strings
+const RuleStrings = "strings"
+
+// Match is something found in a dump
+type Match struct {
+	PID int `json:"pid"`
+	// Offset and length of the match in the dump, in bytes
+	Offset int64 `json:"offset"`
+	Length int   `json:"length"`
+	// Name of the rule or of the built-in extractor that matched, or RuleStrings
+	Rule string `json:"rule"`
+	// Encoding of the string the match was found in, empty for matches of the raw bytes
+	Encoding Encoding `json:"encoding,omitempty"`
+	// The match as UTF-8 text, or hex encoded if it is binary
+	Value string `json:"value"`
+}
+
+// Options configure a Scanner
+type Options struct {
+	// Minimum length of the strings, in characters, defaults to 6
+	MinLength int
+	// Strings longer than this many characters are split, defaults to 4096
+	MaxStringLength int
+	// Report every string of the dump, not only what the extractors and rules find in them
+	Strings bool
+
+	// Built-in extractors to run over the strings, defaults to every one of Extractors
+	Extractors []string
+	// Do not run any built-in extractor
+	NoExtractors bool
+
+	Rules []*Rule
+	// Longest match of the rules over the raw bytes, longer matches may be missed or cut where the dump is read
+	// in chunks.  Defaults to 4096.
+	Window int
+
+	// ScanTask gives up on a download when no data is received for this long, defaults to 2 minutes
+	StallTimeout time.Duration
+}
+
+// Scanner scans memory dumps.  A Scanner is safe for concurrent use.
+//
+// Dumps are read in chunks.  Rules are matched against the raw bytes, keeping the last Window bytes of a chunk to
+// match across chunk boundaries.  ASCII and UTF-16LE strings are extracted as the dump is read, the built-in
+// extractors are run over them and the regex rules over the UTF-16LE ones, which the raw bytes hide.
+type Scanner struct {
+	opts       Options
+	rules      []*compiledRule
+	extractors []*extractor
+}
+
+// NewScanner Compiles the rules of opts into a scanner
+func NewScanner(opts *Options) (*Scanner, error) {
+	s := &Scanner{}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.MinLength <= 0 {
+		s.opts.MinLength = defaultMinLength
+	}
+	if s.opts.MaxStringLength < s.opts.MinLength {
+		s.opts.MaxStringLength = defaultMaxStringLength
+	}
+	if s.opts.Window <= 0 {
+		s.opts.Window = defaultWindow
+	}
+
+	for _, rule := range s.opts.Rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, compiled)
+	}
+
+	names := s.opts.Extractors
+	if names == nil {
+		names = Extractors
+	}
+	if s.opts.NoExtractors {
+		names = nil
+	}
+	for _, name := range names {
+		e, ok := extractors[name]
+		if !ok {
+			return nil, fmt.Errorf("memscan: unknown extractor %q", name)
+		}
+		s.extractors = append(s.extractors, e)
+	}
+
+	return s, nil
+}
+
+// Scan Reads the dump of the process pid from r and calls fn for every match, as they are found.  Returning an
+// error from fn stops the scan.
+func (s *Scanner) Scan(r io.Reader, pid int, fn func(m *Match) error) error {
+	sc := &scan{scanner: s, pid: pid, fn: fn, reported: make([]int64, len(s.rules))}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if scanErr := sc.chunk(buf[:n]); scanErr != nil {
+				return scanErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sc.end()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ScanAll Scans a dump and returns all of its matches sorted by offset, see Scan
+func (s *Scanner) ScanAll(r io.Reader, pid int) ([]*Match, error) {
+	matches := []*Match{}
+	err := s.Scan(r, pid, func(m *Match) error {
+		matches = append(matches, m)
+		return nil
+	})
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Offset < matches[j].Offset })
+	return matches, err
+}
+
+// ScanTask Downloads the memory dump of the process pid of the specified task ID with MemoryStream and scans it,
+// see Scan.
+func (s *Scanner) ScanTask(ctx context.Context, c *cuckoo.Client, taskID, pid int, fn func(m *Match) error) error {
+	body, err := c.MemoryStream(ctx, taskID, pid, s.opts.StallTimeout)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return s.Scan(body, pid, fn)
+}
+
+// scan is the state of the scan of a dump
+type scan struct {
+	scanner *Scanner
+	pid     int
+	fn      func(m *Match) error
+
+	// Raw bytes kept from the previous chunk, starting at offset carryOffset
+	carry       []byte
+	carryOffset int64
+	// End of the last match of each rule, so matches seen again in the carried bytes are reported once
+	reported []int64
+
+	// Offset of the next byte
+	offset int64
+	ascii  *run
+	// UTF-16LE strings starting at even and odd offsets
+	wide     [2]*run
+	previous byte
+}
+
+// run is a string being read
+type run struct {
+	start int64
+	chars []byte
+}
+
+func (sc *scan) chunk(data []byte) error {
+	if err := sc.strings(data); err != nil {
+		return err
+	}
+	if len(sc.scanner.rules) == 0 {
+		return nil
+	}
+
+	buf := append(sc.carry, data...)
+	limit := len(buf) - sc.scanner.opts.Window
+	if limit < 0 {
+		limit = 0
+	}
+	if err := sc.raw(buf, limit); err != nil {
+		return err
+	}
+	sc.carry = append([]byte{}, buf[limit:]...)
+	sc.carryOffset += int64(limit)
+	return nil
+}
+
+func (sc *scan) end() error {
+	if len(sc.scanner.rules) > 0 {
+		if err := sc.raw(sc.carry, len(sc.carry)); err != nil {
+			return err
+		}
+	}
+	if err := sc.flush(sc.ascii, EncodingASCII); err != nil {
+		return err
+	}
+	for _, w := range sc.wide {
+		if err := sc.flush(w, EncodingUTF16LE); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// raw matches the rules against buf, which starts at carryOffset, reporting the matches starting before limit
+func (sc *scan) raw(buf []byte, limit int) error {
+	for i, rule := range sc.scanner.rules {
+		for _, loc := range rule.findAll(buf) {
+			if loc[0] >= limit {
+				break
+			}
+			start := sc.carryOffset + int64(loc[0])
+			if start < sc.reported[i] || loc[0] == loc[1] {
+				continue
+			}
+			sc.reported[i] = sc.carryOffset + int64(loc[1])
+
+			err := sc.fn(&Match{
+				PID:    sc.pid,
+				Offset: start,
+				Length: loc[1] - loc[0],
+				Rule:   rule.name,
+				Value:  rule.value(buf[loc[0]:loc[1]]),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// strings extracts the strings of the next bytes of the dump
+func (sc *scan) strings(data []byte) error {
+	maxLength := sc.scanner.opts.MaxStringLength
+	for _, b := range data {
+		offset := sc.offset
+		sc.offset++
+
+		if isPrintable(b) {
+			if sc.ascii == nil {
+				sc.ascii = &run{start: offset}
+			}
+			sc.ascii.chars = append(sc.ascii.chars, b)
+			if len(sc.ascii.chars) >= maxLength {
+				if err := sc.flush(sc.ascii, EncodingASCII); err != nil {
+					return err
+				}
+				sc.ascii = nil
+			}
+		} else if sc.ascii != nil {
+			if err := sc.flush(sc.ascii, EncodingASCII); err != nil {
+				return err
+			}
+			sc.ascii = nil
+		}
+
+		// The character starting at the previous byte
+		if offset == 0 {
+			sc.previous = b
+			continue
+		}
+		parity := (offset - 1) % 2
+		w := sc.wide[parity]
+		if isPrintable(sc.previous) && b == 0 {
+			if w == nil {
+				w = &run{start: offset - 1}
+				sc.wide[parity] = w
+			}
+			w.chars = append(w.chars, sc.previous)
+			if len(w.chars) >= maxLength {
+				if err := sc.flush(w, EncodingUTF16LE); err != nil {
+					return err
+				}
+				sc.wide[parity] = nil
+			}
+		} else if w != nil {
+			if err := sc.flush(w, EncodingUTF16LE); err != nil {
+				return err
+			}
+			sc.wide[parity] = nil
+		}
+		sc.previous = b
+	}
+	return nil
+}
+
+// flush reports a string and what is found in it
+func (sc *scan) flush(r *run, encoding Encoding) error {
+	if r == nil || len(r.chars) < sc.scanner.opts.MinLength {
+		return nil
+	}
+	text := string(r.chars)
+	width := int64(1)
+	if encoding == EncodingUTF16LE {
+		width = 2
+	}
+	report := func(rule string, start, end int) error {
+		return sc.fn(&Match{
+			PID:      sc.pid,
+			Offset:   r.start + int64(start)*width,
+			Length:   (end - start) * int(width),
+			Rule:     rule,
+			Encoding: encoding,
+			Value:    text[start:end],
+		})
+	}
+
+	if sc.scanner.opts.Strings {
+		if err := report(RuleStrings, 0, len(text)); err != nil {
+			return err
+		}
+	}
+	for _, e := range sc.scanner.extractors {
+		for _, loc := range e.regex.FindAllStringIndex(text, -1) {
+			if e.valid != nil && !e.valid(text, loc[0], loc[1]) {
+				continue
+			}
+			if err := report(e.name, loc[0], loc[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The raw bytes of ASCII strings were already matched
+	if encoding != EncodingUTF16LE {
+		return nil
+	}
+	for _, rule := range sc.scanner.rules {
+		if rule.regex == nil {
+			continue
+		}
+		for _, loc := range rule.regex.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			if err := report(rule.name, loc[0], loc[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isPrintable returns true for the bytes of ASCII strings
+func isPrintable(b byte) bool {
+	return b >= 0x20 && b <= 0x7e || b == '\t'
+}
@@ -0,0 +1,185 @@
+package memscan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf16"
+
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+func encodeUTF16LE(s string) []byte {
+	encoded := []byte{}
+	for _, u := range utf16.Encode([]rune(s)) {
+		encoded = append(encoded, byte(u), byte(u>>8))
+	}
+	return encoded
+}
+
+const (
+	testBitcoin = "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	testBech32  = "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	testEther   = "0x52908400098527886E0F7030069857D2E4169EE7"
+	testMonero  = "44AFFq5kSiGBoZ4NMDwYtN18obc8AemS33DBLWs3H7otXft3XjrpDtQGv7SqSsaBYBb98uNbr2VBBEt7f2wfn3RVGQBEP3A"
+)
+
+func sandboxDump() []byte {
+	return testutil.Dump(chunkSize+8192, 0xff, map[int][]byte{
+		100:  []byte("GET http://evil.example.com/gate.php?id=1 from 185.100.87.202"),
+		300:  []byte("kernel32.dll version 10.0.17763.1"),
+		500:  encodeUTF16LE("https://c2.example.net/panel"),
+		700:  []byte("pay to " + testBitcoin + " or 1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb"),
+		900:  []byte(testBech32 + " " + testEther),
+		1100: []byte(testMonero),
+		// Across the first chunk boundary
+		chunkSize - 3:       {0x4d, 0x5a, 0x90, 0x00, 0x03, 0x00, 0x00, 0x00, 0x04},
+		chunkSize + 100 - 6: []byte("load evil1234.dll"),
+		chunkSize + 400:     encodeUTF16LE("C:\\evil42.dll"),
+	})
+}
+
+func testRules() []*Rule {
+	return []*Rule{
+		{Name: "pe_header", Hex: "4d 5a 90 00 ?? 00 00 00 04"},
+		{Name: "evil_dll", Regex: `evil[0-9]+\.dll`},
+	}
+}
+
+func find(matches []*Match, rule string) []*Match {
+	found := []*Match{}
+	for _, m := range matches {
+		if m.Rule == rule {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+func TestScan(t *testing.T) {
+	s, err := NewScanner(&Options{Rules: testRules()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, err := s.ScanAll(iotest.HalfReader(bytes.NewReader(sandboxDump())), 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := find(matches, ExtractURL)
+	if len(urls) != 2 || urls[0].Value != "http://evil.example.com/gate.php?id=1" || urls[0].Offset != 104 || urls[0].Encoding != EncodingASCII ||
+		urls[1].Value != "https://c2.example.net/panel" || urls[1].Offset != 500 || urls[1].Length != 56 || urls[1].Encoding != EncodingUTF16LE {
+		t.Errorf("unexpected urls %+v %+v", urls[0], urls[1])
+	}
+	if ips := find(matches, ExtractIP); len(ips) != 1 || ips[0].Value != "185.100.87.202" || ips[0].PID != 1234 {
+		t.Errorf("unexpected ips %+v", ips)
+	}
+	domains := find(matches, ExtractDomain)
+	if len(domains) != 2 || domains[0].Value != "evil.example.com" || domains[1].Value != "c2.example.net" {
+		t.Errorf("unexpected domains %+v", domains)
+	}
+	if bitcoin := find(matches, ExtractBitcoin); len(bitcoin) != 2 || bitcoin[0].Value != testBitcoin || bitcoin[1].Value != testBech32 {
+		t.Errorf("unexpected bitcoin addresses %+v", bitcoin)
+	}
+	if ether := find(matches, ExtractEthereum); len(ether) != 1 || ether[0].Value != testEther || ether[0].Offset != 900+int64(len(testBech32))+1 {
+		t.Errorf("unexpected ethereum addresses %+v", ether)
+	}
+	if monero := find(matches, ExtractMonero); len(monero) != 1 || monero[0].Offset != 1100 {
+		t.Errorf("unexpected monero addresses %+v", monero)
+	}
+
+	pe := find(matches, "pe_header")
+	if len(pe) != 1 || pe[0].Offset != chunkSize-3 || pe[0].Value != "4d5a90000300000004" || pe[0].Encoding != "" {
+		t.Errorf("unexpected hex matches %+v", pe[0])
+	}
+	dlls := find(matches, "evil_dll")
+	if len(dlls) != 2 || dlls[0].Offset != chunkSize+99 || dlls[0].Value != "evil1234.dll" ||
+		dlls[1].Value != "evil42.dll" || dlls[1].Offset != chunkSize+400+6 || dlls[1].Encoding != EncodingUTF16LE {
+		t.Errorf("unexpected regex matches %+v %+v", dlls[0], dlls[1])
+	}
+	if len(find(matches, RuleStrings)) != 0 {
+		t.Errorf("strings should only be reported with Options.Strings")
+	}
+}
+
+func TestScanStrings(t *testing.T) {
+	s, err := NewScanner(&Options{Strings: true, NoExtractors: true, MinLength: 4, MaxStringLength: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := testutil.Dump(64, 0xff, map[int][]byte{
+		1:  []byte("abc"),
+		10: []byte("abcdefghijkl"),
+		31: encodeUTF16LE("wide"),
+	})
+	matches, err := s.ScanAll(bytes.NewReader(dump), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Match{
+		{PID: 7, Offset: 10, Length: 8, Rule: RuleStrings, Encoding: EncodingASCII, Value: "abcdefgh"},
+		{PID: 7, Offset: 18, Length: 4, Rule: RuleStrings, Encoding: EncodingASCII, Value: "ijkl"},
+		{PID: 7, Offset: 31, Length: 8, Rule: RuleStrings, Encoding: EncodingUTF16LE, Value: "wide"},
+	}
+	if len(matches) != len(expected) {
+		t.Fatalf("expected %d strings, got %+v", len(expected), matches)
+	}
+	for i, m := range matches {
+		if *m != *expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], m)
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	for _, rule := range []*Rule{
+		{Name: "empty"},
+		{Name: "both", Regex: "a", Hex: "61"},
+		{Name: "wildcards", Hex: "?? ??"},
+		{Name: "odd", Hex: "4d5"},
+		{Name: "regex", Regex: "("},
+	} {
+		if _, err := NewScanner(&Options{Rules: []*Rule{rule}}); err == nil {
+			t.Errorf("expected an error for rule %+v", rule)
+		}
+	}
+	if _, err := NewScanner(&Options{Extractors: []string{"phone"}}); err == nil {
+		t.Errorf("expected an error for an unknown extractor")
+	}
+
+	s, _ := NewScanner(nil)
+	stop := errors.New("stop")
+	if err := s.Scan(bytes.NewReader(sandboxDump()), 1, func(m *Match) error { return stop }); err != stop {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+}
+
+func TestScanTask(t *testing.T) {
+	dump := sandboxDump()
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/memory/get/42/1234" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// The download takes longer than the timeout of the client
+		testutil.WriteSlowly(w, dump, 80*time.Millisecond)
+	})
+	c.Client.Timeout = 50 * time.Millisecond
+	s, _ := NewScanner(&Options{Extractors: []string{ExtractIP}})
+	ips := []*Match{}
+	err := s.ScanTask(context.Background(), c, 42, 1234, func(m *Match) error {
+		ips = append(ips, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0].Value != "185.100.87.202" || ips[0].PID != 1234 {
+		t.Errorf("unexpected matches %+v", ips)
+	}
+}
@@ -0,0 +1,154 @@
+package memscan
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rule is a pattern looked for in memory dumps.  Exactly one of Regex and Hex is set.
+type Rule struct {
+	Name string `json:"name"`
+	// Go regular expression, matched against the raw bytes of the dump and against its UTF-16LE strings.  Go
+	// expressions match UTF-8 text, use Hex for binary patterns.
+	Regex string `json:"regex,omitempty"`
+	// Hex bytes where "??" matches any byte, e.g. "4d 5a ?? 00".  Spaces are ignored.
+	Hex string `json:"hex,omitempty"`
+}
+
+// compiledRule is a rule ready to be matched
+type compiledRule struct {
+	name  string
+	regex *regexp.Regexp
+	hex   *hexPattern
+}
+
+func compileRule(rule *Rule) (*compiledRule, error) {
+	if rule.Name == "" {
+		return nil, fmt.Errorf("memscan: rule without a name")
+	}
+	compiled := &compiledRule{name: rule.Name}
+	switch {
+	case rule.Regex != "" && rule.Hex != "":
+		return nil, fmt.Errorf("memscan: rule %s has both a regex and a hex pattern", rule.Name)
+	case rule.Regex != "":
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("memscan: rule %s: %w", rule.Name, err)
+		}
+		compiled.regex = regex
+	case rule.Hex != "":
+		pattern, err := parseHexPattern(rule.Hex)
+		if err != nil {
+			return nil, fmt.Errorf("memscan: rule %s: %w", rule.Name, err)
+		}
+		compiled.hex = pattern
+	default:
+		return nil, fmt.Errorf("memscan: rule %s has no pattern", rule.Name)
+	}
+	return compiled, nil
+}
+
+// findAll returns the start and end of the non overlapping matches in data
+func (r *compiledRule) findAll(data []byte) [][]int {
+	if r.regex != nil {
+		return r.regex.FindAllIndex(data, -1)
+	}
+	return r.hex.findAll(data)
+}
+
+// value returns a match as reported, hex for binary data
+func (r *compiledRule) value(match []byte) string {
+	if r.regex != nil && utf8.Valid(match) {
+		return string(match)
+	}
+	return hex.EncodeToString(match)
+}
+
+// hexPattern is a byte pattern with wildcards, searched by its longest run of fixed bytes
+type hexPattern struct {
+	bytes []byte
+	// Set for the fixed bytes
+	fixed        []bool
+	anchor       []byte
+	anchorOffset int
+}
+
+func parseHexPattern(pattern string) (*hexPattern, error) {
+	digits := strings.Join(strings.Fields(pattern), "")
+	if len(digits) == 0 || len(digits)%2 != 0 {
+		return nil, fmt.Errorf("invalid hex pattern %q", pattern)
+	}
+
+	p := &hexPattern{}
+	for i := 0; i < len(digits); i += 2 {
+		pair := digits[i : i+2]
+		if pair == "??" {
+			p.bytes = append(p.bytes, 0)
+			p.fixed = append(p.fixed, false)
+			continue
+		}
+		b, err := hex.DecodeString(pair)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex pattern %q", pattern)
+		}
+		p.bytes = append(p.bytes, b[0])
+		p.fixed = append(p.fixed, true)
+	}
+
+	// Longest run of fixed bytes
+	for start := 0; start < len(p.bytes); {
+		if !p.fixed[start] {
+			start++
+			continue
+		}
+		end := start
+		for end < len(p.bytes) && p.fixed[end] {
+			end++
+		}
+		if end-start > len(p.anchor) {
+			p.anchor, p.anchorOffset = p.bytes[start:end], start
+		}
+		start = end
+	}
+	if len(p.anchor) == 0 {
+		return nil, fmt.Errorf("hex pattern %q has no fixed byte", pattern)
+	}
+	return p, nil
+}
+
+func (p *hexPattern) findAll(data []byte) [][]int {
+	matches := [][]int{}
+	from := 0
+	for search := p.anchorOffset; search < len(data); {
+		i := bytes.Index(data[search:], p.anchor)
+		if i < 0 {
+			break
+		}
+		start := search + i - p.anchorOffset
+		end := start + len(p.bytes)
+		if end > len(data) {
+			break
+		}
+		if start >= from && p.matchAt(data[start:end]) {
+			matches = append(matches, []int{start, end})
+			from = end
+			search = end + p.anchorOffset
+			continue
+		}
+		search += i + 1
+	}
+	return matches
+}
+
+func (p *hexPattern) matchAt(data []byte) bool {
+	for i, b := range p.bytes {
+		if p.fixed[i] && data[i] != b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package misp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTimeout = time.Second * 30
+)
+
+// Client pushes events to a MISP compatible API
+type Client struct {
+	// Auth key sent in the Authorization header
+	APIKey  string
+	BaseURL string
+
+	// Client used for requests
+	Client *http.Client
+}
+
+// Config is the configuration required to create a client
+type Config struct {
+	APIKey  string
+	BaseURL string
+	// Optional, if nil a new client will be created
+	// with a defaultTimeout
+	Client *http.Client
+}
+
+// New Creates a new client for the MISP instance at BaseURL
+func New(c *Config) *Client {
+	client := c.Client
+	if client == nil {
+		client = &http.Client{
+			Timeout: defaultTimeout,
+		}
+	}
+
+	return &Client{
+		APIKey:  c.APIKey,
+		BaseURL: c.BaseURL,
+		Client:  client,
+	}
+}
+
+// AddEvent Creates the event on MISP and returns the ID MISP assigned to it.
+//
+// The event UUID is stable per task, so MISP rejects pushing the same task twice.
+func (c *Client) AddEvent(ctx context.Context, event *Event) (string, error) {
+	body, err := json.Marshal(map[string]*Event{"Event": event})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/events/add", c.BaseURL), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", c.APIKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 401, 403:
+		return "", fmt.Errorf("not authorized")
+	default:
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024)) // Limit reading incase response is massive for some reason
+		return "", fmt.Errorf("bad response code: %d, body: %s", resp.StatusCode, message)
+	}
+
+	response := struct {
+		Event struct {
+			ID string `json:"id"`
+		} `json:"Event"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("misp: add event response marshalling error: %w", err)
+	}
+
+	return response.Event.ID, nil
+}
@@ -0,0 +1,313 @@
+// Package misp exports cuckoo analysis results as MISP events and pushes them to a MISP instance
+package misp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/reporttime"
+	"github.com/godaddy/go-cukoo/internal/uuid"
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+// fileTemplateUUID is the UUID of the file object template of misp-objects
+const fileTemplateUUID = "688c46fb-5edb-40a3-8273-1af7923e2215"
+
+// namespace is used to derive the UUIDs of events and attributes, so re-exports update instead of duplicating
+var namespace = uuid.V5(uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7"), "github.com/godaddy/go-cuckoo/misp")
+
+// Threat levels
+const (
+	ThreatLevelHigh      = "1"
+	ThreatLevelMedium    = "2"
+	ThreatLevelLow       = "3"
+	ThreatLevelUndefined = "4"
+)
+
+// Event is a MISP event
+type Event struct {
+	UUID          string       `json:"uuid"`
+	Info          string       `json:"info"`
+	Date          string       `json:"date"`
+	ThreatLevelID string       `json:"threat_level_id"`
+	Analysis      string       `json:"analysis"`
+	Distribution  string       `json:"distribution"`
+	Published     bool         `json:"published"`
+	Attributes    []*Attribute `json:"Attribute"`
+	Objects       []*Object    `json:"Object"`
+	Tags          []*Tag       `json:"Tag"`
+}
+
+// Attribute is a MISP attribute
+type Attribute struct {
+	UUID           string `json:"uuid"`
+	Type           string `json:"type"`
+	Category       string `json:"category"`
+	Value          string `json:"value"`
+	ToIDS          bool   `json:"to_ids"`
+	Comment        string `json:"comment,omitempty"`
+	ObjectRelation string `json:"object_relation,omitempty"`
+}
+
+// Object is a MISP object, such as a dropped file
+type Object struct {
+	UUID         string       `json:"uuid"`
+	Name         string       `json:"name"`
+	MetaCategory string       `json:"meta-category"`
+	TemplateUUID string       `json:"template_uuid"`
+	Comment      string       `json:"comment,omitempty"`
+	Attributes   []*Attribute `json:"Attribute"`
+}
+
+// Tag is a MISP tag
+type Tag struct {
+	Name string `json:"name"`
+}
+
+// Options control the export
+type Options struct {
+	// Options used to extract the network indicators and dropped files, see ioc.Extract
+	IOC *ioc.Options
+	// Rules deciding the to_ids flag of each attribute, defaults to DefaultToIDSRules
+	ToIDS []*ToIDSRule
+	// Distribution of the event, defaults to "0" (your organisation only)
+	Distribution string
+	// Threat level of the event, by default it is derived from the score
+	ThreatLevel string
+}
+
+// ToIDSRule sets the to_ids flag of the attributes it matches.  The first matching rule wins.
+type ToIDSRule struct {
+	// Attribute types the rule applies to, empty for all types
+	Types []string `json:"types"`
+	// Attribute categories the rule applies to, empty for all categories
+	Categories []string `json:"categories"`
+	// Only match if the task scored at least this much
+	MinScore float64 `json:"min_score"`
+	// The to_ids flag to set
+	ToIDS bool `json:"to_ids"`
+}
+
+// DefaultToIDSRules flag hashes and network indicators for detection once a task looks at least suspicious
+func DefaultToIDSRules() []*ToIDSRule {
+	return []*ToIDSRule{
+		{Types: []string{"md5", "sha1", "sha256", "sha512", "ip-dst", "domain", "url"}, MinScore: 2, ToIDS: true},
+		{ToIDS: false},
+	}
+}
+
+// matches returns true if the rule applies to the attribute
+func (r *ToIDSRule) matches(attribute *Attribute, score float64) bool {
+	return score >= r.MinScore && (len(r.Types) == 0 || contains(r.Types, attribute.Type)) &&
+		(len(r.Categories) == 0 || contains(r.Categories, attribute.Category))
+}
+
+// Export Converts a reported task into a MISP event.
+//
+// sample holds the hashes of the analyzed file and may be nil for URL tasks.
+func Export(task *cuckoo.Task, sample *cuckoo.Sample, report *cuckoo.Report, opts *Options) *Event {
+	if opts == nil {
+		opts = &Options{}
+	}
+	rules := opts.ToIDS
+	if rules == nil {
+		rules = DefaultToIDSRules()
+	}
+	score := 0.0
+	if report.Info != nil {
+		score = report.Info.Score
+	}
+
+	eventUUID := uuid.V5(namespace, "event|"+strconv.Itoa(task.ID))
+	e := &exporter{eventUUID: eventUUID.String(), rules: rules, score: score, seen: map[string]bool{}}
+
+	event := &Event{
+		UUID:          e.eventUUID,
+		Info:          fmt.Sprintf("Cuckoo analysis #%d: %s", task.ID, targetName(task, report)),
+		Date:          reporttime.Analysis(task, report).Format("2006-01-02"),
+		ThreatLevelID: opts.ThreatLevel,
+		Analysis:      "2",
+		Distribution:  opts.Distribution,
+		Attributes:    []*Attribute{},
+		Objects:       []*Object{},
+		Tags:          []*Tag{},
+	}
+	if event.ThreatLevelID == "" {
+		event.ThreatLevelID = threatLevel(report)
+	}
+	if event.Distribution == "" {
+		event.Distribution = "0"
+	}
+
+	// The analyzed sample
+	if sample != nil {
+		for _, hash := range [][2]string{{"md5", sample.Md5}, {"sha1", sample.Sha1}, {"sha256", sample.Sha256}, {"sha512", sample.Sha512}, {"ssdeep", sample.Ssdeep}} {
+			event.Attributes = e.add(event.Attributes, "", "", hash[0], "Payload delivery", hash[1], "analyzed sample")
+		}
+		if report.Target != nil && report.Target.File != nil {
+			event.Attributes = e.add(event.Attributes, "", "", "filename", "Payload delivery", report.Target.File.Name, "analyzed sample")
+		}
+	} else if task.Category == "url" {
+		event.Attributes = e.add(event.Attributes, "", "", "url", "Payload delivery", task.Target, "analyzed url")
+	}
+
+	// Network activity
+	set := ioc.Extract(report, opts.IOC)
+	for _, ip := range set.IPs {
+		event.Attributes = e.add(event.Attributes, "", "", "ip-dst", "Network activity", ip, "")
+	}
+	for _, domain := range set.Domains {
+		event.Attributes = e.add(event.Attributes, "", "", "domain", "Network activity", domain, "")
+	}
+	for _, u := range set.URLs {
+		event.Attributes = e.add(event.Attributes, "", "", "url", "Network activity", u, "")
+	}
+	for _, userAgent := range set.UserAgents {
+		event.Attributes = e.add(event.Attributes, "", "", "user-agent", "Network activity", userAgent, "")
+	}
+
+	// Dropped files
+	for _, file := range set.DroppedFiles {
+		object := &Object{
+			UUID:         uuid.V5(namespace, "object|"+e.eventUUID+"|"+file.SHA256+"|"+file.Path).String(),
+			Name:         "file",
+			MetaCategory: "file",
+			TemplateUUID: fileTemplateUUID,
+			Comment:      "dropped file",
+			Attributes:   []*Attribute{},
+		}
+		for _, attribute := range [][3]string{
+			{"filename", "filename", file.Name},
+			{"fullpath", "text", file.Path},
+			{"size-in-bytes", "size-in-bytes", strconv.FormatInt(file.Size, 10)},
+			{"text", "text", file.Type},
+			{"md5", "md5", file.MD5},
+			{"sha1", "sha1", file.SHA1},
+			{"sha256", "sha256", file.SHA256},
+			{"sha512", "sha512", file.SHA512},
+		} {
+			object.Attributes = e.add(object.Attributes, object.UUID, attribute[0], attribute[1], "Artifacts dropped", attribute[2], "")
+		}
+		event.Objects = append(event.Objects, object)
+	}
+
+	event.Tags = signatureTags(report)
+	return event
+}
+
+// ExportTask Fetches a task, its sample and its report from cuckoo with ioc.FetchTask and exports them, see Export.
+func ExportTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*Event, error) {
+	withMachine := &Options{}
+	if opts != nil {
+		*withMachine = *opts
+	}
+	data, err := ioc.FetchTask(ctx, c, taskID, withMachine.IOC)
+	if err != nil {
+		return nil, err
+	}
+	withMachine.IOC = data.Options
+
+	return Export(data.Task, data.Sample, data.Report, withMachine), nil
+}
+
+// exporter builds the attributes of an event
+type exporter struct {
+	eventUUID string
+	rules     []*ToIDSRule
+	score     float64
+	seen      map[string]bool
+}
+
+// add appends an attribute to attributes unless its value is empty or it is a duplicate within scope
+func (e *exporter) add(attributes []*Attribute, scope, relation, attributeType, category, value, comment string) []*Attribute {
+	key := scope + "|" + relation + "|" + attributeType + "|" + value
+	if value == "" || e.seen[key] {
+		return attributes
+	}
+	e.seen[key] = true
+
+	attribute := &Attribute{
+		UUID:     uuid.V5(namespace, "attribute|"+e.eventUUID+"|"+key).String(),
+		Type:     attributeType,
+		Category: category,
+		Value:    value,
+		Comment:  comment,
+
+		ObjectRelation: relation,
+	}
+	for _, rule := range e.rules {
+		if rule.matches(attribute, e.score) {
+			attribute.ToIDS = rule.ToIDS
+			break
+		}
+	}
+	return append(attributes, attribute)
+}
+
+// signatureTags returns tags for the names, malware families and ATT&CK techniques of the matched signatures
+func signatureTags(report *cuckoo.Report) []*Tag {
+	names := map[string]bool{}
+	for _, signature := range report.Signatures {
+		names[fmt.Sprintf("cuckoo:signature=%q", signature.Name)] = true
+		for _, family := range signature.Families {
+			names[fmt.Sprintf("cuckoo:family=%q", family)] = true
+		}
+		for id, ttp := range signature.TTP {
+			if ttp != nil && ttp.Short != "" {
+				names[fmt.Sprintf("misp-galaxy:mitre-attack-pattern=%q", ttp.Short+" - "+id)] = true
+			} else {
+				names[fmt.Sprintf("mitre-attack:technique=%q", id)] = true
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	tags := make([]*Tag, 0, len(sorted))
+	for _, name := range sorted {
+		tags = append(tags, &Tag{Name: name})
+	}
+	return tags
+}
+
+// threatLevel maps the cuckoo score to a threat level
+func threatLevel(report *cuckoo.Report) string {
+	if report.Info == nil {
+		return ThreatLevelUndefined
+	}
+	switch {
+	case report.Info.Score >= 7:
+		return ThreatLevelHigh
+	case report.Info.Score >= 4:
+		return ThreatLevelMedium
+	default:
+		return ThreatLevelLow
+	}
+}
+
+// targetName returns a readable name for what the task analyzed
+func targetName(task *cuckoo.Task, report *cuckoo.Report) string {
+	if report.Target != nil && report.Target.File != nil && report.Target.File.Name != "" {
+		return report.Target.File.Name
+	}
+	if report.Target != nil && report.Target.URL != "" {
+		return report.Target.URL
+	}
+	return task.Target
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package misp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+var (
+	testTask   = &cuckoo.Task{ID: 42, Category: "file"}
+	testSample = &cuckoo.Sample{
+		Md5:    "5d41402abc4b2a76b9719d911017c592",
+		Sha1:   "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+		Sha256: "0d8f0c3aa6b9f34d4a2dcaabb8a0a0a3a4b6f6ad5f4b5b8c8e0f1d2c3b4a5968",
+	}
+	testIOCOptions = &ioc.Options{Machines: []*cuckoo.Machine{{IP: "192.168.56.101", ResultserverIP: "192.168.56.1"}}}
+)
+
+func TestExport(t *testing.T) {
+	event := Export(testTask, testSample, testutil.LoadReport(t), &Options{IOC: testIOCOptions})
+
+	if event.Info != "Cuckoo analysis #42: invoice.exe" || event.Date != "2020-02-11" || event.ThreatLevelID != ThreatLevelMedium {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	types := map[string]int{}
+	for _, attribute := range event.Attributes {
+		types[attribute.Type]++
+		switch attribute.Type {
+		case "sha256", "ip-dst", "domain", "url":
+			if !attribute.ToIDS {
+				t.Errorf("%s should be flagged for ids", attribute.Type)
+			}
+		case "user-agent", "filename":
+			if attribute.ToIDS {
+				t.Errorf("%s should not be flagged for ids", attribute.Type)
+			}
+		}
+	}
+	expected := map[string]int{"md5": 1, "sha1": 1, "sha256": 1, "filename": 1, "ip-dst": 2, "domain": 1, "url": 1, "user-agent": 1}
+	for attributeType, count := range expected {
+		if types[attributeType] != count {
+			t.Errorf("expected %d %s attributes, got %d", count, attributeType, types[attributeType])
+		}
+	}
+
+	if len(event.Objects) != 2 || event.Objects[0].Attributes[0].ObjectRelation != "filename" {
+		t.Errorf("unexpected objects %+v", event.Objects)
+	}
+
+	tags := map[string]bool{}
+	for _, tag := range event.Tags {
+		tags[tag.Name] = true
+	}
+	for _, tag := range []string{`cuckoo:signature="persistence_autorun"`, `cuckoo:family="zeus"`, `misp-galaxy:mitre-attack-pattern="Process Injection - T1055"`, `mitre-attack:technique="T1071"`} {
+		if !tags[tag] {
+			t.Errorf("missing tag %s in %v", tag, tags)
+		}
+	}
+
+	// Exporting again should give the same UUIDs
+	again := Export(testTask, testSample, testutil.LoadReport(t), &Options{IOC: testIOCOptions})
+	if again.UUID != event.UUID || again.Attributes[0].UUID != event.Attributes[0].UUID {
+		t.Errorf("uuids are not stable")
+	}
+}
+
+func TestExportToIDSRules(t *testing.T) {
+	event := Export(testTask, testSample, testutil.LoadReport(t), &Options{
+		ToIDS: []*ToIDSRule{{Categories: []string{"Network activity"}, ToIDS: true}},
+	})
+
+	for _, attribute := range event.Attributes {
+		if attribute.ToIDS != (attribute.Category == "Network activity") {
+			t.Errorf("rule not applied to %s %s", attribute.Category, attribute.Type)
+		}
+	}
+}
+
+func TestAddEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/events/add" || r.Header.Get("Authorization") != "key" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		body := struct {
+			Event *Event `json:"Event"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if body.Event == nil || body.Event.Info == "" {
+			t.Errorf("event was not sent")
+			w.WriteHeader(400)
+			return
+		}
+		w.Write([]byte(`{"Event": {"id": "17", "uuid": "` + body.Event.UUID + `"}}`))
+	}))
+	defer server.Close()
+
+	c := New(&Config{APIKey: "key", BaseURL: server.URL})
+	id, err := c.AddEvent(context.Background(), Export(testTask, testSample, testutil.LoadReport(t), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "17" {
+		t.Errorf("unexpected event id %s", id)
+	}
+}
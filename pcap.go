@@ -9,6 +9,11 @@ import (
 
 // PcapGet Returns the content of the PCAP associated with the given task.
 func (c *Client) PcapGet(ctx context.Context, taskID int) (io.ReadCloser, error) {
+	cacheName := pcapCacheName(taskID)
+	if cached, ok := c.Cache.get(cacheName); ok {
+		return cached, nil
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/pcap/get/%d", c.BaseURL, taskID), nil)
 	if err != nil {
 		return nil, err
@@ -27,5 +32,5 @@ func (c *Client) PcapGet(ctx context.Context, taskID int) (io.ReadCloser, error)
 		return nil, fmt.Errorf("bad response code: %d", resp.StatusCode)
 	}
 
-	return resp.Body, nil
+	return c.putTask(ctx, taskID, cacheName, resp.Body), nil
 }
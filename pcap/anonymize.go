@@ -0,0 +1,509 @@
+package pcap
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// AnonymizeKeySize is the size of the key of an Anonymizer
+const AnonymizeKeySize = 32
+
+// AnonymizeOptions configures an Anonymizer
+type AnonymizeOptions struct {
+	// The guest and resultserver addresses of Machines are rewritten.  Their resultserver traffic is dropped
+	// unless IncludeResultserver is set.
+	Options
+
+	// Secret key of the pseudonyms, AnonymizeKeySize bytes.  The same key gives the same pseudonyms across
+	// captures, a random key is used if it is empty.
+	Key []byte
+	// Other internal networks whose addresses are rewritten, like the subnet of the sandbox
+	Networks []*net.IPNet
+	// Optional, only the packets of the flows matching it are kept
+	Flows *FlowFilter
+}
+
+// FlowFilter selects flows by their original addresses.  Empty fields match any flow.
+type FlowFilter struct {
+	// Addresses or CIDR ranges of either end of the flow
+	Hosts []string
+	// Port of either end of the flow, fragments other than the first one have no ports and don't match
+	Ports []int
+	// "tcp", "udp", "icmp" or "icmpv6"
+	Protocols []string
+}
+
+// AnonymizeStats counts the packets of an anonymized capture
+type AnonymizeStats struct {
+	Packets int `json:"packets"`
+	Written int `json:"written"`
+	Dropped int `json:"dropped"`
+}
+
+// Anonymizer rewrites the internal addresses of captures so they can be shared.
+//
+// Internal IP addresses and every unicast MAC address are replaced with prefix-preserving pseudonyms
+// (Crypto-PAn): two addresses sharing a prefix have pseudonyms sharing a prefix of the same length, so the layout
+// of the sandbox network is kept without revealing it.  The other addresses, those the malware talked to, are
+// kept.  Addresses are rewritten in the link, ARP and IP headers, in the headers quoted by ICMP errors and in the
+// neighbor discovery messages of ICMPv6.  Checksums are recomputed.  Addresses in payloads, like DNS answers, are
+// not rewritten.
+//
+// Packets that can't be decoded, packets that are neither IP nor ARP, and neighbor discovery messages with options
+// other than link-layer addresses, prefixes and MTU are dropped since they could hold addresses that are not
+// rewritten.  An Anonymizer is not safe for concurrent use.
+type Anonymizer struct {
+	opts     AnonymizeOptions
+	pan      *cryptoPAn
+	internal []*net.IPNet
+	excluded []*excludedEndpoint
+	hosts    []*net.IPNet
+
+	ips  map[string]net.IP
+	macs map[string]net.HardwareAddr
+}
+
+// NewAnonymizer Returns an anonymizer, see AnonymizeOptions
+func NewAnonymizer(opts *AnonymizeOptions) (*Anonymizer, error) {
+	a := &Anonymizer{ips: map[string]net.IP{}, macs: map[string]net.HardwareAddr{}}
+	if opts != nil {
+		a.opts = *opts
+	}
+
+	key := a.opts.Key
+	if len(key) == 0 {
+		key = make([]byte, AnonymizeKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	pan, err := newCryptoPAn(key)
+	if err != nil {
+		return nil, err
+	}
+	a.pan = pan
+
+	for _, machine := range a.opts.Machines {
+		for _, address := range []string{machine.IP, machine.ResultserverIP} {
+			if ip := net.ParseIP(address); ip != nil {
+				a.internal = append(a.internal, hostNet(ip))
+			}
+		}
+	}
+	a.internal = append(a.internal, a.opts.Networks...)
+	a.excluded = excludedEndpoints(&a.opts.Options)
+
+	if a.opts.Flows != nil {
+		for _, host := range a.opts.Flows.Hosts {
+			if _, network, err := net.ParseCIDR(host); err == nil {
+				a.hosts = append(a.hosts, network)
+				continue
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return nil, fmt.Errorf("pcap: invalid host %q", host)
+			}
+			a.hosts = append(a.hosts, hostNet(ip))
+		}
+	}
+
+	return a, nil
+}
+
+// AnonymizeTask Downloads the capture of the specified task ID and writes it anonymized to w, adding the machine
+// the task ran on to the internal addresses.  See Anonymizer.
+func AnonymizeTask(ctx context.Context, c *cuckoo.Client, taskID int, w io.Writer, opts *AnonymizeOptions) (*AnonymizeStats, error) {
+	withMachine := &AnonymizeOptions{}
+	if opts != nil {
+		*withMachine = *opts
+	}
+	machineOpts, err := withTaskMachine(ctx, c, taskID, &withMachine.Options)
+	if err != nil {
+		return nil, err
+	}
+	withMachine.Options = *machineOpts
+
+	a, err := NewAnonymizer(withMachine)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.PcapGet(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return a.Anonymize(body, w)
+}
+
+// Anonymize Reads a capture and writes it anonymized to w, packet by packet.  The output has the format and link
+// type of the input.
+func (a *Anonymizer) Anonymize(r io.Reader, w io.Writer) (*AnonymizeStats, error) {
+	reader, err := NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(w)
+	writer, err := NewWriter(buffered, reader.Header())
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &AnonymizeStats{}
+	linkType := reader.Header().LinkType
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Packets++
+		if !a.rewrite(linkType, record) {
+			stats.Dropped++
+			continue
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+		stats.Written++
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// IP returns the pseudonym of an internal address, other addresses are returned as is
+func (a *Anonymizer) IP(ip net.IP) net.IP {
+	if !a.isInternal(ip) {
+		return ip
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	if pseudonym, ok := a.ips[string(ip)]; ok {
+		return pseudonym
+	}
+	pseudonym := net.IP(a.pan.anonymize(ip))
+	a.ips[string(ip)] = pseudonym
+	return pseudonym
+}
+
+// MAC returns the pseudonym of a unicast MAC address, broadcast and multicast addresses are returned as is
+func (a *Anonymizer) MAC(mac net.HardwareAddr) net.HardwareAddr {
+	if len(mac) == 0 || mac[0]&1 == 1 {
+		return mac
+	}
+	if pseudonym, ok := a.macs[string(mac)]; ok {
+		return pseudonym
+	}
+	pseudonym := net.HardwareAddr(a.pan.anonymize(mac))
+	// Stay unicast
+	pseudonym[0] &^= 1
+	a.macs[string(mac)] = pseudonym
+	return pseudonym
+}
+
+func (a *Anonymizer) isInternal(ip net.IP) bool {
+	for _, network := range a.internal {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite anonymizes a record in place, it returns false if the record must be dropped
+func (a *Anonymizer) rewrite(linkType uint32, record *Record) bool {
+	p, err := Decode(linkType, record)
+	if err != nil {
+		return false
+	}
+	if p.NetworkOffset < 0 && p.EtherType != EtherTypeARP {
+		return false
+	}
+	if !a.keep(p) {
+		return false
+	}
+
+	data := record.Data
+	switch linkType {
+	case LinkTypeEthernet:
+		copy(data[0:6], a.MAC(p.DstMAC))
+		copy(data[6:12], a.MAC(p.SrcMAC))
+	case LinkTypeLinuxSLL:
+		if binary.BigEndian.Uint16(data[4:6]) == 6 {
+			copy(data[6:12], a.MAC(net.HardwareAddr(data[6:12])))
+		}
+	}
+
+	if p.EtherType == EtherTypeARP {
+		return a.rewriteARP(data[linkHeaderLength(linkType, data):])
+	}
+
+	oldSrc, oldDst := append(net.IP{}, p.SrcIP...), append(net.IP{}, p.DstIP...)
+	copy(p.SrcIP, a.IP(p.SrcIP))
+	copy(p.DstIP, a.IP(p.DstIP))
+	p.UpdateFragmentChecksum(oldSrc, oldDst)
+	if p.IPVersion == 4 && p.Protocol == ProtocolICMP && !p.Fragment {
+		a.rewriteICMP(p)
+	}
+	if p.Protocol == ProtocolICMPv6 && p.TransportOffset >= 0 && !a.rewriteICMPv6(p) {
+		return false
+	}
+	p.FixChecksums()
+	return true
+}
+
+// keep returns true if a packet is not resultserver traffic and matches the flow filter
+func (a *Anonymizer) keep(p *Packet) bool {
+	s := &summarizer{excluded: a.excluded}
+	if p.TransportOffset >= 0 && (s.isExcluded(p.SrcIP, p.SrcPort) || s.isExcluded(p.DstIP, p.DstPort)) {
+		return false
+	}
+
+	filter := a.opts.Flows
+	if filter == nil {
+		return true
+	}
+	if p.NetworkOffset < 0 {
+		return false
+	}
+	if len(filter.Protocols) > 0 {
+		name := protocolName(p.Protocol)
+		found := false
+		for _, protocol := range filter.Protocols {
+			found = found || strings.EqualFold(protocol, name)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(a.hosts) > 0 {
+		found := false
+		for _, network := range a.hosts {
+			found = found || network.Contains(p.SrcIP) || network.Contains(p.DstIP)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(filter.Ports) > 0 {
+		if p.TransportOffset < 0 {
+			return false
+		}
+		found := false
+		for _, port := range filter.Ports {
+			found = found || port == int(p.SrcPort) || port == int(p.DstPort)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteARP rewrites the addresses of an ethernet IPv4 ARP packet, other ARP packets are dropped
+func (a *Anonymizer) rewriteARP(arp []byte) bool {
+	if len(arp) < 28 || binary.BigEndian.Uint16(arp[0:2]) != 1 || binary.BigEndian.Uint16(arp[2:4]) != EtherTypeIPv4 ||
+		arp[4] != 6 || arp[5] != 4 {
+		return false
+	}
+	copy(arp[8:14], a.MAC(net.HardwareAddr(arp[8:14])))
+	copy(arp[14:18], a.IP(net.IP(arp[14:18])))
+	copy(arp[18:24], a.MAC(net.HardwareAddr(arp[18:24])))
+	copy(arp[24:28], a.IP(net.IP(arp[24:28])))
+	return true
+}
+
+// rewriteICMP rewrites the IPv4 header quoted by an ICMP error
+func (a *Anonymizer) rewriteICMP(p *Packet) {
+	data := p.Data
+	offset := p.NetworkOffset + int(data[p.NetworkOffset]&0x0f)*4
+	end := p.NetworkOffset + p.IPLength
+	if end > len(data) {
+		end = len(data)
+	}
+	if end < offset+8+20 {
+		return
+	}
+	switch data[offset] {
+	case 3, 4, 5, 11, 12: // Unreachable, source quench, redirect, time exceeded, parameter problem
+	default:
+		return
+	}
+
+	quoted := data[offset+8 : end]
+	headerLength := int(quoted[0]&0x0f) * 4
+	if quoted[0]>>4 != 4 || headerLength < 20 || len(quoted) < headerLength {
+		return
+	}
+	copy(quoted[12:16], a.IP(net.IP(quoted[12:16])))
+	copy(quoted[16:20], a.IP(net.IP(quoted[16:20])))
+	setChecksum(quoted[:headerLength], 10, 0)
+
+	if end == p.NetworkOffset+p.IPLength {
+		setChecksum(data[offset:end], 2, 0)
+	}
+}
+
+// rewriteICMPv6 rewrites the IPv6 header quoted by an ICMPv6 error and the addresses of a neighbor discovery
+// message, it returns false if the message holds options that are not rewritten
+func (a *Anonymizer) rewriteICMPv6(p *Packet) bool {
+	end := p.NetworkOffset + p.IPLength
+	if end > len(p.Data) {
+		end = len(p.Data)
+	}
+	message := p.Data[p.TransportOffset:end]
+	if len(message) < 8 {
+		return false
+	}
+
+	var options int
+	switch message[0] {
+	case 1, 2, 3, 4: // Unreachable, packet too big, time exceeded, parameter problem
+		quoted := message[8:]
+		if len(quoted) >= 40 && quoted[0]>>4 == 6 {
+			copy(quoted[8:24], a.IP(net.IP(quoted[8:24])))
+			copy(quoted[24:40], a.IP(net.IP(quoted[24:40])))
+		}
+		return true
+	case 133: // Router solicitation
+		options = 8
+	case 134: // Router advertisement
+		options = 16
+	case 135, 136: // Neighbor solicitation and advertisement, with a target address
+		options = 24
+	case 137: // Redirect, with a target and a destination address
+		options = 40
+	default:
+		return true
+	}
+	if len(message) < options {
+		return false
+	}
+	for offset := 8; offset+16 <= options && message[0] >= 135; offset += 16 {
+		copy(message[offset:offset+16], a.IP(net.IP(message[offset:offset+16])))
+	}
+
+	for rest := message[options:]; len(rest) > 0; {
+		if len(rest) < 8 || rest[1] == 0 || len(rest) < int(rest[1])*8 {
+			return false
+		}
+		option := rest[:int(rest[1])*8]
+		switch option[0] {
+		case 1, 2: // Source and target link-layer addresses
+			if len(option) != 8 {
+				return false
+			}
+			copy(option[2:8], a.MAC(net.HardwareAddr(option[2:8])))
+		case 3: // Prefix information, the pseudonym keeps the prefix length
+			if len(option) != 32 || option[2] > 128 {
+				return false
+			}
+			copy(option[16:32], a.IP(net.IP(option[16:32])).Mask(net.CIDRMask(int(option[2]), 128)))
+		case 5: // MTU
+		default:
+			return false
+		}
+		rest = rest[len(option):]
+	}
+	return true
+}
+
+// linkHeaderLength returns the offset of the network layer of a frame
+func linkHeaderLength(linkType uint32, data []byte) int {
+	switch linkType {
+	case LinkTypeEthernet:
+		offset := 14
+		for binary.BigEndian.Uint16(data[offset-2:offset]) == EtherTypeVLAN {
+			offset += 4
+		}
+		return offset
+	case LinkTypeLinuxSLL:
+		return 16
+	case LinkTypeNull:
+		return 4
+	}
+	return 0
+}
+
+func protocolName(protocol uint8) string {
+	switch protocol {
+	case ProtocolTCP:
+		return "tcp"
+	case ProtocolUDP:
+		return "udp"
+	case ProtocolICMP:
+		return "icmp"
+	case ProtocolICMPv6:
+		return "icmpv6"
+	}
+	return fmt.Sprint(protocol)
+}
+
+// hostNet returns the network holding only ip
+func hostNet(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// cryptoPAn is the prefix-preserving pseudonymization of Xu et al., extended to addresses of any length up to
+// 128 bits.  Bit i of the pseudonym is bit i of the address flipped by a pseudorandom function of the bits before
+// it.
+type cryptoPAn struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+}
+
+func newCryptoPAn(key []byte) (*cryptoPAn, error) {
+	if len(key) != AnonymizeKeySize {
+		return nil, fmt.Errorf("pcap: anonymization key must be %d bytes, got %d", AnonymizeKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	c := &cryptoPAn{block: block}
+	block.Encrypt(c.pad[:], key[16:32])
+	return c, nil
+}
+
+func (c *cryptoPAn) anonymize(address []byte) []byte {
+	pseudonym := make([]byte, len(address))
+	var input, output [aes.BlockSize]byte
+	for i := 0; i < len(address)*8; i++ {
+		// The first i bits of the address, padded with the rest of the pad
+		input = c.pad
+		copy(input[:i/8], address[:i/8])
+		if bits := uint(i % 8); bits > 0 {
+			mask := byte(0xff << (8 - bits))
+			input[i/8] = address[i/8]&mask | c.pad[i/8]&^mask
+		}
+
+		c.block.Encrypt(output[:], input[:])
+		pseudonym[i/8] |= (output[0] >> 7) << (7 - uint(i%8))
+	}
+
+	for i := range pseudonym {
+		pseudonym[i] ^= address[i]
+	}
+	return pseudonym
+}
@@ -0,0 +1,419 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+// testAnonymizeKey is the key of the reference Crypto-PAn implementation
+var testAnonymizeKey = []byte{21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16,
+	216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2}
+
+func TestCryptoPAn(t *testing.T) {
+	pan, err := newCryptoPAn(testAnonymizeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, expected := range map[string]string{
+		"128.11.68.132":   "135.242.180.132",
+		"129.118.74.4":    "134.136.186.123",
+		"130.132.252.244": "133.68.164.234",
+		"141.223.7.43":    "141.167.8.160",
+		"192.102.249.13":  "252.138.62.131",
+	} {
+		if got := net.IP(pan.anonymize(net.ParseIP(address).To4())).String(); got != expected {
+			t.Errorf("expected %s for %s, got %s", expected, address, got)
+		}
+	}
+
+	if _, err := newCryptoPAn([]byte("short")); err == nil {
+		t.Errorf("expected an error for a short key")
+	}
+}
+
+// arpFrame returns the ARP request of the guest for the resultserver
+func arpFrame() []byte {
+	frame := &bytes.Buffer{}
+	frame.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	frame.Write(guestMAC)
+	binary.Write(frame, binary.BigEndian, []uint16{EtherTypeARP, 1, EtherTypeIPv4})
+	frame.Write([]byte{6, 4, 0, 1})
+	frame.Write(guestMAC)
+	frame.Write(net.ParseIP(guest).To4())
+	frame.Write(make([]byte, 6))
+	frame.Write(net.ParseIP(resultserver).To4())
+	return frame.Bytes()
+}
+
+// icmpUnreachable returns the port unreachable sent by the guest for a late DNS response
+func icmpUnreachable(t *testing.T) []byte {
+	response := buildFrame(t, &testPacket{protocol: ProtocolUDP, src: "8.8.8.8:53", dst: guest + ":51000", payload: []byte("late")})
+	quoted := response[14:]
+
+	icmp := append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, quoted...)
+	setChecksum(icmp, 2, 0)
+	frame := &bytes.Buffer{}
+	frame.Write(hostMAC)
+	frame.Write(guestMAC)
+	binary.Write(frame, binary.BigEndian, EtherTypeIPv4)
+	frame.Write([]byte{0x45, 0})
+	binary.Write(frame, binary.BigEndian, uint16(20+len(icmp)))
+	frame.Write([]byte{0, 2, 0, 0, 64, ProtocolICMP, 0, 0})
+	frame.Write(net.ParseIP(guest).To4())
+	frame.Write(net.ParseIP("8.8.8.8").To4())
+	frame.Write(icmp)
+	return frame.Bytes()
+}
+
+// icmpv6Frame returns the ethernet frame of an ICMPv6 message sent by the guest to the all nodes address
+func icmpv6Frame(t *testing.T, icmp []byte) []byte {
+	frame := &bytes.Buffer{}
+	frame.Write([]byte{0x33, 0x33, 0, 0, 0, 1})
+	frame.Write(guestMAC)
+	binary.Write(frame, binary.BigEndian, EtherTypeIPv6)
+	frame.Write([]byte{0x60, 0, 0, 0})
+	binary.Write(frame, binary.BigEndian, uint16(len(icmp)))
+	frame.Write([]byte{ProtocolICMPv6, 255})
+	frame.Write(net.ParseIP("fe80::a00:27ff:fe11:2233"))
+	frame.Write(net.ParseIP("ff02::1"))
+	frame.Write(icmp)
+
+	packet, err := Decode(LinkTypeEthernet, &Record{Length: frame.Len(), Data: frame.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.FixChecksums()
+	return packet.Data
+}
+
+// udpFragments returns the ethernet frames of a UDP datagram from the guest split into two fragments, the first
+// holding the UDP header and 8 bytes of payload
+func udpFragments(t *testing.T, src, dst string, payload []byte) [][]byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	v4 := srcIP.To4() != nil
+	if v4 {
+		srcIP, dstIP = srcIP.To4(), dstIP.To4()
+	}
+	udp := &bytes.Buffer{}
+	binary.Write(udp, binary.BigEndian, []uint16{53, 51000, uint16(8 + len(payload)), 0})
+	udp.Write(payload)
+	segment := udp.Bytes()
+	setChecksum(segment, 6, pseudoHeaderSum(srcIP, dstIP, ProtocolUDP, len(segment)))
+
+	frames := [][]byte{}
+	for i, part := range [][]byte{segment[:16], segment[16:]} {
+		frame := &bytes.Buffer{}
+		frame.Write(hostMAC)
+		frame.Write(guestMAC)
+		if v4 {
+			flagsFragment := uint16(i * 2)
+			if i == 0 {
+				flagsFragment |= 0x2000
+			}
+			binary.Write(frame, binary.BigEndian, []uint16{EtherTypeIPv4, 0x4500, uint16(20 + len(part)), 7, flagsFragment})
+			frame.Write([]byte{64, ProtocolUDP, 0, 0})
+		} else {
+			binary.Write(frame, binary.BigEndian, []uint16{EtherTypeIPv6, 0x6000, 0, uint16(8 + len(part))})
+			frame.Write([]byte{44, 64})
+		}
+		frame.Write(srcIP)
+		frame.Write(dstIP)
+		if !v4 {
+			offsetMore := uint16(i * 16)
+			if i == 0 {
+				offsetMore |= 1
+			}
+			frame.Write([]byte{ProtocolUDP, 0})
+			binary.Write(frame, binary.BigEndian, offsetMore)
+			binary.Write(frame, binary.BigEndian, uint32(7))
+		}
+		frame.Write(part)
+
+		packet, err := Decode(LinkTypeEthernet, &Record{Length: frame.Len(), Data: frame.Bytes()})
+		if err != nil || !packet.Fragment {
+			t.Fatalf("bad fragment %v", err)
+		}
+		packet.FixChecksums()
+		frames = append(frames, packet.Data)
+	}
+	return frames
+}
+
+// appendRecord adds a frame to a capture written by writeTestPcap
+func appendRecord(capture, frame []byte) []byte {
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(epoch.Add(4*time.Second).Unix()))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(header[12:16], uint32(len(frame)))
+	return append(append(capture, header...), frame...)
+}
+
+// readPackets decodes a capture, checking its checksums
+func readPackets(t *testing.T, capture []byte) []*Packet {
+	reader, err := NewReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := []*Packet{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := Decode(reader.Header().LinkType, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.IPVersion == 4 {
+			header := p.Data[p.NetworkOffset : p.NetworkOffset+20]
+			if finishChecksum(sumWords(0, header)) != 0 {
+				t.Errorf("bad IP checksum in packet %d", len(packets))
+			}
+		}
+		if p.Protocol == ProtocolTCP {
+			segment := p.Data[p.TransportOffset:]
+			if finishChecksum(sumWords(pseudoHeaderSum(p.SrcIP, p.DstIP, p.Protocol, len(segment)), segment)) != 0 {
+				t.Errorf("bad TCP checksum in packet %d", len(packets))
+			}
+		}
+		if p.Protocol == ProtocolICMPv6 {
+			icmp := p.Data[p.TransportOffset:]
+			if finishChecksum(sumWords(pseudoHeaderSum(p.SrcIP, p.DstIP, p.Protocol, len(icmp)), icmp)) != 0 {
+				t.Errorf("bad ICMPv6 checksum in packet %d", len(packets))
+			}
+		}
+		if p.Protocol == ProtocolICMP {
+			icmp := p.Data[p.NetworkOffset+20:]
+			quoted := icmp[8:28]
+			if finishChecksum(sumWords(0, icmp)) != 0 || finishChecksum(sumWords(0, quoted)) != 0 {
+				t.Errorf("bad ICMP checksum in packet %d", len(packets))
+			}
+		}
+		packets = append(packets, p)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	capture := writeTestPcap(t, sandboxTraffic())
+	capture = appendRecord(capture, arpFrame())
+	capture = appendRecord(capture, icmpUnreachable(t))
+
+	a, err := NewAnonymizer(&AnonymizeOptions{
+		Options: Options{Machines: []*cuckoo.Machine{testMachine()}},
+		Key:     testAnonymizeKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	stats, err := a.Anonymize(bytes.NewReader(capture), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Packets != 11 || stats.Written != 9 || stats.Dropped != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	guestIP, resultserverIP := a.IP(net.ParseIP(guest)), a.IP(net.ParseIP(resultserver))
+	if guestIP.Equal(net.ParseIP(guest)) || !bytes.Equal(guestIP[:3], resultserverIP[:3]) || guestIP[3] == resultserverIP[3] {
+		t.Errorf("pseudonyms should share the prefix of the addresses: %s %s", guestIP, resultserverIP)
+	}
+	if ip := a.IP(net.ParseIP("8.8.8.8")); !ip.Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("external addresses should be kept, got %s", ip)
+	}
+	if mac := a.MAC(guestMAC); bytes.Equal(mac, guestMAC) || mac[0]&1 != 0 {
+		t.Errorf("unexpected MAC pseudonym %s", mac)
+	}
+
+	packets := readPackets(t, out.Bytes())
+	if len(packets) != 9 {
+		t.Fatalf("expected 9 packets, got %d", len(packets))
+	}
+	for _, p := range packets {
+		for _, ip := range []net.IP{p.SrcIP, p.DstIP} {
+			if ip.Equal(net.ParseIP(guest)) || ip.Equal(net.ParseIP(resultserver)) {
+				t.Errorf("internal address left in %+v", p)
+			}
+		}
+		if bytes.Equal(p.SrcMAC, guestMAC) || bytes.Equal(p.DstMAC, guestMAC) {
+			t.Errorf("guest MAC left in %+v", p)
+		}
+	}
+	if dns := packets[0]; !dns.SrcIP.Equal(guestIP) || !dns.DstIP.Equal(net.ParseIP("8.8.8.8")) || dns.SrcPort != 51000 {
+		t.Errorf("unexpected DNS query %+v", dns)
+	}
+
+	arp := packets[7].Data[14:]
+	if !bytes.Equal(arp[14:18], guestIP) || !bytes.Equal(arp[24:28], resultserverIP) || !bytes.Equal(arp[8:14], a.MAC(guestMAC)) {
+		t.Errorf("unexpected ARP packet %x", arp)
+	}
+	quoted := packets[8].Data[14+20+8:]
+	if !bytes.Equal(quoted[16:20], guestIP) {
+		t.Errorf("quoted header not rewritten %x", quoted[:20])
+	}
+
+	// The same key gives the same capture
+	again := &bytes.Buffer{}
+	b, _ := NewAnonymizer(&AnonymizeOptions{Options: Options{Machines: []*cuckoo.Machine{testMachine()}}, Key: testAnonymizeKey})
+	if _, err := b.Anonymize(bytes.NewReader(capture), again); err != nil || !bytes.Equal(again.Bytes(), out.Bytes()) {
+		t.Errorf("anonymization should be deterministic: %v", err)
+	}
+}
+
+func TestAnonymizeNDP(t *testing.T) {
+	target := net.ParseIP("fe80::a00:27ff:fe11:2233")
+	advertisement := append([]byte{136, 0, 0, 0, 0x60, 0, 0, 0}, target...)
+	advertisement = append(append(advertisement, 2, 1), guestMAC...)
+	solicitation := append([]byte{135, 0, 0, 0, 0, 0, 0, 0}, net.ParseIP("fe80::1")...)
+	solicitation = append(append(solicitation, 1, 1), guestMAC...)
+	solicitation = append(solicitation, 14, 1, 1, 2, 3, 4, 5, 6) // Nonce
+
+	capture := writeTestPcap(t, nil)
+	capture = appendRecord(capture, icmpv6Frame(t, advertisement))
+	capture = appendRecord(capture, icmpv6Frame(t, solicitation))
+
+	a, err := NewAnonymizer(&AnonymizeOptions{
+		Networks: []*net.IPNet{{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(10, 128)}},
+		Key:      testAnonymizeKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	stats, err := a.Anonymize(bytes.NewReader(capture), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 1 || stats.Dropped != 1 {
+		t.Errorf("expected the solicitation with a nonce to be dropped: %+v", stats)
+	}
+
+	packets := readPackets(t, out.Bytes())
+	if len(packets) != 1 {
+		t.Fatalf("expected 1 packet, got %d", len(packets))
+	}
+	message := packets[0].Payload
+	if !bytes.Equal(message[4:20], a.IP(target)) || bytes.Equal(message[4:20], target) {
+		t.Errorf("target address not rewritten %x", message[4:20])
+	}
+	if !bytes.Equal(message[22:28], a.MAC(guestMAC)) {
+		t.Errorf("link-layer address not rewritten %x", message[22:28])
+	}
+}
+
+func TestAnonymizeFragments(t *testing.T) {
+	payload := []byte("a DNS response too large for a single packet")
+	for _, test := range []struct {
+		src, dst string
+		// Length of the IP headers of the fragments
+		headers int
+	}{
+		{guest, "8.8.8.8", 20},
+		{"fe80::a00:27ff:fe11:2233", "2001:4860:4860::8888", 48},
+	} {
+		capture := writeTestPcap(t, nil)
+		for _, frame := range udpFragments(t, test.src, test.dst, payload) {
+			capture = appendRecord(capture, frame)
+		}
+
+		a, err := NewAnonymizer(&AnonymizeOptions{
+			Networks: []*net.IPNet{
+				{IP: net.ParseIP("192.168.56.0").To4(), Mask: net.CIDRMask(24, 32)},
+				{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(10, 128)},
+			},
+			Key: testAnonymizeKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		if _, err := a.Anonymize(bytes.NewReader(capture), out); err != nil {
+			t.Fatal(err)
+		}
+		packets := readPackets(t, out.Bytes())
+		if len(packets) != 2 {
+			t.Fatalf("expected 2 fragments, got %d", len(packets))
+		}
+
+		first, second := packets[0], packets[1]
+		if first.SrcIP.Equal(net.ParseIP(test.src)) {
+			t.Errorf("source address not rewritten %s", first.SrcIP)
+		}
+		segment := append(append([]byte{}, first.Data[first.NetworkOffset+test.headers:]...), second.Data[second.NetworkOffset+test.headers:]...)
+		if finishChecksum(sumWords(pseudoHeaderSum(first.SrcIP, first.DstIP, ProtocolUDP, len(segment)), segment)) != 0 {
+			t.Errorf("bad UDP checksum of the reassembled %s datagram", test.src)
+		}
+	}
+}
+
+func TestAnonymizeFlows(t *testing.T) {
+	a, err := NewAnonymizer(&AnonymizeOptions{
+		Options:  Options{Machines: []*cuckoo.Machine{testMachine()}, IncludeResultserver: true},
+		Networks: []*net.IPNet{{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(10, 128)}},
+		Flows:    &FlowFilter{Hosts: []string{"185.100.87.0/24", "fe80::1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if _, err := a.Anonymize(bytes.NewReader(writeTestPcap(t, sandboxTraffic())), out); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := readPackets(t, out.Bytes())
+	if len(packets) != 5 {
+		t.Fatalf("expected the HTTP and mDNS flows, got %d packets", len(packets))
+	}
+	if !packets[0].DstIP.Equal(net.ParseIP("185.100.87.202")) || packets[4].SrcIP.Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("unexpected packets %+v %+v", packets[0], packets[4])
+	}
+
+	if _, err := NewAnonymizer(&AnonymizeOptions{Flows: &FlowFilter{Hosts: []string{"evil"}}}); err == nil {
+		t.Errorf("expected an error for an invalid host")
+	}
+}
+
+func TestAnonymizeTask(t *testing.T) {
+	capture := writeTestPcap(t, sandboxTraffic())
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/view/42":
+			fmt.Fprint(w, `{"task": {"id": 42, "machine": "win7-1"}}`)
+		case "/pcap/get/42":
+			w.Write(capture)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	out := &bytes.Buffer{}
+	stats, err := AnonymizeTask(context.Background(), c, 42, out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 7 || stats.Dropped != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	for _, p := range readPackets(t, out.Bytes()) {
+		if p.SrcIP.Equal(net.ParseIP(guest)) || p.DstIP.Equal(net.ParseIP(guest)) {
+			t.Errorf("guest address left in %+v", p)
+		}
+	}
+}
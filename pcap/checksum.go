@@ -0,0 +1,156 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// FixChecksums Recomputes the checksums of a packet after its addresses were rewritten: the IPv4 header checksum
+// and the TCP, UDP and ICMPv6 checksums.  Transport checksums need the whole packet, when it was truncated by the
+// snap length the UDP checksum is disabled and the TCP and ICMPv6 checksums are left as is.  The transport checksum
+// of a fragmented packet covers the whole datagram, see UpdateFragmentChecksum.
+func (p *Packet) FixChecksums() {
+	if p.NetworkOffset < 0 {
+		return
+	}
+	data := p.Data
+
+	if p.IPVersion == 4 {
+		header := data[p.NetworkOffset : p.NetworkOffset+int(data[p.NetworkOffset]&0x0f)*4]
+		setChecksum(header, 10, 0)
+	}
+	if p.TransportOffset < 0 {
+		return
+	}
+
+	var field int
+	switch p.Protocol {
+	case ProtocolTCP:
+		field = 16
+	case ProtocolUDP:
+		field = 6
+	case ProtocolICMPv6:
+		field = 2
+	default:
+		return
+	}
+
+	end := p.NetworkOffset + p.IPLength
+	if end > len(data) {
+		if p.Protocol == ProtocolUDP && p.IPVersion == 4 {
+			data[p.TransportOffset+6], data[p.TransportOffset+7] = 0, 0
+		}
+		return
+	}
+	segment := data[p.TransportOffset:end]
+	if p.Protocol == ProtocolUDP && p.IPVersion == 4 && segment[6] == 0 && segment[7] == 0 {
+		// The sender did not compute it
+		return
+	}
+
+	setChecksum(segment, field, pseudoHeaderSum(p.SrcIP, p.DstIP, p.Protocol, len(segment)))
+	if p.Protocol == ProtocolUDP && segment[6] == 0 && segment[7] == 0 {
+		// Zero means no checksum for UDP
+		segment[6], segment[7] = 0xff, 0xff
+	}
+}
+
+// UpdateFragmentChecksum Updates the TCP, UDP or ICMPv6 checksum held by the first fragment of a packet after its
+// addresses were rewritten from oldSrc and oldDst.  The other fragments don't hold the rest of the datagram, so the
+// checksum is updated for the new addresses (RFC 1624) rather than recomputed.  Other packets are left as is.
+func (p *Packet) UpdateFragmentChecksum(oldSrc, oldDst net.IP) {
+	if !p.Fragment {
+		return
+	}
+	var field int
+	switch p.Protocol {
+	case ProtocolTCP:
+		field = 16
+	case ProtocolUDP:
+		field = 6
+	case ProtocolICMPv6:
+		field = 2
+	default:
+		return
+	}
+	offset := p.firstFragmentTransport()
+	if offset < 0 || offset+field+2 > len(p.Data) {
+		return
+	}
+	checksum := p.Data[offset+field : offset+field+2]
+	if p.Protocol == ProtocolUDP && p.IPVersion == 4 && checksum[0] == 0 && checksum[1] == 0 {
+		// The sender did not compute it
+		return
+	}
+
+	// HC' = ~(~HC + ~m + m')
+	sum := uint32(^binary.BigEndian.Uint16(checksum))
+	for _, old := range []net.IP{oldSrc, oldDst} {
+		for i := 0; i+1 < len(old); i += 2 {
+			sum += uint32(^binary.BigEndian.Uint16(old[i : i+2]))
+		}
+	}
+	sum = sumWords(sum, p.SrcIP)
+	sum = sumWords(sum, p.DstIP)
+	updated := finishChecksum(sum)
+	if p.Protocol == ProtocolUDP && updated == 0 {
+		updated = 0xffff
+	}
+	binary.BigEndian.PutUint16(checksum, updated)
+}
+
+// firstFragmentTransport returns the offset of the transport header of the first fragment of a packet, or -1 for
+// the other fragments
+func (p *Packet) firstFragmentTransport() int {
+	data := p.Data
+	if p.IPVersion == 4 {
+		if binary.BigEndian.Uint16(data[p.NetworkOffset+6:p.NetworkOffset+8])&0x1fff != 0 {
+			return -1
+		}
+		return p.NetworkOffset + int(data[p.NetworkOffset]&0x0f)*4
+	}
+
+	// Skip the extension headers up to the fragment header, like decodeIPv6
+	next, offset := data[p.NetworkOffset+6], p.NetworkOffset+40
+	for next != 44 {
+		if (next != 0 && next != 43 && next != 60) || offset+2 > len(data) {
+			return -1
+		}
+		next, offset = data[offset], offset+(int(data[offset+1])+1)*8
+	}
+	if offset+8 > len(data) || binary.BigEndian.Uint16(data[offset+2:offset+4])&0xfff8 != 0 {
+		return -1
+	}
+	return offset + 8
+}
+
+// setChecksum writes the internet checksum of data, starting from initial, in the field at offset
+func setChecksum(data []byte, offset int, initial uint32) {
+	data[offset], data[offset+1] = 0, 0
+	binary.BigEndian.PutUint16(data[offset:offset+2], finishChecksum(sumWords(initial, data)))
+}
+
+// pseudoHeaderSum returns the sum of the pseudo header covered by the TCP, UDP and ICMPv6 checksums
+func pseudoHeaderSum(src, dst net.IP, protocol uint8, length int) uint32 {
+	sum := sumWords(0, src)
+	sum = sumWords(sum, dst)
+	return sum + uint32(protocol) + uint32(length>>16) + uint32(length&0xffff)
+}
+
+func sumWords(sum uint32, data []byte) uint32 {
+	for len(data) >= 2 {
+		sum += uint32(data[0])<<8 | uint32(data[1])
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+	return sum
+}
+
+func finishChecksum(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
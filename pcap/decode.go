@@ -0,0 +1,268 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// IP protocols
+const (
+	ProtocolICMP   uint8 = 1
+	ProtocolTCP    uint8 = 6
+	ProtocolUDP    uint8 = 17
+	ProtocolICMPv6 uint8 = 58
+)
+
+// EtherTypes
+const (
+	EtherTypeIPv4 uint16 = 0x0800
+	EtherTypeARP  uint16 = 0x0806
+	EtherTypeVLAN uint16 = 0x8100
+	EtherTypeIPv6 uint16 = 0x86dd
+)
+
+// TCPFlags are the control bits of a TCP segment
+type TCPFlags uint8
+
+// TCP control bits
+const (
+	TCPFin TCPFlags = 1 << iota
+	TCPSyn
+	TCPRst
+	TCPPsh
+	TCPAck
+	TCPUrg
+)
+
+// Has returns true if all the flags of f are set
+func (flags TCPFlags) Has(f TCPFlags) bool {
+	return flags&f == f
+}
+
+// errTruncated is returned when a header is cut short
+var errTruncated = errors.New("pcap: truncated packet")
+
+// Packet is a decoded record.  Offsets index Data, which is shared with the record.
+type Packet struct {
+	Timestamp time.Time
+	// Length of the packet on the wire
+	Length int
+	Data   []byte
+
+	// Link layer, only set for ethernet captures
+	SrcMAC    net.HardwareAddr
+	DstMAC    net.HardwareAddr
+	EtherType uint16
+
+	// Network layer, NetworkOffset is -1 when the packet is not IP
+	NetworkOffset int
+	IPVersion     int
+	SrcIP         net.IP
+	DstIP         net.IP
+	Protocol      uint8
+	// IPLength is the length of the IP packet from its header
+	IPLength int
+	// Fragment is set for the fragments of an IPv4 packet, their transport layer is not decoded
+	Fragment bool
+
+	// Transport layer, TransportOffset is -1 when it is not decoded.  ICMPv6 is decoded without ports, its Payload
+	// follows the type, code and checksum.
+	TransportOffset int
+	SrcPort         uint16
+	DstPort         uint16
+	Seq             uint32
+	Ack             uint32
+	Flags           TCPFlags
+	Payload         []byte
+}
+
+// Decode Decodes the layers of a record of a capture with the given link type.
+//
+// Layers that cannot be decoded are left empty, an error is only returned if a header is truncated or the link
+// type is not supported.
+func Decode(linkType uint32, record *Record) (*Packet, error) {
+	p := &Packet{
+		Timestamp:       record.Timestamp,
+		Length:          record.Length,
+		Data:            record.Data,
+		NetworkOffset:   -1,
+		TransportOffset: -1,
+	}
+	data := record.Data
+
+	var etherType uint16
+	offset := 0
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return p, errTruncated
+		}
+		p.DstMAC = net.HardwareAddr(data[0:6])
+		p.SrcMAC = net.HardwareAddr(data[6:12])
+		etherType = binary.BigEndian.Uint16(data[12:14])
+		offset = 14
+		for etherType == EtherTypeVLAN {
+			if len(data) < offset+4 {
+				return p, errTruncated
+			}
+			etherType = binary.BigEndian.Uint16(data[offset+2 : offset+4])
+			offset += 4
+		}
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return p, errTruncated
+		}
+		etherType = binary.BigEndian.Uint16(data[14:16])
+		offset = 16
+	case LinkTypeNull:
+		if len(data) < 4 {
+			return p, errTruncated
+		}
+		// The address family is in the byte order of the capturing host
+		family := binary.LittleEndian.Uint32(data[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data[0:4])
+		}
+		switch family {
+		case 2:
+			etherType = EtherTypeIPv4
+		case 24, 28, 30:
+			etherType = EtherTypeIPv6
+		}
+		offset = 4
+	case LinkTypeRaw:
+		if len(data) > 0 && data[0]>>4 == 6 {
+			etherType = EtherTypeIPv6
+		} else {
+			etherType = EtherTypeIPv4
+		}
+	default:
+		return p, fmt.Errorf("pcap: unsupported link type %d", linkType)
+	}
+	p.EtherType = etherType
+
+	switch etherType {
+	case EtherTypeIPv4:
+		return p, p.decodeIPv4(offset)
+	case EtherTypeIPv6:
+		return p, p.decodeIPv6(offset)
+	}
+	return p, nil
+}
+
+func (p *Packet) decodeIPv4(offset int) error {
+	data := p.Data
+	if len(data) < offset+20 || data[offset]>>4 != 4 {
+		return errTruncated
+	}
+	headerLength := int(data[offset]&0x0f) * 4
+	if headerLength < 20 || len(data) < offset+headerLength {
+		return errTruncated
+	}
+
+	p.NetworkOffset = offset
+	p.IPVersion = 4
+	p.IPLength = int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+	flagsFragment := binary.BigEndian.Uint16(data[offset+6 : offset+8])
+	p.Fragment = flagsFragment&0x3fff != 0
+	p.Protocol = data[offset+9]
+	p.SrcIP = net.IP(data[offset+12 : offset+16])
+	p.DstIP = net.IP(data[offset+16 : offset+20])
+
+	end := offset + p.IPLength
+	if p.IPLength < headerLength || end > len(data) {
+		// Truncated by the snap length, decode what was captured
+		end = len(data)
+	}
+	if p.Fragment {
+		return nil
+	}
+	return p.decodeTransport(offset+headerLength, end)
+}
+
+func (p *Packet) decodeIPv6(offset int) error {
+	data := p.Data
+	if len(data) < offset+40 || data[offset]>>4 != 6 {
+		return errTruncated
+	}
+
+	p.NetworkOffset = offset
+	p.IPVersion = 6
+	p.IPLength = 40 + int(binary.BigEndian.Uint16(data[offset+4:offset+6]))
+	p.SrcIP = net.IP(data[offset+8 : offset+24])
+	p.DstIP = net.IP(data[offset+24 : offset+40])
+
+	end := offset + p.IPLength
+	if end > len(data) {
+		end = len(data)
+	}
+
+	// Skip the extension headers
+	next := data[offset+6]
+	transport := offset + 40
+	for {
+		switch next {
+		case 0, 43, 60: // Hop-by-hop, routing and destination options
+			if transport+2 > end {
+				return errTruncated
+			}
+			next, transport = data[transport], transport+(int(data[transport+1])+1)*8
+			continue
+		case 44: // Fragment
+			if transport+8 > end {
+				return errTruncated
+			}
+			p.Fragment = true
+			p.Protocol = data[transport]
+			return nil
+		}
+		break
+	}
+	p.Protocol = next
+	if transport > end {
+		return errTruncated
+	}
+	return p.decodeTransport(transport, end)
+}
+
+func (p *Packet) decodeTransport(offset, end int) error {
+	data := p.Data[:end]
+	switch p.Protocol {
+	case ProtocolTCP:
+		if len(data) < offset+20 {
+			return errTruncated
+		}
+		headerLength := int(data[offset+12]>>4) * 4
+		if headerLength < 20 || len(data) < offset+headerLength {
+			return errTruncated
+		}
+		p.TransportOffset = offset
+		p.SrcPort = binary.BigEndian.Uint16(data[offset : offset+2])
+		p.DstPort = binary.BigEndian.Uint16(data[offset+2 : offset+4])
+		p.Seq = binary.BigEndian.Uint32(data[offset+4 : offset+8])
+		p.Ack = binary.BigEndian.Uint32(data[offset+8 : offset+12])
+		p.Flags = TCPFlags(data[offset+13] & 0x3f)
+		p.Payload = data[offset+headerLength:]
+	case ProtocolUDP:
+		if len(data) < offset+8 {
+			return errTruncated
+		}
+		p.TransportOffset = offset
+		p.SrcPort = binary.BigEndian.Uint16(data[offset : offset+2])
+		p.DstPort = binary.BigEndian.Uint16(data[offset+2 : offset+4])
+		p.Payload = data[offset+8:]
+		if length := int(binary.BigEndian.Uint16(data[offset+4 : offset+6])); length >= 8 && offset+length <= len(data) {
+			p.Payload = data[offset+8 : offset+length]
+		}
+	case ProtocolICMPv6:
+		if len(data) < offset+4 {
+			return errTruncated
+		}
+		p.TransportOffset = offset
+		p.Payload = data[offset+4:]
+	}
+	return nil
+}
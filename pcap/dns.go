@@ -0,0 +1,177 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNSQuery is a DNS question and the answers it got
+type DNSQuery struct {
+	Time   time.Time `json:"time"`
+	Client Endpoint  `json:"client"`
+	Server Endpoint  `json:"server"`
+	ID     uint16    `json:"id"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	// Whether a response was seen, and its response code (e.g. NOERROR or NXDOMAIN)
+	Responded bool         `json:"responded"`
+	RCode     string       `json:"rcode,omitempty"`
+	Answers   []*DNSAnswer `json:"answers"`
+}
+
+// DNSAnswer is a resource record of the answer section of a response
+type DNSAnswer struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	// Data is the address, name or text of the record, hex for the types that are not decoded
+	Data string `json:"data"`
+}
+
+// dnsMessage is a decoded DNS message
+type dnsMessage struct {
+	id        uint16
+	response  bool
+	rcode     string
+	questions [][2]string
+	answers   []*DNSAnswer
+}
+
+var errDNSTruncated = errors.New("pcap: truncated dns message")
+
+var dnsTypes = map[uint16]string{
+	1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT", 28: "AAAA", 33: "SRV", 255: "ANY",
+}
+
+var dnsRCodes = map[uint16]string{
+	0: "NOERROR", 1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED",
+}
+
+func dnsType(t uint16) string {
+	if name, ok := dnsTypes[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// parseDNS decodes a DNS message
+func parseDNS(data []byte) (*dnsMessage, error) {
+	if len(data) < 12 {
+		return nil, errDNSTruncated
+	}
+	flags := binary.BigEndian.Uint16(data[2:4])
+	m := &dnsMessage{
+		id:       binary.BigEndian.Uint16(data[0:2]),
+		response: flags&0x8000 != 0,
+		rcode:    dnsRCodes[flags&0x000f],
+	}
+	if m.rcode == "" {
+		m.rcode = fmt.Sprintf("RCODE%d", flags&0x000f)
+	}
+	questions := int(binary.BigEndian.Uint16(data[4:6]))
+	answers := int(binary.BigEndian.Uint16(data[6:8]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := dnsName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, errDNSTruncated
+		}
+		m.questions = append(m.questions, [2]string{name, dnsType(binary.BigEndian.Uint16(data[next : next+2]))})
+		offset = next + 4
+	}
+
+	for i := 0; i < answers; i++ {
+		name, next, err := dnsName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(data) {
+			return nil, errDNSTruncated
+		}
+		recordType := binary.BigEndian.Uint16(data[next : next+2])
+		ttl := binary.BigEndian.Uint32(data[next+4 : next+8])
+		length := int(binary.BigEndian.Uint16(data[next+8 : next+10]))
+		start := next + 10
+		if start+length > len(data) {
+			return nil, errDNSTruncated
+		}
+
+		answer := &DNSAnswer{Name: name, Type: dnsType(recordType), TTL: ttl}
+		rdata := data[start : start+length]
+		switch recordType {
+		case 1, 28:
+			answer.Data = net.IP(rdata).String()
+		case 2, 5, 12:
+			answer.Data, _, err = dnsName(data, start)
+		case 15:
+			if length > 2 {
+				answer.Data, _, err = dnsName(data, start+2)
+			}
+		case 16:
+			parts := []string{}
+			for i := 0; i < len(rdata); {
+				end := i + 1 + int(rdata[i])
+				if end > len(rdata) {
+					end = len(rdata)
+				}
+				parts = append(parts, string(rdata[i+1:end]))
+				i = end
+			}
+			answer.Data = strings.Join(parts, "")
+		default:
+			answer.Data = fmt.Sprintf("%x", rdata)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.answers = append(m.answers, answer)
+		offset = start + length
+	}
+
+	return m, nil
+}
+
+// dnsName reads a possibly compressed name at offset, returning the offset following it
+func dnsName(data []byte, offset int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(data) {
+			return "", 0, errDNSTruncated
+		}
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if offset+2 > len(data) {
+				return "", 0, errDNSTruncated
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			jumps++
+			if jumps > 32 {
+				return "", 0, errors.New("pcap: dns name compression loop")
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:offset+2]) & 0x3fff)
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, errDNSTruncated
+			}
+			labels = append(labels, string(data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	guestMAC = net.HardwareAddr{0x08, 0x00, 0x27, 0x11, 0x22, 0x33}
+	hostMAC  = net.HardwareAddr{0x0a, 0x00, 0x27, 0x00, 0x00, 0x00}
+	epoch    = time.Date(2020, 2, 11, 16, 46, 40, 0, time.UTC)
+)
+
+// testPacket describes a packet written by writeTestPcap
+type testPacket struct {
+	// Offset from epoch
+	at       time.Duration
+	protocol uint8
+	src, dst string
+	flags    TCPFlags
+	seq, ack uint32
+	payload  []byte
+}
+
+func splitEndpoint(t *testing.T, endpoint string) (net.IP, uint16) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return net.ParseIP(host), uint16(p)
+}
+
+// buildFrame returns the ethernet frame of a packet, UDP checksums are left disabled
+func buildFrame(t *testing.T, p *testPacket) []byte {
+	srcIP, srcPort := splitEndpoint(t, p.src)
+	dstIP, dstPort := splitEndpoint(t, p.dst)
+
+	transport := &bytes.Buffer{}
+	binary.Write(transport, binary.BigEndian, srcPort)
+	binary.Write(transport, binary.BigEndian, dstPort)
+	if p.protocol == ProtocolTCP {
+		binary.Write(transport, binary.BigEndian, p.seq)
+		binary.Write(transport, binary.BigEndian, p.ack)
+		transport.Write([]byte{5 << 4, byte(p.flags)})
+		binary.Write(transport, binary.BigEndian, uint16(65535))
+		transport.Write([]byte{0, 0, 0, 0}) // Checksum and urgent pointer
+	} else {
+		binary.Write(transport, binary.BigEndian, uint16(8+len(p.payload)))
+		transport.Write([]byte{0, 0})
+	}
+	transport.Write(p.payload)
+
+	frame := &bytes.Buffer{}
+	frame.Write(hostMAC)
+	frame.Write(guestMAC)
+	if srcIP.To4() != nil {
+		binary.Write(frame, binary.BigEndian, EtherTypeIPv4)
+		frame.Write([]byte{0x45, 0})
+		binary.Write(frame, binary.BigEndian, uint16(20+transport.Len()))
+		frame.Write([]byte{0, 1, 0x40, 0, 64, p.protocol, 0, 0})
+		frame.Write(srcIP.To4())
+		frame.Write(dstIP.To4())
+	} else {
+		binary.Write(frame, binary.BigEndian, EtherTypeIPv6)
+		frame.Write([]byte{0x60, 0, 0, 0})
+		binary.Write(frame, binary.BigEndian, uint16(transport.Len()))
+		frame.Write([]byte{p.protocol, 64})
+		frame.Write(srcIP.To16())
+		frame.Write(dstIP.To16())
+	}
+	frame.Write(transport.Bytes())
+
+	packet, err := Decode(LinkTypeEthernet, &Record{Length: frame.Len(), Data: frame.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.FixChecksums()
+	return packet.Data
+}
+
+// writeTestPcap returns a little endian microsecond ethernet capture of the packets
+func writeTestPcap(t *testing.T, packets []*testPacket) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []uint32{magicMicroseconds})
+	binary.Write(buf, binary.LittleEndian, []uint16{2, 4})
+	binary.Write(buf, binary.LittleEndian, []uint32{0, 0, 65535, LinkTypeEthernet})
+	for _, p := range packets {
+		frame := buildFrame(t, p)
+		at := epoch.Add(p.at)
+		binary.Write(buf, binary.LittleEndian, []uint32{
+			uint32(at.Unix()), uint32(at.Nanosecond() / 1000), uint32(len(frame)), uint32(len(frame)),
+		})
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+// dnsQuestion returns a DNS query for name
+func dnsQuestion(id uint16, name string, recordType uint16) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, []uint16{id, 0x0100, 1, 0, 0, 0})
+	writeDNSName(buf, name)
+	binary.Write(buf, binary.BigEndian, []uint16{recordType, 1})
+	return buf.Bytes()
+}
+
+// dnsResponse returns the response to a query for name, with a CNAME to alias and its A records.  The names
+// of the answers are compressed.
+func dnsResponse(id uint16, name, alias string, ips ...string) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, []uint16{id, 0x8180, 1, uint16(1 + len(ips)), 0, 0})
+	writeDNSName(buf, name)
+	binary.Write(buf, binary.BigEndian, []uint16{1, 1})
+
+	// CNAME, pointing back at the question name
+	rdata := &bytes.Buffer{}
+	writeDNSName(rdata, alias)
+	buf.Write([]byte{0xc0, 12})
+	binary.Write(buf, binary.BigEndian, []uint16{5, 1})
+	binary.Write(buf, binary.BigEndian, uint32(300))
+	binary.Write(buf, binary.BigEndian, uint16(rdata.Len()))
+	aliasOffset := buf.Len()
+	buf.Write(rdata.Bytes())
+
+	for _, ip := range ips {
+		buf.Write([]byte{0xc0, byte(aliasOffset)})
+		binary.Write(buf, binary.BigEndian, []uint16{1, 1})
+		binary.Write(buf, binary.BigEndian, uint32(60))
+		binary.Write(buf, binary.BigEndian, uint16(4))
+		buf.Write(net.ParseIP(ip).To4())
+	}
+	return buf.Bytes()
+}
+
+func writeDNSName(buf *bytes.Buffer, name string) {
+	for _, label := range strings.Split(name, ".") {
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+}
@@ -0,0 +1,131 @@
+// Package pcap reads the packet captures of cuckoo analyses and summarizes the traffic they hold
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Link types of the captures, see https://www.tcpdump.org/linktypes.html
+const (
+	LinkTypeNull     uint32 = 0
+	LinkTypeEthernet uint32 = 1
+	LinkTypeRaw      uint32 = 101
+	LinkTypeLinuxSLL uint32 = 113
+)
+
+const (
+	magicMicroseconds = 0xa1b2c3d4
+	magicNanoseconds  = 0xa1b23c4d
+	magicPcapNG       = 0x0a0d0d0a
+
+	// maxRecordSize bounds the records read so a corrupted length doesn't allocate gigabytes
+	maxRecordSize = 16 * 1024 * 1024
+)
+
+// ErrPcapNG is returned for pcapng captures, only the classic pcap format is supported
+var ErrPcapNG = errors.New("pcap: pcapng captures are not supported")
+
+// Header is the global header of a capture
+type Header struct {
+	ByteOrder binary.ByteOrder
+	// Nanosecond timestamps instead of microseconds
+	Nanoseconds  bool
+	VersionMajor uint16
+	VersionMinor uint16
+	ThisZone     int32
+	SigFigs      uint32
+	SnapLen      uint32
+	LinkType     uint32
+}
+
+// Record is a captured packet as stored in the capture
+type Record struct {
+	Timestamp time.Time
+	// Length of the packet on the wire, Data may be shorter if it was truncated by the snap length
+	Length int
+	Data   []byte
+}
+
+// Reader reads the records of a capture
+type Reader struct {
+	r      io.Reader
+	header *Header
+	buf    [16]byte
+}
+
+// NewReader Reads the global header of a capture.  Both byte orders and nanosecond captures are supported.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: r}
+	var raw [24]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return nil, fmt.Errorf("pcap: error reading header: %w", err)
+	}
+
+	header := &Header{}
+	switch magic := binary.LittleEndian.Uint32(raw[0:4]); {
+	case magic == magicMicroseconds:
+		header.ByteOrder = binary.LittleEndian
+	case magic == magicNanoseconds:
+		header.ByteOrder, header.Nanoseconds = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(raw[0:4]) == magicMicroseconds:
+		header.ByteOrder = binary.BigEndian
+	case binary.BigEndian.Uint32(raw[0:4]) == magicNanoseconds:
+		header.ByteOrder, header.Nanoseconds = binary.BigEndian, true
+	case magic == magicPcapNG:
+		return nil, ErrPcapNG
+	default:
+		return nil, fmt.Errorf("pcap: unknown magic number %#08x", magic)
+	}
+
+	order := header.ByteOrder
+	header.VersionMajor = order.Uint16(raw[4:6])
+	header.VersionMinor = order.Uint16(raw[6:8])
+	header.ThisZone = int32(order.Uint32(raw[8:12]))
+	header.SigFigs = order.Uint32(raw[12:16])
+	header.SnapLen = order.Uint32(raw[16:20])
+	header.LinkType = order.Uint32(raw[20:24])
+	reader.header = header
+
+	return reader, nil
+}
+
+// Header returns the global header of the capture
+func (r *Reader) Header() *Header {
+	return r.header
+}
+
+// Next reads the next record.  It returns io.EOF at the end of the capture.
+func (r *Reader) Next() (*Record, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("pcap: truncated record header: %w", err)
+		}
+		return nil, err
+	}
+
+	order := r.header.ByteOrder
+	seconds := int64(order.Uint32(r.buf[0:4]))
+	fraction := int64(order.Uint32(r.buf[4:8]))
+	capturedLength := order.Uint32(r.buf[8:12])
+	length := order.Uint32(r.buf[12:16])
+	if capturedLength > maxRecordSize {
+		return nil, fmt.Errorf("pcap: record of %d bytes is too large", capturedLength)
+	}
+	if !r.header.Nanoseconds {
+		fraction *= int64(time.Microsecond)
+	}
+
+	record := &Record{
+		Timestamp: time.Unix(seconds, fraction).UTC(),
+		Length:    int(length),
+		Data:      make([]byte, capturedLength),
+	}
+	if _, err := io.ReadFull(r.r, record.Data); err != nil {
+		return nil, fmt.Errorf("pcap: truncated record: %w", err)
+	}
+	return record, nil
+}
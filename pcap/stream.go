@@ -0,0 +1,264 @@
+package pcap
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// defaultMaxBuffered is the default number of out of order bytes buffered per direction before a gap is skipped
+const defaultMaxBuffered = 1024 * 1024
+
+// Direction is the direction of the data of a TCP connection
+type Direction int
+
+// Directions
+const (
+	ClientToServer Direction = iota
+	ServerToClient
+)
+
+// StreamHandler receives the reassembled data of TCP connections
+type StreamHandler interface {
+	// Data is called with the next bytes of a direction of conn, in order.  data is only valid during the call.
+	Data(conn *Conn, direction Direction, data []byte, timestamp time.Time)
+	// Close is called once a connection is closed or the capture ends
+	Close(conn *Conn)
+}
+
+// Conn is a TCP connection followed by an Assembler
+type Conn struct {
+	// Client is the endpoint that sent the SYN, or the first packet if the handshake was not captured
+	Client    Endpoint
+	Server    Endpoint
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Bytes skipped because segments were missing
+	Missing int64
+
+	key        flowKey
+	directions [2]*halfStream
+	closed     bool
+}
+
+// halfStream is the state of one direction of a connection
+type halfStream struct {
+	started  bool
+	next     uint32
+	pending  []*segment
+	buffered int
+	fin      bool
+}
+
+// segment is out of order data waiting for the bytes before it
+type segment struct {
+	seq       uint32
+	data      []byte
+	timestamp time.Time
+}
+
+// Assembler reassembles the TCP connections of a capture and hands their data to a StreamHandler
+type Assembler struct {
+	// Out of order bytes buffered per direction before the missing bytes are given up on, defaults to 1MB
+	MaxBuffered int
+
+	handler StreamHandler
+	conns   map[flowKey]*Conn
+}
+
+// NewAssembler Returns an assembler calling handler
+func NewAssembler(handler StreamHandler) *Assembler {
+	return &Assembler{handler: handler, conns: map[flowKey]*Conn{}}
+}
+
+// Add Adds a decoded packet, packets other than TCP are ignored
+func (a *Assembler) Add(p *Packet) {
+	if p.Protocol != ProtocolTCP || p.TransportOffset < 0 {
+		return
+	}
+
+	src := Endpoint{IP: p.SrcIP.String(), Port: int(p.SrcPort)}
+	dst := Endpoint{IP: p.DstIP.String(), Port: int(p.DstPort)}
+	key := newFlowKey(p.Protocol, src, dst)
+
+	conn, ok := a.conns[key]
+	if ok && conn.closed {
+		// A new connection reusing the ports of a closed one
+		if !p.Flags.Has(TCPSyn) {
+			return
+		}
+		delete(a.conns, key)
+		ok = false
+	}
+	if !ok {
+		conn = &Conn{Client: src, Server: dst, FirstSeen: p.Timestamp, key: key, directions: [2]*halfStream{{}, {}}}
+		if p.Flags.Has(TCPSyn) && p.Flags.Has(TCPAck) {
+			// Only the SYN-ACK was captured, the receiver is the client
+			conn.Client, conn.Server = dst, src
+		}
+		a.conns[key] = conn
+	}
+	conn.LastSeen = p.Timestamp
+
+	direction := ClientToServer
+	if src != conn.Client {
+		direction = ServerToClient
+	}
+	half := conn.directions[direction]
+
+	seq := p.Seq
+	if p.Flags.Has(TCPSyn) {
+		half.started = true
+		half.next = seq + 1
+		seq++
+	}
+	if !half.started {
+		// The handshake was not captured, start with the first data seen
+		half.started = true
+		half.next = seq
+	}
+
+	if len(p.Payload) > 0 {
+		a.segment(conn, direction, &segment{seq: seq, data: append([]byte{}, p.Payload...), timestamp: p.Timestamp})
+	}
+
+	if p.Flags.Has(TCPRst) {
+		a.close(conn)
+		return
+	}
+	if p.Flags.Has(TCPFin) {
+		half.fin = true
+		if conn.directions[ClientToServer].fin && conn.directions[ServerToClient].fin {
+			a.close(conn)
+		}
+	}
+}
+
+// segment delivers the data of a segment, or buffers it until the bytes before it arrive
+func (a *Assembler) segment(conn *Conn, direction Direction, s *segment) {
+	half := conn.directions[direction]
+	half.pending = append(half.pending, s)
+	half.buffered += len(s.data)
+	sort.SliceStable(half.pending, func(i, j int) bool { return seqBefore(half.pending[i].seq, half.pending[j].seq) })
+
+	maxBuffered := a.MaxBuffered
+	if maxBuffered <= 0 {
+		maxBuffered = defaultMaxBuffered
+	}
+	a.deliver(conn, direction, half.buffered > maxBuffered)
+}
+
+// deliver hands the in order pending data to the handler.  With skipGaps, missing bytes are given up on.
+func (a *Assembler) deliver(conn *Conn, direction Direction, skipGaps bool) {
+	half := conn.directions[direction]
+	for len(half.pending) > 0 {
+		s := half.pending[0]
+		if seqBefore(half.next, s.seq) {
+			if !skipGaps {
+				return
+			}
+			conn.Missing += int64(s.seq - half.next)
+			half.next = s.seq
+		}
+
+		half.pending = half.pending[1:]
+		half.buffered -= len(s.data)
+
+		// Drop what was already delivered, retransmissions and overlaps
+		if already := int64(half.next - s.seq); already > 0 {
+			if already >= int64(len(s.data)) {
+				continue
+			}
+			s.data = s.data[already:]
+		}
+		half.next += uint32(len(s.data))
+		a.handler.Data(conn, direction, s.data, s.timestamp)
+	}
+}
+
+// close flushes what is left of a connection and hands it to the handler
+func (a *Assembler) close(conn *Conn) {
+	if conn.closed {
+		return
+	}
+	a.deliver(conn, ClientToServer, true)
+	a.deliver(conn, ServerToClient, true)
+	conn.closed = true
+	a.handler.Close(conn)
+}
+
+// Flush Closes every connection still open, it must be called at the end of the capture
+func (a *Assembler) Flush() {
+	conns := make([]*Conn, 0, len(a.conns))
+	for _, conn := range a.conns {
+		conns = append(conns, conn)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].FirstSeen.Before(conns[j].FirstSeen) })
+	for _, conn := range conns {
+		a.close(conn)
+	}
+	a.conns = map[flowKey]*Conn{}
+}
+
+// seqBefore compares sequence numbers across wraparound
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// Assemble Reads a capture and hands the data of its TCP connections to handler.
+//
+// The traffic to the resultserver of opts.Machines is left out unless opts.IncludeResultserver is set, see Summarize.
+func Assemble(r io.Reader, opts *Options, handler StreamHandler) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	reader, err := NewReader(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+
+	s := &summarizer{excluded: excludedEndpoints(opts)}
+	assembler := NewAssembler(handler)
+	linkType := reader.Header().LinkType
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		packet, err := Decode(linkType, record)
+		if err != nil || packet.TransportOffset < 0 {
+			continue
+		}
+		if s.isExcluded(packet.SrcIP, packet.SrcPort) || s.isExcluded(packet.DstIP, packet.DstPort) {
+			continue
+		}
+		assembler.Add(packet)
+	}
+	assembler.Flush()
+	return nil
+}
+
+// AssembleTask Downloads the capture of the specified task ID and hands the data of its TCP connections to
+// handler, leaving out the resultserver of the machine the task ran on.  See Assemble.
+func AssembleTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options, handler StreamHandler) error {
+	opts, err := withTaskMachine(ctx, c, taskID, opts)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.PcapGet(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return Assemble(body, opts, handler)
+}
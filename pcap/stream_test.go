@@ -0,0 +1,116 @@
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// recordingHandler keeps the data of every connection
+type recordingHandler struct {
+	data   map[*Conn]*[2]bytes.Buffer
+	closed []*Conn
+}
+
+func (h *recordingHandler) Data(conn *Conn, direction Direction, data []byte, timestamp time.Time) {
+	if h.data[conn] == nil {
+		h.data[conn] = &[2]bytes.Buffer{}
+	}
+	h.data[conn][direction].Write(data)
+}
+
+func (h *recordingHandler) Close(conn *Conn) {
+	h.closed = append(h.closed, conn)
+}
+
+func assemble(t *testing.T, packets []*testPacket, maxBuffered int) *recordingHandler {
+	reader, err := NewReader(bytes.NewReader(writeTestPcap(t, packets)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &recordingHandler{data: map[*Conn]*[2]bytes.Buffer{}}
+	assembler := NewAssembler(h)
+	assembler.MaxBuffered = maxBuffered
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := Decode(LinkTypeEthernet, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assembler.Add(packet)
+	}
+	assembler.Flush()
+	return h
+}
+
+func TestAssembler(t *testing.T) {
+	client, server := guest+":49170", "203.0.113.5:8080"
+	seq := uint32(0xfffffff0) // Wraps around during the connection
+	packets := []*testPacket{
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPSyn, seq: seq},
+		{protocol: ProtocolTCP, src: server, dst: client, flags: TCPSyn | TCPAck, seq: 7000, ack: seq + 1},
+		// Out of order, retransmitted and overlapping segments
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: seq + 1 + 10, payload: []byte("klmnopqrst")},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: seq + 1, payload: []byte("abcdefgh")},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: seq + 1, payload: []byte("abcdefgh")},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: seq + 1 + 6, payload: []byte("ghijkl")},
+		{protocol: ProtocolTCP, src: server, dst: client, flags: TCPAck | TCPPsh, seq: 7001, payload: []byte("response")},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPFin | TCPAck, seq: seq + 21},
+		{protocol: ProtocolTCP, src: server, dst: client, flags: TCPFin | TCPAck, seq: 7009},
+		// A new connection on the same ports
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPSyn, seq: 100},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: 101, payload: []byte("second")},
+	}
+	h := assemble(t, packets, 0)
+
+	if len(h.closed) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(h.closed))
+	}
+	first, second := h.closed[0], h.closed[1]
+	if first.Client.String() != client || first.Server.String() != server || first.Missing != 0 {
+		t.Errorf("unexpected connection %+v", first)
+	}
+	if got := h.data[first][ClientToServer].String(); got != "abcdefghijklmnopqrst" {
+		t.Errorf("unexpected client data %q", got)
+	}
+	if got := h.data[first][ServerToClient].String(); got != "response" {
+		t.Errorf("unexpected server data %q", got)
+	}
+	if got := h.data[second][ClientToServer].String(); got != "second" {
+		t.Errorf("unexpected data of the reused ports %q", got)
+	}
+}
+
+func TestAssemblerGaps(t *testing.T) {
+	client, server := guest+":49171", "203.0.113.5:443"
+	packets := []*testPacket{
+		// Only the SYN-ACK was captured
+		{protocol: ProtocolTCP, src: server, dst: client, flags: TCPSyn | TCPAck, seq: 10, ack: 501},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: 501, payload: []byte("1234")},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: 510, payload: []byte("abcd")},
+		{protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck, seq: 514, payload: []byte("efgh")},
+	}
+
+	// The missing bytes are given up on once too much is buffered
+	h := assemble(t, packets, 4)
+	conn := h.closed[0]
+	if conn.Client.String() != client || conn.Missing != 5 {
+		t.Errorf("unexpected connection %+v", conn)
+	}
+	if got := h.data[conn][ClientToServer].String(); got != "1234abcdefgh" {
+		t.Errorf("unexpected data %q", got)
+	}
+
+	// Or at the end of the capture
+	h = assemble(t, packets, 0)
+	if got := h.data[h.closed[0]][ClientToServer].String(); got != "1234abcdefgh" {
+		t.Errorf("unexpected data %q", got)
+	}
+}
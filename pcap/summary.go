@@ -0,0 +1,415 @@
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// defaultResultserverPort is the port of the cuckoo resultserver when the machine doesn't say
+const defaultResultserverPort = 2042
+
+// Endpoint is an address and port of a flow
+type Endpoint struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.IP, strconv.Itoa(e.Port))
+}
+
+// Flow is the traffic between two endpoints over TCP or UDP
+type Flow struct {
+	// "tcp" or "udp"
+	Protocol string `json:"protocol"`
+	// Client is the endpoint that opened the flow (sent the SYN, or the first packet)
+	Client    Endpoint  `json:"client"`
+	Server    Endpoint  `json:"server"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Packets and IP bytes sent by the client and by the server
+	ClientPackets int   `json:"client_packets"`
+	ClientBytes   int64 `json:"client_bytes"`
+	ServerPackets int   `json:"server_packets"`
+	ServerBytes   int64 `json:"server_bytes"`
+}
+
+// HTTPRequest is the request line and main headers of an HTTP request
+type HTTPRequest struct {
+	Time      time.Time `json:"time"`
+	Client    Endpoint  `json:"client"`
+	Server    Endpoint  `json:"server"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Version   string    `json:"version"`
+	Host      string    `json:"host,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// URL returns the absolute URL of the request, using the server address when there is no Host header
+func (r *HTTPRequest) URL() string {
+	if strings.HasPrefix(r.URI, "http://") || strings.HasPrefix(r.URI, "https://") {
+		return r.URI
+	}
+	host := r.Host
+	if host == "" {
+		host = r.Server.IP
+		if r.Server.Port != 80 {
+			host = r.Server.String()
+		}
+	}
+	return "http://" + host + r.URI
+}
+
+// Summary is the traffic of a capture
+type Summary struct {
+	// Flows ordered by the time they started
+	Flows []*Flow        `json:"flows"`
+	DNS   []*DNSQuery    `json:"dns"`
+	HTTP  []*HTTPRequest `json:"http"`
+	// Packets read, and packets that could not be decoded
+	Packets int `json:"packets"`
+	Skipped int `json:"skipped"`
+}
+
+// Options control the summary
+type Options struct {
+	// Analysis machines whose resultserver traffic is left out
+	Machines []*cuckoo.Machine
+	// Keep the traffic to the resultserver
+	IncludeResultserver bool
+}
+
+// Summarize Reads a capture and summarizes its TCP and UDP flows, DNS queries and HTTP requests.
+//
+// The traffic between the guest and the cuckoo resultserver of opts.Machines is left out unless
+// opts.IncludeResultserver is set.
+func Summarize(r io.Reader, opts *Options) (*Summary, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	reader, err := NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &summarizer{
+		summary:  &Summary{Flows: []*Flow{}, DNS: []*DNSQuery{}, HTTP: []*HTTPRequest{}},
+		flows:    map[flowKey]*Flow{},
+		queries:  map[string]*DNSQuery{},
+		excluded: excludedEndpoints(opts),
+	}
+	linkType := reader.Header().LinkType
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.summary.Packets++
+		packet, err := Decode(linkType, record)
+		if err != nil {
+			s.summary.Skipped++
+			continue
+		}
+		s.add(packet)
+	}
+
+	sort.SliceStable(s.summary.Flows, func(i, j int) bool {
+		return s.summary.Flows[i].FirstSeen.Before(s.summary.Flows[j].FirstSeen)
+	})
+	return s.summary, nil
+}
+
+// SummarizeTask Downloads the capture of the specified task ID and summarizes it without its resultserver traffic,
+// see Summarize.
+func SummarizeTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*Summary, error) {
+	opts, err := withTaskMachine(ctx, c, taskID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.PcapGet(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return Summarize(body, opts)
+}
+
+// withTaskMachine returns a copy of opts with the machine the task ran on added
+func withTaskMachine(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*Options, error) {
+	withMachine := &Options{}
+	if opts != nil {
+		*withMachine = *opts
+	}
+
+	task, err := c.TasksView(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := task.Machine.(string)
+	if guest, ok := task.Guest.(map[string]interface{}); ok && name == "" {
+		name, _ = guest["name"].(string)
+	}
+	if name == "" {
+		return withMachine, nil
+	}
+
+	machine, err := c.MachinesView(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up machine %s: %w", name, err)
+	}
+	withMachine.Machines = append([]*cuckoo.Machine{machine}, withMachine.Machines...)
+	return withMachine, nil
+}
+
+// IPs returns the addresses the clients of the flows talked to, sorted
+func (s *Summary) IPs() []string {
+	set := map[string]bool{}
+	for _, flow := range s.Flows {
+		set[flow.Server.IP] = true
+	}
+	return sortedKeys(set)
+}
+
+// Domains returns the names that were looked up, sorted
+func (s *Summary) Domains() []string {
+	set := map[string]bool{}
+	for _, query := range s.DNS {
+		if query.Name != "" {
+			set[strings.ToLower(query.Name)] = true
+		}
+	}
+	return sortedKeys(set)
+}
+
+// URLs returns the URLs that were requested, sorted
+func (s *Summary) URLs() []string {
+	set := map[string]bool{}
+	for _, request := range s.HTTP {
+		set[request.URL()] = true
+	}
+	return sortedKeys(set)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// flowKey identifies a flow regardless of direction, the lowest endpoint comes first
+type flowKey struct {
+	protocol uint8
+	a, b     string
+}
+
+func newFlowKey(protocol uint8, src, dst Endpoint) flowKey {
+	a, b := src.String(), dst.String()
+	if b < a {
+		a, b = b, a
+	}
+	return flowKey{protocol: protocol, a: a, b: b}
+}
+
+// summarizer holds the state of a single Summarize
+type summarizer struct {
+	summary  *Summary
+	flows    map[flowKey]*Flow
+	queries  map[string]*DNSQuery
+	excluded []*excludedEndpoint
+}
+
+// excludedEndpoint is a resultserver, a zero port matches any port
+type excludedEndpoint struct {
+	ip   net.IP
+	port int
+}
+
+func excludedEndpoints(opts *Options) []*excludedEndpoint {
+	if opts.IncludeResultserver {
+		return nil
+	}
+	excluded := []*excludedEndpoint{}
+	for _, machine := range opts.Machines {
+		ip := net.ParseIP(machine.ResultserverIP)
+		if ip == nil {
+			continue
+		}
+		port, err := strconv.Atoi(machine.ResultserverPort)
+		if err != nil || port == 0 {
+			port = defaultResultserverPort
+		}
+		excluded = append(excluded, &excludedEndpoint{ip: ip, port: port})
+	}
+	return excluded
+}
+
+func (s *summarizer) isExcluded(ip net.IP, port uint16) bool {
+	for _, e := range s.excluded {
+		if e.ip.Equal(ip) && (e.port == 0 || e.port == int(port)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *summarizer) add(p *Packet) {
+	if p.TransportOffset < 0 || (p.Protocol != ProtocolTCP && p.Protocol != ProtocolUDP) {
+		return
+	}
+	if s.isExcluded(p.SrcIP, p.SrcPort) || s.isExcluded(p.DstIP, p.DstPort) {
+		return
+	}
+
+	src := Endpoint{IP: p.SrcIP.String(), Port: int(p.SrcPort)}
+	dst := Endpoint{IP: p.DstIP.String(), Port: int(p.DstPort)}
+	key := newFlowKey(p.Protocol, src, dst)
+	flow, ok := s.flows[key]
+	if !ok || (p.Protocol == ProtocolTCP && p.Flags.Has(TCPSyn) && !p.Flags.Has(TCPAck) && flow.Client != src) {
+		if !ok {
+			protocol := "udp"
+			if p.Protocol == ProtocolTCP {
+				protocol = "tcp"
+			}
+			flow = &Flow{Protocol: protocol, FirstSeen: p.Timestamp}
+			s.flows[key] = flow
+			s.summary.Flows = append(s.summary.Flows, flow)
+		}
+		// The sender of the SYN opens the flow, even if an earlier packet was seen the other way
+		if flow.Client != src {
+			flow.Client, flow.Server = src, dst
+			flow.ClientPackets, flow.ServerPackets = flow.ServerPackets, flow.ClientPackets
+			flow.ClientBytes, flow.ServerBytes = flow.ServerBytes, flow.ClientBytes
+		}
+	}
+
+	length := int64(p.IPLength)
+	if flow.Client == src {
+		flow.ClientPackets++
+		flow.ClientBytes += length
+	} else {
+		flow.ServerPackets++
+		flow.ServerBytes += length
+	}
+	flow.LastSeen = p.Timestamp
+
+	switch {
+	case p.Protocol == ProtocolUDP && (p.SrcPort == 53 || p.DstPort == 53):
+		s.dns(p, src, dst, p.Payload)
+	case p.Protocol == ProtocolTCP && (p.SrcPort == 53 || p.DstPort == 53) && len(p.Payload) > 2:
+		// DNS over TCP, only messages within a single segment are decoded
+		length := int(binary.BigEndian.Uint16(p.Payload[0:2]))
+		if len(p.Payload) >= 2+length {
+			s.dns(p, src, dst, p.Payload[2:2+length])
+		}
+	case p.Protocol == ProtocolTCP && len(p.Payload) > 0:
+		s.http(p, src, dst)
+	}
+}
+
+func (s *summarizer) dns(p *Packet, src, dst Endpoint, payload []byte) {
+	message, err := parseDNS(payload)
+	if err != nil || len(message.questions) == 0 {
+		return
+	}
+
+	client, server := src, dst
+	if message.response {
+		client, server = dst, src
+	}
+	question := message.questions[0]
+	key := fmt.Sprintf("%s|%s|%d|%s|%s", client, server, message.id, strings.ToLower(question[0]), question[1])
+
+	query, ok := s.queries[key]
+	if !ok || (!message.response && query.Responded) {
+		query = &DNSQuery{
+			Time:    p.Timestamp,
+			Client:  client,
+			Server:  server,
+			ID:      message.id,
+			Name:    question[0],
+			Type:    question[1],
+			Answers: []*DNSAnswer{},
+		}
+		s.queries[key] = query
+		s.summary.DNS = append(s.summary.DNS, query)
+	}
+	if message.response {
+		query.Responded = true
+		query.RCode = message.rcode
+		query.Answers = append(query.Answers, message.answers...)
+	}
+}
+
+// httpMethods are the methods recognized at the start of a TCP payload
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+func (s *summarizer) http(p *Packet, src, dst Endpoint) {
+	payload := p.Payload
+	space := bytes.IndexByte(payload, ' ')
+	if space < 3 || space > 7 || !isHTTPMethod(string(payload[:space])) {
+		return
+	}
+
+	end := bytes.Index(payload, []byte("\r\n\r\n"))
+	if end < 0 {
+		end = len(payload)
+	}
+	lines := strings.Split(string(payload[:end]), "\r\n")
+	parts := strings.Split(lines[0], " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		return
+	}
+
+	request := &HTTPRequest{
+		Time:    p.Timestamp,
+		Client:  src,
+		Server:  dst,
+		Method:  parts[0],
+		URI:     parts[1],
+		Version: parts[2],
+	}
+	for _, line := range lines[1:] {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		switch strings.ToLower(line[:colon]) {
+		case "host":
+			request.Host = value
+		case "user-agent":
+			request.UserAgent = value
+		}
+	}
+	s.summary.HTTP = append(s.summary.HTTP, request)
+}
+
+func isHTTPMethod(method string) bool {
+	for _, m := range httpMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+const (
+	guest        = "192.168.56.101"
+	resultserver = "192.168.56.1"
+)
+
+// sandboxTraffic is a DNS lookup, an HTTP request and the upload of the results to the resultserver
+func sandboxTraffic() []*testPacket {
+	request := []byte("GET /gate.php?id=1 HTTP/1.1\r\nHost: evil.example.com\r\nUser-Agent: Mozilla/4.0 (compatible; MSIE 8.0)\r\n\r\n")
+	return []*testPacket{
+		{at: 0, protocol: ProtocolTCP, src: guest + ":49160", dst: resultserver + ":2042", flags: TCPSyn},
+		{at: time.Millisecond, protocol: ProtocolTCP, src: guest + ":49160", dst: resultserver + ":2042", flags: TCPAck | TCPPsh, payload: []byte("BSON")},
+		{at: time.Second, protocol: ProtocolUDP, src: guest + ":51000", dst: "8.8.8.8:53", payload: dnsQuestion(0x1234, "evil.example.com", 1)},
+		{at: time.Second + 20*time.Millisecond, protocol: ProtocolUDP, src: "8.8.8.8:53", dst: guest + ":51000",
+			payload: dnsResponse(0x1234, "evil.example.com", "cdn.example.net", "185.100.87.202", "185.100.87.203")},
+		{at: 2 * time.Second, protocol: ProtocolTCP, src: guest + ":49161", dst: "185.100.87.202:80", flags: TCPSyn, seq: 100},
+		{at: 2*time.Second + 10*time.Millisecond, protocol: ProtocolTCP, src: "185.100.87.202:80", dst: guest + ":49161", flags: TCPSyn | TCPAck, seq: 500, ack: 101},
+		{at: 2*time.Second + 11*time.Millisecond, protocol: ProtocolTCP, src: guest + ":49161", dst: "185.100.87.202:80", flags: TCPAck, seq: 101, ack: 501},
+		{at: 2*time.Second + 12*time.Millisecond, protocol: ProtocolTCP, src: guest + ":49161", dst: "185.100.87.202:80", flags: TCPAck | TCPPsh, seq: 101, ack: 501, payload: request},
+		{at: 3 * time.Second, protocol: ProtocolUDP, src: "[fe80::1]:5353", dst: "[ff02::fb]:5353", payload: []byte("mdns")},
+	}
+}
+
+func testMachine() *cuckoo.Machine {
+	return &cuckoo.Machine{Name: "win7-1", IP: guest, ResultserverIP: resultserver, ResultserverPort: "2042"}
+}
+
+func TestSummarize(t *testing.T) {
+	capture := writeTestPcap(t, sandboxTraffic())
+	summary, err := Summarize(bytes.NewReader(capture), &Options{Machines: []*cuckoo.Machine{testMachine()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Packets != 9 || summary.Skipped != 0 {
+		t.Errorf("unexpected packet counts %d %d", summary.Packets, summary.Skipped)
+	}
+	if len(summary.Flows) != 3 {
+		t.Fatalf("expected 3 flows, got %d", len(summary.Flows))
+	}
+
+	dns := summary.Flows[0]
+	if dns.Protocol != "udp" || dns.Client.String() != guest+":51000" || dns.Server.String() != "8.8.8.8:53" ||
+		dns.ClientPackets != 1 || dns.ServerPackets != 1 {
+		t.Errorf("unexpected dns flow %+v", dns)
+	}
+	web := summary.Flows[1]
+	if web.Protocol != "tcp" || web.Server.String() != "185.100.87.202:80" || web.ClientPackets != 3 || web.ServerPackets != 1 {
+		t.Errorf("unexpected http flow %+v", web)
+	}
+	if web.ServerBytes != 40 || !web.FirstSeen.Equal(epoch.Add(2*time.Second)) || !web.LastSeen.Equal(epoch.Add(2*time.Second+12*time.Millisecond)) {
+		t.Errorf("unexpected http flow %+v", web)
+	}
+	if mdns := summary.Flows[2]; mdns.Client.String() != "[fe80::1]:5353" {
+		t.Errorf("unexpected ipv6 flow %+v", mdns)
+	}
+
+	if len(summary.DNS) != 1 {
+		t.Fatalf("expected 1 dns query, got %d", len(summary.DNS))
+	}
+	query := summary.DNS[0]
+	if query.Name != "evil.example.com" || query.Type != "A" || !query.Responded || query.RCode != "NOERROR" || len(query.Answers) != 3 {
+		t.Fatalf("unexpected query %+v", query)
+	}
+	if answer := query.Answers[0]; answer.Name != "evil.example.com" || answer.Type != "CNAME" || answer.Data != "cdn.example.net" {
+		t.Errorf("unexpected cname %+v", answer)
+	}
+	if answer := query.Answers[2]; answer.Name != "cdn.example.net" || answer.Data != "185.100.87.203" || answer.TTL != 60 {
+		t.Errorf("unexpected address %+v", answer)
+	}
+
+	if len(summary.HTTP) != 1 {
+		t.Fatalf("expected 1 http request, got %d", len(summary.HTTP))
+	}
+	request := summary.HTTP[0]
+	if request.Method != "GET" || request.Host != "evil.example.com" || request.UserAgent != "Mozilla/4.0 (compatible; MSIE 8.0)" ||
+		request.URL() != "http://evil.example.com/gate.php?id=1" {
+		t.Errorf("unexpected request %+v", request)
+	}
+
+	if ips := summary.IPs(); !reflect.DeepEqual(ips, []string{"185.100.87.202", "8.8.8.8", "ff02::fb"}) {
+		t.Errorf("unexpected ips %v", ips)
+	}
+	if domains := summary.Domains(); !reflect.DeepEqual(domains, []string{"evil.example.com"}) {
+		t.Errorf("unexpected domains %v", domains)
+	}
+}
+
+func TestSummarizeResultserver(t *testing.T) {
+	capture := writeTestPcap(t, sandboxTraffic())
+	summary, err := Summarize(bytes.NewReader(capture), &Options{Machines: []*cuckoo.Machine{testMachine()}, IncludeResultserver: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Flows) != 4 || summary.Flows[0].Server.String() != resultserver+":2042" {
+		t.Errorf("expected the resultserver flow first, got %+v", summary.Flows[0])
+	}
+}
+
+func TestSummarizeTask(t *testing.T) {
+	capture := writeTestPcap(t, sandboxTraffic())
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/view/42":
+			fmt.Fprint(w, `{"task": {"id": 42, "machine": null, "guest": {"name": "win7-1"}}}`)
+		case "/pcap/get/42":
+			w.Write(capture)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	summary, err := SummarizeTask(context.Background(), c, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Flows) != 3 {
+		t.Errorf("expected the resultserver flow to be left out, got %d flows", len(summary.Flows))
+	}
+}
+
+func TestReaderFormats(t *testing.T) {
+	capture := writeTestPcap(t, sandboxTraffic()[:1])
+
+	// Same capture, big endian with nanosecond timestamps
+	big := []byte{0xa1, 0xb2, 0x3c, 0x4d, 0, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0, 0, 0, 1}
+	seconds, nanos := uint32(epoch.Unix()), uint32(123)
+	frame := capture[24+16:]
+	record := []byte{byte(seconds >> 24), byte(seconds >> 16), byte(seconds >> 8), byte(seconds), 0, 0, 0, byte(nanos),
+		0, 0, 0, byte(len(frame)), 0, 0, 0, byte(len(frame))}
+	big = append(append(big, record...), frame...)
+
+	reader, err := NewReader(bytes.NewReader(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reader.Header().Nanoseconds || reader.Header().LinkType != LinkTypeEthernet {
+		t.Errorf("unexpected header %+v", reader.Header())
+	}
+	rec, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Timestamp.Nanosecond() != 123 || !bytes.Equal(rec.Data, frame) {
+		t.Errorf("unexpected record %+v", rec)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte{0x0a, 0x0d, 0x0d, 0x0a, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})); err != ErrPcapNG {
+		t.Errorf("expected ErrPcapNG, got %v", err)
+	}
+	if _, err := Summarize(bytes.NewReader(capture[:len(capture)-3]), nil); err == nil {
+		t.Errorf("expected an error for a truncated capture")
+	}
+}
@@ -0,0 +1,427 @@
+package pcap
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// maxHandshakeSize bounds the handshake bytes kept per direction
+const maxHandshakeSize = 256 * 1024
+
+// TLS record content types and handshake message types
+const (
+	tlsChangeCipherSpec = 20
+	tlsHandshake        = 22
+
+	tlsClientHello     = 1
+	tlsServerHello     = 2
+	tlsCertificate     = 11
+	tlsServerHelloDone = 14
+)
+
+// TLS extensions used by the fingerprints
+const (
+	tlsExtServerName        = 0
+	tlsExtSupportedGroups   = 10
+	tlsExtECPointFormats    = 11
+	tlsExtALPN              = 16
+	tlsExtSupportedVersions = 43
+)
+
+// TLSSession is the cleartext part of a TLS handshake seen on a TCP connection
+type TLSSession struct {
+	Client Endpoint  `json:"client"`
+	Server Endpoint  `json:"server"`
+	Time   time.Time `json:"time"`
+
+	// Negotiated version (e.g. "TLS 1.2"), empty without a ServerHello
+	Version string `json:"version,omitempty"`
+	// Server name indication sent by the client
+	SNI string `json:"sni,omitempty"`
+	// Protocols offered by the client and the one selected by the server
+	ALPN       []string `json:"alpn,omitempty"`
+	ServerALPN string   `json:"server_alpn,omitempty"`
+	// Cipher suite selected by the server, e.g. "0xc02f"
+	CipherSuite string `json:"cipher_suite,omitempty"`
+
+	JA3      string `json:"ja3,omitempty"`
+	JA3Hash  string `json:"ja3_hash,omitempty"`
+	JA3S     string `json:"ja3s,omitempty"`
+	JA3SHash string `json:"ja3s_hash,omitempty"`
+
+	// Certificates sent by the server, leaf first.  TLS 1.3 encrypts them, so they are only seen up to TLS 1.2.
+	Certificates []*Certificate `json:"certificates,omitempty"`
+}
+
+// Certificate is a certificate of a TLS handshake
+type Certificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	DNSNames     []string  `json:"dns_names,omitempty"`
+	SHA1         string    `json:"sha1"`
+	SHA256       string    `json:"sha256"`
+}
+
+// ExtractTLS Reads a capture and returns the TLS handshakes of its TCP connections, in the order they started.
+//
+// Connections are recognized by their content so TLS on any port is found.  The traffic to the resultserver of
+// opts.Machines is left out, see Summarize.
+func ExtractTLS(r io.Reader, opts *Options) ([]*TLSSession, error) {
+	h := &tlsHandler{conns: map[*Conn]*tlsConn{}, sessions: []*TLSSession{}}
+	if err := Assemble(r, opts, h); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(h.sessions, func(i, j int) bool { return h.sessions[i].Time.Before(h.sessions[j].Time) })
+	return h.sessions, nil
+}
+
+// ExtractTLSTask Downloads the capture of the specified task ID and extracts its TLS handshakes, see ExtractTLS.
+func ExtractTLSTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) ([]*TLSSession, error) {
+	h := &tlsHandler{conns: map[*Conn]*tlsConn{}, sessions: []*TLSSession{}}
+	if err := AssembleTask(ctx, c, taskID, opts, h); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(h.sessions, func(i, j int) bool { return h.sessions[i].Time.Before(h.sessions[j].Time) })
+	return h.sessions, nil
+}
+
+// tlsHandler follows the handshakes of the connections of an assembler
+type tlsHandler struct {
+	conns    map[*Conn]*tlsConn
+	sessions []*TLSSession
+}
+
+// tlsConn is the handshake state of a connection
+type tlsConn struct {
+	session *TLSSession
+	// Reassembled records and handshake messages of each direction
+	records   [2][]byte
+	handshake [2][]byte
+	done      [2]bool
+}
+
+func (h *tlsHandler) Data(conn *Conn, direction Direction, data []byte, timestamp time.Time) {
+	c, ok := h.conns[conn]
+	if !ok {
+		// Only follow connections whose client starts with a TLS handshake record
+		if direction != ClientToServer || len(data) < 3 || data[0] != tlsHandshake || data[1] != 3 {
+			h.conns[conn] = nil
+			return
+		}
+		c = &tlsConn{session: &TLSSession{Client: conn.Client, Server: conn.Server, Time: timestamp}}
+		h.conns[conn] = c
+		h.sessions = append(h.sessions, c.session)
+	}
+	if c == nil || c.done[direction] {
+		return
+	}
+
+	c.records[direction] = append(c.records[direction], data...)
+	if len(c.records[direction]) > maxHandshakeSize {
+		c.done[direction] = true
+	}
+	c.parseRecords(direction)
+}
+
+func (h *tlsHandler) Close(conn *Conn) {
+	delete(h.conns, conn)
+}
+
+// parseRecords consumes the complete records of a direction
+func (c *tlsConn) parseRecords(direction Direction) {
+	records := c.records[direction]
+	for len(records) >= 5 && !c.done[direction] {
+		length := int(binary.BigEndian.Uint16(records[3:5]))
+		if len(records) < 5+length {
+			break
+		}
+		contentType, fragment := records[0], records[5:5+length]
+		records = records[5+length:]
+
+		switch contentType {
+		case tlsHandshake:
+			c.handshake[direction] = append(c.handshake[direction], fragment...)
+			c.parseHandshake(direction)
+		case tlsChangeCipherSpec:
+			// Everything after is encrypted
+			c.done[direction] = true
+		default:
+			c.done[direction] = true
+		}
+	}
+	c.records[direction] = records
+}
+
+// parseHandshake consumes the complete handshake messages of a direction
+func (c *tlsConn) parseHandshake(direction Direction) {
+	messages := c.handshake[direction]
+	for len(messages) >= 4 && !c.done[direction] {
+		length := int(messages[1])<<16 | int(messages[2])<<8 | int(messages[3])
+		if len(messages) < 4+length {
+			break
+		}
+		messageType, body := messages[0], messages[4:4+length]
+		messages = messages[4+length:]
+
+		switch {
+		case direction == ClientToServer && messageType == tlsClientHello:
+			c.session.clientHello(body)
+			c.done[direction] = true
+		case direction == ServerToClient && messageType == tlsServerHello:
+			c.session.serverHello(body)
+			if c.session.Version == "TLS 1.3" {
+				c.done[direction] = true
+			}
+		case direction == ServerToClient && messageType == tlsCertificate:
+			c.session.certificates(body)
+		case direction == ServerToClient && messageType == tlsServerHelloDone:
+			c.done[direction] = true
+		}
+	}
+	c.handshake[direction] = messages
+}
+
+// tlsReader reads the fields of a handshake message, remembering if it ran out of data
+type tlsReader struct {
+	data []byte
+	bad  bool
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if r.bad || n > len(r.data) {
+		r.bad = true
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *tlsReader) uint8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *tlsReader) uint16() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *tlsReader) uint24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// vector reads a length prefixed field
+func (r *tlsReader) vector(lengthSize int) *tlsReader {
+	var length int
+	switch lengthSize {
+	case 1:
+		length = r.uint8()
+	case 2:
+		length = r.uint16()
+	case 3:
+		length = r.uint24()
+	}
+	b := r.bytes(length)
+	return &tlsReader{data: b, bad: b == nil && length > 0}
+}
+
+// extension is a hello extension
+type extension struct {
+	kind int
+	data []byte
+}
+
+func (r *tlsReader) extensions() []*extension {
+	extensions := []*extension{}
+	if len(r.data) == 0 {
+		return extensions
+	}
+	all := r.vector(2)
+	for len(all.data) > 0 && !all.bad {
+		kind := all.uint16()
+		data := all.vector(2)
+		extensions = append(extensions, &extension{kind: kind, data: data.data})
+	}
+	return extensions
+}
+
+func (s *TLSSession) clientHello(body []byte) {
+	r := &tlsReader{data: body}
+	version := r.uint16()
+	r.bytes(32) // Random
+	r.vector(1) // Session ID
+	ciphers := r.vector(2)
+	r.vector(1) // Compression methods
+	extensions := r.extensions()
+	if r.bad {
+		return
+	}
+
+	suites := []string{}
+	for len(ciphers.data) >= 2 {
+		if suite := ciphers.uint16(); !isGREASE(suite) {
+			suites = append(suites, strconv.Itoa(suite))
+		}
+	}
+
+	types, curves, pointFormats := []string{}, []string{}, []string{}
+	for _, ext := range extensions {
+		if isGREASE(ext.kind) {
+			continue
+		}
+		types = append(types, strconv.Itoa(ext.kind))
+		data := &tlsReader{data: ext.data}
+		switch ext.kind {
+		case tlsExtServerName:
+			names := data.vector(2)
+			for len(names.data) > 0 && !names.bad {
+				nameType := names.uint8()
+				name := names.vector(2)
+				if nameType == 0 && s.SNI == "" {
+					s.SNI = string(name.data)
+				}
+			}
+		case tlsExtALPN:
+			protocols := data.vector(2)
+			for len(protocols.data) > 0 && !protocols.bad {
+				s.ALPN = append(s.ALPN, string(protocols.vector(1).data))
+			}
+		case tlsExtSupportedGroups:
+			groups := data.vector(2)
+			for len(groups.data) >= 2 {
+				if group := groups.uint16(); !isGREASE(group) {
+					curves = append(curves, strconv.Itoa(group))
+				}
+			}
+		case tlsExtECPointFormats:
+			for _, format := range data.vector(1).data {
+				pointFormats = append(pointFormats, strconv.Itoa(int(format)))
+			}
+		}
+	}
+
+	s.JA3 = strings.Join([]string{
+		strconv.Itoa(version),
+		strings.Join(suites, "-"),
+		strings.Join(types, "-"),
+		strings.Join(curves, "-"),
+		strings.Join(pointFormats, "-"),
+	}, ",")
+	s.JA3Hash = md5Hex(s.JA3)
+}
+
+func (s *TLSSession) serverHello(body []byte) {
+	r := &tlsReader{data: body}
+	version := r.uint16()
+	r.bytes(32) // Random
+	r.vector(1) // Session ID
+	suite := r.uint16()
+	r.uint8() // Compression method
+	extensions := r.extensions()
+	if r.bad {
+		return
+	}
+
+	negotiated := version
+	types := []string{}
+	for _, ext := range extensions {
+		types = append(types, strconv.Itoa(ext.kind))
+		data := &tlsReader{data: ext.data}
+		switch ext.kind {
+		case tlsExtSupportedVersions:
+			if selected := data.uint16(); !data.bad {
+				negotiated = selected
+			}
+		case tlsExtALPN:
+			protocols := data.vector(2)
+			s.ServerALPN = string(protocols.vector(1).data)
+		}
+	}
+
+	s.Version = tlsVersion(negotiated)
+	s.CipherSuite = fmt.Sprintf("%#04x", suite)
+	s.JA3S = strings.Join([]string{strconv.Itoa(version), strconv.Itoa(suite), strings.Join(types, "-")}, ",")
+	s.JA3SHash = md5Hex(s.JA3S)
+}
+
+func (s *TLSSession) certificates(body []byte) {
+	r := &tlsReader{data: body}
+	list := r.vector(3)
+	for len(list.data) > 0 && !list.bad {
+		der := list.vector(3)
+		if der.bad {
+			return
+		}
+		cert, err := x509.ParseCertificate(der.data)
+		if err != nil {
+			continue
+		}
+		sha1Sum := sha1.Sum(der.data)
+		sha256Sum := sha256.Sum256(der.data)
+		s.Certificates = append(s.Certificates, &Certificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: cert.SerialNumber.String(),
+			NotBefore:    cert.NotBefore,
+			NotAfter:     cert.NotAfter,
+			DNSNames:     cert.DNSNames,
+			SHA1:         hex.EncodeToString(sha1Sum[:]),
+			SHA256:       hex.EncodeToString(sha256Sum[:]),
+		})
+	}
+}
+
+// isGREASE returns true for the reserved values clients send to keep servers tolerant, JA3 ignores them
+func isGREASE(value int) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func tlsVersion(version int) string {
+	switch version {
+	case 0x0300:
+		return "SSL 3.0"
+	case 0x0301:
+		return "TLS 1.0"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0304:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("%#04x", version)
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
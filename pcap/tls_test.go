@@ -0,0 +1,188 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+// tlsVector returns data prefixed by its length on size bytes
+func tlsVector(size int, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, uint32(len(body)))
+	return append(prefix[4-size:], body...)
+}
+
+func tlsUint16s(values ...uint16) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, values)
+	return buf.Bytes()
+}
+
+func tlsExtension(kind uint16, data []byte) []byte {
+	return append(tlsUint16s(kind), tlsVector(2, data)...)
+}
+
+// tlsMessage wraps a handshake message into a record
+func tlsMessage(messageType byte, body []byte) []byte {
+	message := append([]byte{messageType}, tlsVector(3, body)...)
+	return append([]byte{tlsHandshake, 3, 1}, tlsVector(2, message)...)
+}
+
+func testClientHello() []byte {
+	random := make([]byte, 32)
+	return tlsMessage(tlsClientHello, bytes.Join([][]byte{
+		tlsUint16s(0x0303),
+		random,
+		tlsVector(1),
+		tlsVector(2, tlsUint16s(0x2a2a, 0xc02f, 0xc030, 0x009c)),
+		tlsVector(1, []byte{0}),
+		tlsVector(2,
+			tlsExtension(0x1a1a, nil),
+			tlsExtension(tlsExtServerName, tlsVector(2, []byte{0}, tlsVector(2, []byte("evil.example.com")))),
+			tlsExtension(tlsExtSupportedGroups, tlsVector(2, tlsUint16s(0x3a3a, 29, 23, 24))),
+			tlsExtension(tlsExtECPointFormats, tlsVector(1, []byte{0})),
+			tlsExtension(tlsExtALPN, tlsVector(2, tlsVector(1, []byte("h2")), tlsVector(1, []byte("http/1.1")))),
+		),
+	}, nil))
+}
+
+func testServerHello(version uint16) []byte {
+	extensions := [][]byte{tlsExtension(tlsExtALPN, tlsVector(2, tlsVector(1, []byte("h2"))))}
+	if version == 0x0304 {
+		extensions = append(extensions, tlsExtension(tlsExtSupportedVersions, tlsUint16s(0x0304)))
+	}
+	return tlsMessage(tlsServerHello, bytes.Join([][]byte{
+		tlsUint16s(0x0303),
+		make([]byte, 32),
+		tlsVector(1),
+		tlsUint16s(0xc02f),
+		{0},
+		tlsVector(2, extensions...),
+	}, nil))
+}
+
+func testCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(4242),
+		Subject:      pkix.Name{CommonName: "evil.example.com"},
+		DNSNames:     []string{"evil.example.com"},
+		NotBefore:    epoch,
+		NotAfter:     epoch.Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// tlsTraffic is a TLS 1.2 handshake whose server flight is split over out of order segments, and a TLS 1.3 one
+func tlsTraffic(t *testing.T) []*testPacket {
+	serverFlight := bytes.Join([][]byte{
+		testServerHello(0x0303),
+		tlsMessage(tlsCertificate, tlsVector(3, tlsVector(3, testCertificate(t)))),
+		tlsMessage(tlsServerHelloDone, nil),
+	}, nil)
+	half := len(serverFlight) / 2
+
+	client, server := guest+":49180", "185.100.87.202:443"
+	client13, server13 := guest+":49181", "185.100.87.203:8443"
+	return []*testPacket{
+		{at: 0, protocol: ProtocolTCP, src: client, dst: server, flags: TCPSyn, seq: 100},
+		{at: 10 * time.Millisecond, protocol: ProtocolTCP, src: server, dst: client, flags: TCPSyn | TCPAck, seq: 900, ack: 101},
+		{at: 11 * time.Millisecond, protocol: ProtocolTCP, src: client, dst: server, flags: TCPAck | TCPPsh, seq: 101, payload: testClientHello()},
+		{at: 21 * time.Millisecond, protocol: ProtocolTCP, src: server, dst: client, flags: TCPAck, seq: 901 + uint32(half), payload: serverFlight[half:]},
+		{at: 22 * time.Millisecond, protocol: ProtocolTCP, src: server, dst: client, flags: TCPAck, seq: 901, payload: serverFlight[:half]},
+
+		{at: time.Second, protocol: ProtocolTCP, src: client13, dst: server13, flags: TCPSyn, seq: 100},
+		{at: time.Second + time.Millisecond, protocol: ProtocolTCP, src: client13, dst: server13, flags: TCPAck | TCPPsh, seq: 101, payload: testClientHello()},
+		{at: time.Second + 2*time.Millisecond, protocol: ProtocolTCP, src: server13, dst: client13, flags: TCPSyn | TCPAck, seq: 300, ack: 101},
+		{at: time.Second + 3*time.Millisecond, protocol: ProtocolTCP, src: server13, dst: client13, flags: TCPAck, seq: 301, payload: testServerHello(0x0304)},
+
+		// Plain text on port 443 is not TLS
+		{at: 2 * time.Second, protocol: ProtocolTCP, src: guest + ":49182", dst: "185.100.87.202:443", flags: TCPAck | TCPPsh, seq: 1, payload: []byte("GET / HTTP/1.0\r\n\r\n")},
+	}
+}
+
+func TestExtractTLS(t *testing.T) {
+	sessions, err := ExtractTLS(bytes.NewReader(writeTestPcap(t, tlsTraffic(t))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+
+	s := sessions[0]
+	if s.Client.String() != guest+":49180" || s.Server.String() != "185.100.87.202:443" || !s.Time.Equal(epoch.Add(11*time.Millisecond)) {
+		t.Errorf("unexpected session %+v", s)
+	}
+	if s.Version != "TLS 1.2" || s.SNI != "evil.example.com" || !reflect.DeepEqual(s.ALPN, []string{"h2", "http/1.1"}) ||
+		s.ServerALPN != "h2" || s.CipherSuite != "0xc02f" {
+		t.Errorf("unexpected handshake %+v", s)
+	}
+	// GREASE values are left out
+	if s.JA3 != "771,49199-49200-156,0-10-11-16,29-23-24,0" || s.JA3Hash != md5Hex(s.JA3) {
+		t.Errorf("unexpected ja3 %s %s", s.JA3, s.JA3Hash)
+	}
+	if s.JA3S != "771,49199,16" || s.JA3SHash != md5Hex(s.JA3S) {
+		t.Errorf("unexpected ja3s %s %s", s.JA3S, s.JA3SHash)
+	}
+	if len(s.Certificates) != 1 {
+		t.Fatalf("expected a certificate, got %d", len(s.Certificates))
+	}
+	cert := s.Certificates[0]
+	if cert.Subject != "CN=evil.example.com" || cert.Issuer != cert.Subject || cert.SerialNumber != "4242" ||
+		!cert.NotBefore.Equal(epoch) || !reflect.DeepEqual(cert.DNSNames, []string{"evil.example.com"}) || len(cert.SHA256) != 64 {
+		t.Errorf("unexpected certificate %+v", cert)
+	}
+
+	s = sessions[1]
+	if s.Version != "TLS 1.3" || s.JA3 != sessions[0].JA3 || s.JA3S != "771,49199,16-43" || len(s.Certificates) != 0 {
+		t.Errorf("unexpected TLS 1.3 session %+v", s)
+	}
+}
+
+func TestExtractTLSTask(t *testing.T) {
+	capture := writeTestPcap(t, tlsTraffic(t))
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/view/42":
+			fmt.Fprint(w, `{"task": {"id": 42, "machine": "win7-1"}}`)
+		case "/pcap/get/42":
+			w.Write(capture)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	sessions, err := ExtractTLSTask(context.Background(), c, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].SNI != "evil.example.com" {
+		t.Errorf("unexpected sessions %+v", sessions)
+	}
+}
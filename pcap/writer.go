@@ -0,0 +1,76 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Writer writes a capture in the classic pcap format
+type Writer struct {
+	w      io.Writer
+	header *Header
+	buf    [16]byte
+}
+
+// NewWriter Writes the global header of a capture.  The byte order defaults to little endian and the version to
+// 2.4, so the header of a Reader can be passed as is to write a capture like the one read.
+func NewWriter(w io.Writer, header *Header) (*Writer, error) {
+	h := *header
+	if h.ByteOrder == nil {
+		h.ByteOrder = binary.LittleEndian
+	}
+	if h.VersionMajor == 0 {
+		h.VersionMajor, h.VersionMinor = 2, 4
+	}
+	if h.SnapLen == 0 {
+		h.SnapLen = 65535
+	}
+
+	magic := uint32(magicMicroseconds)
+	if h.Nanoseconds {
+		magic = magicNanoseconds
+	}
+	var raw [24]byte
+	order := h.ByteOrder
+	order.PutUint32(raw[0:4], magic)
+	order.PutUint16(raw[4:6], h.VersionMajor)
+	order.PutUint16(raw[6:8], h.VersionMinor)
+	order.PutUint32(raw[8:12], uint32(h.ThisZone))
+	order.PutUint32(raw[12:16], h.SigFigs)
+	order.PutUint32(raw[16:20], h.SnapLen)
+	order.PutUint32(raw[20:24], h.LinkType)
+	if _, err := w.Write(raw[:]); err != nil {
+		return nil, fmt.Errorf("pcap: error writing header: %w", err)
+	}
+
+	return &Writer{w: w, header: &h}, nil
+}
+
+// Write writes a record.  Data longer than the snap length of the capture is truncated.
+func (w *Writer) Write(record *Record) error {
+	data := record.Data
+	if len(data) > int(w.header.SnapLen) {
+		data = data[:w.header.SnapLen]
+	}
+	length := record.Length
+	if length < len(data) {
+		length = len(data)
+	}
+
+	fraction := record.Timestamp.Nanosecond()
+	if !w.header.Nanoseconds {
+		fraction /= int(time.Microsecond)
+	}
+	order := w.header.ByteOrder
+	order.PutUint32(w.buf[0:4], uint32(record.Timestamp.Unix()))
+	order.PutUint32(w.buf[4:8], uint32(fraction))
+	order.PutUint32(w.buf[8:12], uint32(len(data)))
+	order.PutUint32(w.buf[12:16], uint32(length))
+	if _, err := w.w.Write(w.buf[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
@@ -0,0 +1,304 @@
+// Package proctree rebuilds the process tree of a cuckoo analysis and renders it as text, Graphviz DOT or JSON
+package proctree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// Tree is the process tree of an analysis
+type Tree struct {
+	Roots []*Node `json:"roots"`
+}
+
+// Node is a process in the tree
+type Node struct {
+	PID         int            `json:"pid"`
+	PPID        int            `json:"ppid"`
+	Name        string         `json:"name"`
+	Path        string         `json:"path,omitempty"`
+	CommandLine string         `json:"command_line"`
+	FirstSeen   time.Time      `json:"first_seen"`
+	APICalls    int            `json:"api_calls"`
+	APICounts   map[string]int `json:"api_counts,omitempty"`
+	Children    []*Node        `json:"children"`
+}
+
+// Build Rebuilds the process tree from the behavior section of a report.
+//
+// The processtree of the report is used when present, otherwise the tree is derived from the parent PIDs of the
+// monitored processes.  API call counts come from apistats.
+func Build(report *cuckoo.Report) *Tree {
+	if report.Behavior == nil {
+		return &Tree{Roots: []*Node{}}
+	}
+	return build(report.Behavior.ProcessTree, report.Behavior.Processes, report.Behavior.APIStats)
+}
+
+// BuildTask Builds the process tree of a task while streaming its report, so the API calls are never decoded.
+func BuildTask(ctx context.Context, c *cuckoo.Client, taskID int) (*Tree, error) {
+	tree := []*cuckoo.ProcessTreeNode{}
+	processes := []*cuckoo.Process{}
+	stats := map[string]map[string]int{}
+
+	err := c.TasksReportWalk(ctx, taskID, map[string]cuckoo.SectionHandler{
+		"behavior.processtree": func(path string, dec *json.Decoder) error {
+			return dec.Decode(&tree)
+		},
+		"behavior.apistats": func(path string, dec *json.Decoder) error {
+			return dec.Decode(&stats)
+		},
+		"behavior.processes[]": func(path string, dec *json.Decoder) error {
+			process := &cuckoo.Process{}
+			processes = append(processes, process)
+			return cuckoo.WalkValue(dec, map[string]cuckoo.SectionHandler{
+				"pid":          decodeInto(&process.PID),
+				"ppid":         decodeInto(&process.PPID),
+				"process_name": decodeInto(&process.ProcessName),
+				"process_path": decodeInto(&process.ProcessPath),
+				"command_line": decodeInto(&process.CommandLine),
+				"first_seen":   decodeInto(&process.FirstSeen),
+			})
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return build(tree, processes, stats), nil
+}
+
+// decodeInto returns a handler decoding the value into v
+func decodeInto(v interface{}) cuckoo.SectionHandler {
+	return func(path string, dec *json.Decoder) error {
+		return dec.Decode(v)
+	}
+}
+
+func build(tree []*cuckoo.ProcessTreeNode, processes []*cuckoo.Process, stats map[string]map[string]int) *Tree {
+	byPID := map[int]*cuckoo.Process{}
+	for _, process := range processes {
+		byPID[process.PID] = process
+	}
+
+	newNode := func(pid, ppid int, name, commandLine string, firstSeen time.Time) *Node {
+		node := &Node{
+			PID:         pid,
+			PPID:        ppid,
+			Name:        name,
+			CommandLine: commandLine,
+			FirstSeen:   firstSeen,
+			APICounts:   stats[strconv.Itoa(pid)],
+			Children:    []*Node{},
+		}
+		for _, count := range node.APICounts {
+			node.APICalls += count
+		}
+		if process, ok := byPID[pid]; ok {
+			node.Path = process.ProcessPath
+			if node.APICounts == nil {
+				node.APICalls = len(process.Calls)
+			}
+		}
+		return node
+	}
+
+	result := &Tree{Roots: []*Node{}}
+	if len(tree) > 0 {
+		var convert func(source *cuckoo.ProcessTreeNode) *Node
+		convert = func(source *cuckoo.ProcessTreeNode) *Node {
+			node := newNode(source.PID, source.PPID, source.ProcessName, source.CommandLine, source.FirstSeen.Time)
+			for _, child := range source.Children {
+				node.Children = append(node.Children, convert(child))
+			}
+			return node
+		}
+		for _, root := range tree {
+			result.Roots = append(result.Roots, convert(root))
+		}
+		return result
+	}
+
+	// No tree in the report, link the processes through their parent PIDs.  PIDs may be reused, every process gets
+	// its own node.
+	nodes := make([]*Node, len(processes))
+	withPID := map[int][]*Node{}
+	for i, process := range processes {
+		nodes[i] = newNode(process.PID, process.PPID, process.ProcessName, process.CommandLine, process.FirstSeen.Time)
+		nodes[i].Path = process.ProcessPath
+		withPID[process.PID] = append(withPID[process.PID], nodes[i])
+	}
+	parents := make([]*Node, len(nodes))
+	for i, node := range nodes {
+		if parents[i] = parentOf(node, withPID[node.PPID]); parents[i] != nil {
+			parents[i].Children = append(parents[i].Children, node)
+		} else {
+			result.Roots = append(result.Roots, node)
+		}
+	}
+
+	// Processes whose parents lead back to them can't be reached from a root, each cycle is broken at its first
+	// process which becomes a root
+	reached := map[*Node]bool{}
+	var reach func(node *Node)
+	reach = func(node *Node) {
+		if reached[node] {
+			return
+		}
+		reached[node] = true
+		for _, child := range node.Children {
+			reach(child)
+		}
+	}
+	for _, root := range result.Roots {
+		reach(root)
+	}
+	for i, node := range nodes {
+		if reached[node] {
+			continue
+		}
+		parent := parents[i]
+		for j, child := range parent.Children {
+			if child == node {
+				parent.Children = append(parent.Children[:j], parent.Children[j+1:]...)
+				break
+			}
+		}
+		result.Roots = append(result.Roots, node)
+		reach(node)
+	}
+	result.sort()
+
+	return result
+}
+
+// parentOf returns the parent of node among the processes with its parent PID: the last one started before it, or
+// the first one if none was
+func parentOf(node *Node, candidates []*Node) *Node {
+	var first, latest *Node
+	for _, candidate := range candidates {
+		if candidate == node {
+			continue
+		}
+		if first == nil {
+			first = candidate
+		}
+		if !candidate.FirstSeen.After(node.FirstSeen) && (latest == nil || !candidate.FirstSeen.Before(latest.FirstSeen)) {
+			latest = candidate
+		}
+	}
+	if latest != nil {
+		return latest
+	}
+	return first
+}
+
+// sort orders the processes by the time they were first seen
+func (t *Tree) sort() {
+	var sortNodes func(nodes []*Node)
+	sortNodes = func(nodes []*Node) {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			if nodes[i].FirstSeen.Equal(nodes[j].FirstSeen) {
+				return nodes[i].PID < nodes[j].PID
+			}
+			return nodes[i].FirstSeen.Before(nodes[j].FirstSeen)
+		})
+		for _, node := range nodes {
+			sortNodes(node.Children)
+		}
+	}
+	sortNodes(t.Roots)
+}
+
+// Walk calls fn for every process in the tree, parents before their children
+func (t *Tree) Walk(fn func(node *Node, depth int)) {
+	var walk func(nodes []*Node, depth int)
+	walk = func(nodes []*Node, depth int) {
+		for _, node := range nodes {
+			fn(node, depth)
+			walk(node.Children, depth+1)
+		}
+	}
+	walk(t.Roots, 0)
+}
+
+// WriteText writes the tree as indented text, one process per line
+func (t *Tree) WriteText(w io.Writer) error {
+	b := &strings.Builder{}
+	var write func(nodes []*Node, prefix string)
+	write = func(nodes []*Node, prefix string) {
+		for i, node := range nodes {
+			branch, indent := "├── ", "│   "
+			if i == len(nodes)-1 {
+				branch, indent = "└── ", "    "
+			}
+			fmt.Fprintf(b, "%s%s%s\n", prefix, branch, node.label())
+			write(node.Children, prefix+indent)
+		}
+	}
+	for _, root := range t.Roots {
+		fmt.Fprintf(b, "%s\n", root.label())
+		write(root.Children, "")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteDOT writes the tree as a Graphviz DOT digraph.  Nodes are numbered in the order of Walk rather than named
+// after their PID, so processes sharing a PID stay apart.
+func (t *Tree) WriteDOT(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString("digraph processes {\n")
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	ids := map[*Node]int{}
+	t.Walk(func(node *Node, depth int) {
+		ids[node] = len(ids) + 1
+		label := fmt.Sprintf("%s (%d)\n%s\n%d calls", node.Name, node.PID, node.CommandLine, node.APICalls)
+		fmt.Fprintf(b, "\tn%d [label=%s];\n", ids[node], dotString(label))
+	})
+	t.Walk(func(node *Node, depth int) {
+		for _, child := range node.Children {
+			fmt.Fprintf(b, "\tn%d -> n%d;\n", ids[node], ids[child])
+		}
+	})
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the tree as indented JSON
+func (t *Tree) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+// label is the single line description of a process used by the text rendering
+func (n *Node) label() string {
+	label := fmt.Sprintf("%s (%d)", n.Name, n.PID)
+	if n.CommandLine != "" {
+		label += " " + n.CommandLine
+	}
+	if !n.FirstSeen.IsZero() {
+		label += " @ " + n.FirstSeen.UTC().Format("15:04:05.000")
+	}
+	return fmt.Sprintf("%s [%d calls]", label, n.APICalls)
+}
+
+// dotString quotes a DOT string, keeping line breaks
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
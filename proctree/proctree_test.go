@@ -0,0 +1,184 @@
+package proctree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+func checkTree(t *testing.T, tree *Tree) {
+	if len(tree.Roots) != 1 {
+		t.Fatalf("expected 1 root, got %d", len(tree.Roots))
+	}
+	root := tree.Roots[0]
+	if root.PID != 2048 || root.Name != "invoice.exe" || root.APICalls != 4 {
+		t.Errorf("unexpected root %+v", root)
+	}
+	if root.Path != `C:\Users\cuckoo\AppData\Local\Temp\invoice.exe` {
+		t.Errorf("unexpected root path %q", root.Path)
+	}
+	if root.FirstSeen.Unix() != 1581439595 {
+		t.Errorf("unexpected first seen %v", root.FirstSeen)
+	}
+	if len(root.Children) != 1 {
+		t.Fatalf("expected 1 child, got %d", len(root.Children))
+	}
+	child := root.Children[0]
+	if child.PID != 2100 || child.PPID != 2048 || child.CommandLine != "cmd.exe /c del invoice.exe" || child.APICalls != 1 {
+		t.Errorf("unexpected child %+v", child)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	checkTree(t, Build(testutil.LoadReport(t)))
+}
+
+func TestBuildWithoutProcessTree(t *testing.T) {
+	report := testutil.LoadReport(t)
+	report.Behavior.ProcessTree = nil
+	report.Behavior.APIStats = nil
+
+	tree := Build(report)
+	checkTree(t, tree)
+	if tree.Roots[0].APICounts != nil {
+		t.Errorf("expected no api counts without apistats")
+	}
+}
+
+func TestBuildReusedPIDs(t *testing.T) {
+	at := func(seconds int) cuckoo.Timestamp {
+		return cuckoo.Timestamp{Time: time.Unix(int64(1581439595+seconds), 0)}
+	}
+	processes := []*cuckoo.Process{
+		// Parents pointing at each other
+		{PID: 10, PPID: 20, ProcessName: "a.exe", FirstSeen: at(0)},
+		{PID: 20, PPID: 10, ProcessName: "b.exe", FirstSeen: at(1)},
+		// PID 30 reused, the child belongs to the second process
+		{PID: 30, PPID: 1, ProcessName: "first.exe", FirstSeen: at(2)},
+		{PID: 30, PPID: 1, ProcessName: "second.exe", FirstSeen: at(5)},
+		{PID: 40, PPID: 30, ProcessName: "child.exe", FirstSeen: at(6)},
+	}
+
+	tree := build(nil, processes, nil)
+	seen := map[string]int{}
+	lines := []string{}
+	tree.Walk(func(node *Node, depth int) {
+		seen[node.Name]++
+		lines = append(lines, fmt.Sprintf("%d %s", depth, node.Name))
+	})
+	expected := []string{"0 a.exe", "1 b.exe", "0 first.exe", "0 second.exe", "1 child.exe"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+	for name, count := range seen {
+		if count != 1 {
+			t.Errorf("%s appears %d times", name, count)
+		}
+	}
+}
+
+func TestBuildTask(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/report/42/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	})
+
+	tree, err := BuildTask(context.Background(), c, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, tree)
+
+	if !reflect.DeepEqual(tree, Build(testutil.LoadReport(t))) {
+		t.Errorf("streamed tree differs from the parsed report tree")
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := Build(testutil.LoadReport(t)).WriteText(b); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", b.String())
+	}
+	if !strings.HasPrefix(lines[0], "invoice.exe (2048) ") || !strings.HasSuffix(lines[0], "[4 calls]") {
+		t.Errorf("unexpected root line %q", lines[0])
+	}
+	if lines[1] != "└── cmd.exe (2100) cmd.exe /c del invoice.exe @ 16:46:44.000 [1 calls]" {
+		t.Errorf("unexpected child line %q", lines[1])
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := Build(testutil.LoadReport(t)).WriteDOT(b); err != nil {
+		t.Fatal(err)
+	}
+
+	dot := b.String()
+	if !strings.HasPrefix(dot, "digraph processes {") || !strings.HasSuffix(dot, "}\n") {
+		t.Errorf("not a digraph: %s", dot)
+	}
+	if !strings.Contains(dot, "\tn1 [label=\"invoice.exe (2048)") || !strings.Contains(dot, "\tn1 -> n2;\n") {
+		t.Errorf("missing edge: %s", dot)
+	}
+	// Quotes and backslashes of the command line must be escaped
+	if !strings.Contains(dot, `\"C:\\Users\\cuckoo\\AppData\\Local\\Temp\\invoice.exe\"`) {
+		t.Errorf("command line not escaped: %s", dot)
+	}
+}
+
+func TestWriteDOTReusedPIDs(t *testing.T) {
+	processes := []*cuckoo.Process{
+		{PID: 30, PPID: 1, ProcessName: "first.exe", FirstSeen: cuckoo.Timestamp{Time: time.Unix(1581439595, 0)}},
+		{PID: 30, PPID: 1, ProcessName: "second.exe", FirstSeen: cuckoo.Timestamp{Time: time.Unix(1581439600, 0)}},
+		{PID: 40, PPID: 30, ProcessName: "child.exe", FirstSeen: cuckoo.Timestamp{Time: time.Unix(1581439601, 0)}},
+	}
+	b := &bytes.Buffer{}
+	if err := build(nil, processes, nil).WriteDOT(b); err != nil {
+		t.Fatal(err)
+	}
+
+	dot := b.String()
+	for _, line := range []string{"\tn1 [label=\"first.exe (30)", "\tn2 [label=\"second.exe (30)", "\tn3 [label=\"child.exe (40)", "\tn2 -> n3;\n"} {
+		if !strings.Contains(dot, line) {
+			t.Errorf("missing %q: %s", line, dot)
+		}
+	}
+	if strings.Count(dot, "->") != 1 {
+		t.Errorf("expected a single edge: %s", dot)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := Build(testutil.LoadReport(t)).WriteJSON(b); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &Tree{}
+	if err := json.Unmarshal(b.Bytes(), decoded); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, decoded)
+}
@@ -0,0 +1,853 @@
+package cuckoo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report is the JSON report cuckoo 2.0.x generates for a task.
+//
+// Every section keeps the keys it does not know about in Extra, so nothing is lost when cuckoo
+// or one of its modules adds fields.  Sections that were not generated (e.g. static for a URL) are nil.
+type Report struct {
+	Info        *ReportInfo    `json:"info,omitempty"`
+	Target      *ReportTarget  `json:"target,omitempty"`
+	Signatures  []*Signature   `json:"signatures,omitempty"`
+	Behavior    *Behavior      `json:"behavior,omitempty"`
+	Network     *Network       `json:"network,omitempty"`
+	Static      *Static        `json:"static,omitempty"`
+	Dropped     []*DroppedFile `json:"dropped,omitempty"`
+	ProcMemory  []*ProcMemory  `json:"procmemory,omitempty"`
+	Debug       *Debug         `json:"debug,omitempty"`
+	Screenshots []*Screenshot  `json:"screenshots,omitempty"`
+	Strings     []string       `json:"strings,omitempty"`
+
+	// Sections not modelled above (e.g. virustotal, suricata, memory)
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ReportInfo is the info section of a report
+type ReportInfo struct {
+	ID       int          `json:"id"`
+	Category string       `json:"category"`
+	Package  string       `json:"package"`
+	Platform string       `json:"platform"`
+	Options  interface{}  `json:"options"`
+	Custom   interface{}  `json:"custom"`
+	Owner    interface{}  `json:"owner"`
+	Route    interface{}  `json:"route"`
+	Score    float64      `json:"score"`
+	Version  string       `json:"version"`
+	Monitor  string       `json:"monitor"`
+	Added    Timestamp    `json:"added"`
+	Started  Timestamp    `json:"started"`
+	Ended    Timestamp    `json:"ended"`
+	Duration int64        `json:"duration"`
+	Machine  *InfoMachine `json:"machine,omitempty"`
+	Git      *InfoGit     `json:"git,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// InfoMachine is the analysis machine the task ran on
+type InfoMachine struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Label      string `json:"label"`
+	Manager    string `json:"manager"`
+	Status     string `json:"status"`
+	StartedOn  string `json:"started_on"`
+	ShutdownOn string `json:"shutdown_on"`
+}
+
+// InfoGit is the git revision of cuckoo that generated the report
+type InfoGit struct {
+	Head      string `json:"head"`
+	FetchHead string `json:"fetch_head"`
+}
+
+// ReportTarget is the target section of a report.  File is set for file tasks and URL for URL tasks.
+type ReportTarget struct {
+	Category string      `json:"category"`
+	File     *ReportFile `json:"file,omitempty"`
+	URL      string      `json:"url,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ReportFile is a file described in a report, such as the target or a dropped file
+type ReportFile struct {
+	Name   string       `json:"name"`
+	Path   string       `json:"path"`
+	Size   int64        `json:"size"`
+	Type   string       `json:"type"`
+	MD5    string       `json:"md5"`
+	SHA1   string       `json:"sha1"`
+	SHA256 string       `json:"sha256"`
+	SHA512 string       `json:"sha512"`
+	CRC32  string       `json:"crc32"`
+	Ssdeep string       `json:"ssdeep"`
+	Yara   []*YaraMatch `json:"yara"`
+	URLs   []string     `json:"urls"`
+}
+
+// YaraMatch is a yara rule that matched a file or memory region
+type YaraMatch struct {
+	Name    string                 `json:"name"`
+	Meta    map[string]interface{} `json:"meta"`
+	Offsets map[string]interface{} `json:"offsets"`
+	Strings []string               `json:"strings"`
+}
+
+// Signature is a cuckoo signature that matched during the analysis
+type Signature struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Severity    int           `json:"severity"`
+	Families    []string      `json:"families"`
+	References  []string      `json:"references"`
+	TTP         SignatureTTPs `json:"ttp,omitempty"`
+	MarkCount   int           `json:"markcount"`
+	Marks       []*Mark       `json:"marks"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// SignatureTTPs are the ATT&CK techniques of a signature keyed by technique ID
+type SignatureTTPs map[string]*TTP
+
+// TTP is the description cuckoo ships for an ATT&CK technique
+type TTP struct {
+	Short string `json:"short"`
+	Long  string `json:"long"`
+}
+
+// UnmarshalJSON accepts both the {"T1055": {"short": ...}} form and a plain list of technique IDs
+func (t *SignatureTTPs) UnmarshalJSON(data []byte) error {
+	ids := []string{}
+	if err := json.Unmarshal(data, &ids); err == nil {
+		*t = SignatureTTPs{}
+		for _, id := range ids {
+			(*t)[id] = &TTP{}
+		}
+		return nil
+	}
+
+	ttps := map[string]*TTP{}
+	if err := json.Unmarshal(data, &ttps); err != nil {
+		return err
+	}
+	*t = ttps
+	return nil
+}
+
+// Mark is a piece of evidence for a signature.
+//
+// Type says which fields are set: "call" marks have Call, "ioc" marks have Category and IOC,
+// and "generic" marks keep their custom keys in Extra.
+type Mark struct {
+	Type        string      `json:"type"`
+	PID         int         `json:"pid,omitempty"`
+	CID         int         `json:"cid,omitempty"`
+	Call        *Call       `json:"call,omitempty"`
+	Category    string      `json:"category,omitempty"`
+	IOC         interface{} `json:"ioc,omitempty"`
+	Description string      `json:"description,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Behavior is the behavior section of a report
+type Behavior struct {
+	Generic     []*GenericProcess         `json:"generic"`
+	APIStats    map[string]map[string]int `json:"apistats"`
+	Processes   []*Process                `json:"processes"`
+	ProcessTree []*ProcessTreeNode        `json:"processtree"`
+	Summary     *BehaviorSummary          `json:"summary"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// GenericProcess is the per process summary of the behavior section
+type GenericProcess struct {
+	PID         int              `json:"pid"`
+	PPID        int              `json:"ppid"`
+	ProcessName string           `json:"process_name"`
+	ProcessPath string           `json:"process_path"`
+	FirstSeen   Timestamp        `json:"first_seen"`
+	Summary     *BehaviorSummary `json:"summary"`
+}
+
+// Process is a monitored process along with the API calls it made
+type Process struct {
+	PID         int       `json:"pid"`
+	PPID        int       `json:"ppid"`
+	TID         int       `json:"tid"`
+	ProcessName string    `json:"process_name"`
+	ProcessPath string    `json:"process_path"`
+	CommandLine string    `json:"command_line"`
+	FirstSeen   Timestamp `json:"first_seen"`
+	Track       bool      `json:"track"`
+	Type        string    `json:"type"`
+	Modules     []*Module `json:"modules"`
+	Calls       []*Call   `json:"calls"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Module is a module loaded in a process
+type Module struct {
+	Basename string `json:"basename"`
+	Filepath string `json:"filepath"`
+	BaseAddr string `json:"baseaddr"`
+	ImgSize  int64  `json:"imgsize"`
+}
+
+// Call is a single API call made by a process
+type Call struct {
+	API         string                 `json:"api"`
+	Category    string                 `json:"category"`
+	Status      CallStatus             `json:"status"`
+	ReturnValue interface{}            `json:"return_value"`
+	Arguments   map[string]interface{} `json:"arguments"`
+	Flags       map[string]interface{} `json:"flags"`
+	Time        float64                `json:"time"`
+	TID         int                    `json:"tid"`
+	Stacktrace  []string               `json:"stacktrace"`
+}
+
+// CallStatus is whether an API call succeeded.  Cuckoo writes it as either a bool or 0/1.
+type CallStatus bool
+
+// UnmarshalJSON accepts a bool or a number
+func (s *CallStatus) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true":
+		*s = true
+	case "false", "null", "0":
+		*s = false
+	default:
+		if _, err := strconv.ParseFloat(string(data), 64); err != nil {
+			return fmt.Errorf("cuckoo: unknown call status %s", data)
+		}
+		*s = true
+	}
+	return nil
+}
+
+// ProcessTreeNode is a process in the process tree of the behavior section
+type ProcessTreeNode struct {
+	PID         int                `json:"pid"`
+	PPID        int                `json:"ppid"`
+	ProcessName string             `json:"process_name"`
+	CommandLine string             `json:"command_line"`
+	FirstSeen   Timestamp          `json:"first_seen"`
+	Track       bool               `json:"track"`
+	Children    []*ProcessTreeNode `json:"children"`
+}
+
+// BehaviorSummary is the summary of everything the monitored processes did
+type BehaviorSummary struct {
+	FileCreated      []string   `json:"file_created,omitempty"`
+	FileRecreated    []string   `json:"file_recreated,omitempty"`
+	FileOpened       []string   `json:"file_opened,omitempty"`
+	FileRead         []string   `json:"file_read,omitempty"`
+	FileWritten      []string   `json:"file_written,omitempty"`
+	FileDeleted      []string   `json:"file_deleted,omitempty"`
+	FileExists       []string   `json:"file_exists,omitempty"`
+	FileFailed       []string   `json:"file_failed,omitempty"`
+	FileCopied       [][]string `json:"file_copied,omitempty"`
+	FileMoved        [][]string `json:"file_moved,omitempty"`
+	DirectoryCreated []string   `json:"directory_created,omitempty"`
+	DirectoryRemoved []string   `json:"directory_removed,omitempty"`
+	DirectoryEnum    []string   `json:"directory_enumerated,omitempty"`
+	DLLLoaded        []string   `json:"dll_loaded,omitempty"`
+	RegkeyOpened     []string   `json:"regkey_opened,omitempty"`
+	RegkeyRead       []string   `json:"regkey_read,omitempty"`
+	RegkeyWritten    []string   `json:"regkey_written,omitempty"`
+	RegkeyDeleted    []string   `json:"regkey_deleted,omitempty"`
+	Mutex            []string   `json:"mutex,omitempty"`
+	CommandLine      []string   `json:"command_line,omitempty"`
+	GUID             []string   `json:"guid,omitempty"`
+	ConnectsHost     []string   `json:"connects_host,omitempty"`
+	ConnectsIP       []string   `json:"connects_ip,omitempty"`
+	ResolvesHost     []string   `json:"resolves_host,omitempty"`
+	FetchesURL       []string   `json:"fetches_url,omitempty"`
+	DownloadsFile    []string   `json:"downloads_file,omitempty"`
+	WMIQuery         []string   `json:"wmi_query,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Network is the network section of a report
+type Network struct {
+	PcapSHA256       string          `json:"pcap_sha256"`
+	SortedPcapSHA256 string          `json:"sorted_pcap_sha256"`
+	Hosts            []NetworkHost   `json:"hosts"`
+	Domains          []*Domain       `json:"domains"`
+	DNS              []*DNSRequest   `json:"dns"`
+	HTTP             []*HTTPRequest  `json:"http"`
+	HTTPEx           []*HTTPEx       `json:"http_ex"`
+	HTTPSEx          []*HTTPEx       `json:"https_ex"`
+	TCP              []*Connection   `json:"tcp"`
+	UDP              []*Connection   `json:"udp"`
+	ICMP             []*ICMP         `json:"icmp"`
+	SMTP             []*SMTP         `json:"smtp"`
+	IRC              []*IRC          `json:"irc"`
+	TLS              []*TLS          `json:"tls"`
+	DeadHosts        [][]interface{} `json:"dead_hosts"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// NetworkHost is a host contacted during the analysis.
+//
+// Cuckoo 2.0 lists hosts as plain IP strings, other versions use objects.  Both are accepted.
+type NetworkHost struct {
+	IP          string `json:"ip"`
+	CountryName string `json:"country_name,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+	InAddrArpa  string `json:"inaddrarpa,omitempty"`
+}
+
+// UnmarshalJSON accepts either a plain IP string or a host object
+func (h *NetworkHost) UnmarshalJSON(data []byte) error {
+	ip := ""
+	if err := json.Unmarshal(data, &ip); err == nil {
+		*h = NetworkHost{IP: ip}
+		return nil
+	}
+
+	type host NetworkHost
+	return json.Unmarshal(data, (*host)(h))
+}
+
+// Domain is a domain resolved during the analysis
+type Domain struct {
+	Domain string `json:"domain"`
+	IP     string `json:"ip"`
+}
+
+// DNSRequest is a DNS request and its answers
+type DNSRequest struct {
+	Request string       `json:"request"`
+	Type    string       `json:"type"`
+	Answers []*DNSAnswer `json:"answers"`
+}
+
+// DNSAnswer is a single answer to a DNS request
+type DNSAnswer struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// HTTPRequest is an HTTP request seen in the network traffic
+type HTTPRequest struct {
+	Count     int    `json:"count"`
+	Method    string `json:"method"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Path      string `json:"path"`
+	URI       string `json:"uri"`
+	Version   string `json:"version"`
+	UserAgent string `json:"user-agent"`
+	Body      string `json:"body"`
+	Data      string `json:"data"`
+}
+
+// HTTPEx is an HTTP(S) request and response as extracted by the extended network processing
+type HTTPEx struct {
+	Src      string                 `json:"src"`
+	Sport    int                    `json:"sport"`
+	Dst      string                 `json:"dst"`
+	Dport    int                    `json:"dport"`
+	Protocol string                 `json:"protocol"`
+	Method   string                 `json:"method"`
+	Host     string                 `json:"host"`
+	URI      string                 `json:"uri"`
+	Path     string                 `json:"path"`
+	Status   int                    `json:"status"`
+	Request  string                 `json:"request"`
+	Response string                 `json:"response"`
+	Req      map[string]interface{} `json:"req"`
+	Resp     map[string]interface{} `json:"resp"`
+}
+
+// Connection is a TCP or UDP connection
+type Connection struct {
+	Src    string  `json:"src"`
+	Sport  int     `json:"sport"`
+	Dst    string  `json:"dst"`
+	Dport  int     `json:"dport"`
+	Offset int64   `json:"offset"`
+	Time   float64 `json:"time"`
+}
+
+// ICMP is an ICMP packet
+type ICMP struct {
+	Src  string `json:"src"`
+	Dst  string `json:"dst"`
+	Type int    `json:"type"`
+	Data string `json:"data"`
+}
+
+// SMTP is an SMTP conversation
+type SMTP struct {
+	Dst string `json:"dst"`
+	Raw string `json:"raw"`
+}
+
+// IRC is an IRC message
+type IRC struct {
+	Command string `json:"command"`
+	Params  string `json:"params"`
+	Type    string `json:"type"`
+}
+
+// TLS is a TLS session seen in the network traffic
+type TLS struct {
+	SrcIP        string `json:"srcip"`
+	SrcPort      int    `json:"srcport"`
+	DstIP        string `json:"dstip"`
+	DstPort      int    `json:"dstport"`
+	ClientRandom string `json:"client_random"`
+	ServerRandom string `json:"server_random"`
+	SessionID    string `json:"session_id"`
+}
+
+// Static is the static analysis section of a report.  Only PE files are modelled, other file types end up in Extra.
+type Static struct {
+	PEImphash        string           `json:"pe_imphash"`
+	PETimestamp      string           `json:"pe_timestamp"`
+	PDBPath          string           `json:"pdb_path"`
+	PEIDSignatures   []string         `json:"peid_signatures"`
+	PEImports        []*PEImport      `json:"pe_imports"`
+	PEExports        []*PEExport      `json:"pe_exports"`
+	PESections       []*PESection     `json:"pe_sections"`
+	PEResources      []*PEResource    `json:"pe_resources"`
+	PEVersionInfo    []*PEVersionInfo `json:"pe_versioninfo"`
+	ImportedDLLCount int              `json:"imported_dll_count"`
+	Signature        []interface{}    `json:"signature"`
+	Keys             []string         `json:"keys"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// PEImport is a DLL imported by a PE file
+type PEImport struct {
+	DLL     string      `json:"dll"`
+	Imports []*PESymbol `json:"imports"`
+}
+
+// PESymbol is an imported symbol
+type PESymbol struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// PEExport is an exported symbol
+type PEExport struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Ordinal int    `json:"ordinal"`
+}
+
+// PESection is a section of a PE file
+type PESection struct {
+	Name           string  `json:"name"`
+	VirtualAddress string  `json:"virtual_address"`
+	VirtualSize    string  `json:"virtual_size"`
+	SizeOfData     string  `json:"size_of_data"`
+	Entropy        float64 `json:"entropy"`
+}
+
+// PEResource is a resource of a PE file
+type PEResource struct {
+	Name        string `json:"name"`
+	Offset      string `json:"offset"`
+	Size        string `json:"size"`
+	Filetype    string `json:"filetype"`
+	Language    string `json:"language"`
+	Sublanguage string `json:"sublanguage"`
+}
+
+// PEVersionInfo is an entry of the version information of a PE file
+type PEVersionInfo struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DroppedFile is a file dropped by the sample
+type DroppedFile struct {
+	ReportFile
+	// Path of the file on the analysis machine
+	Filepath string `json:"filepath"`
+	// Processes that wrote the file
+	PIDs []int `json:"pids"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ProcMemory is a process memory dump taken during the analysis
+type ProcMemory struct {
+	PID       int             `json:"pid"`
+	File      string          `json:"file"`
+	Yara      []*YaraMatch    `json:"yara"`
+	URLs      []string        `json:"urls"`
+	Regions   []*MemoryRegion `json:"regions"`
+	Extracted []interface{}   `json:"extracted"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// MemoryRegion is a memory region of a dumped process
+type MemoryRegion struct {
+	Addr    string      `json:"addr"`
+	End     string      `json:"end"`
+	Size    int64       `json:"size"`
+	Protect interface{} `json:"protect"`
+	State   interface{} `json:"state"`
+	Type    interface{} `json:"type"`
+}
+
+// Debug is the debug section of a report
+type Debug struct {
+	Errors  []string `json:"errors"`
+	Log     Lines    `json:"log"`
+	Cuckoo  Lines    `json:"cuckoo"`
+	Action  []string `json:"action"`
+	DbgView []string `json:"dbgview"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Screenshot is a screenshot taken during the analysis
+type Screenshot struct {
+	Path  string `json:"path"`
+	AHash string `json:"ahash"`
+}
+
+// Lines is a log that cuckoo stores either as one string or as a list of lines
+type Lines []string
+
+// UnmarshalJSON accepts either a string or a list of strings
+func (l *Lines) UnmarshalJSON(data []byte) error {
+	text := ""
+	if err := json.Unmarshal(data, &text); err == nil {
+		*l = strings.Split(strings.TrimRight(text, "\n"), "\n")
+		return nil
+	}
+
+	lines := []string{}
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return err
+	}
+	*l = lines
+	return nil
+}
+
+// Timestamp is a time in a report.  Cuckoo writes these as unix epoch floats or as "2006-01-02 15:04:05" strings.
+type Timestamp struct {
+	time.Time
+}
+
+// timestampFormats are the string layouts cuckoo uses for times
+var timestampFormats = []string{
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02T15:04:05.999999",
+	time.RFC3339Nano,
+}
+
+// UnmarshalJSON parses an epoch number, a date string or null
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "null" || text == "" || text == "none" {
+		*t = Timestamp{}
+		return nil
+	}
+
+	if epoch, err := strconv.ParseFloat(text, 64); err == nil {
+		seconds := int64(epoch)
+		*t = Timestamp{time.Unix(seconds, int64((epoch-float64(seconds))*1e9)).UTC()}
+		return nil
+	}
+
+	for _, format := range timestampFormats {
+		if parsed, err := time.Parse(format, text); err == nil {
+			*t = Timestamp{parsed}
+			return nil
+		}
+	}
+	return fmt.Errorf("cuckoo: unknown timestamp %s", data)
+}
+
+// MarshalJSON writes the time as a unix epoch float, or null if it is not set
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)), nil
+}
+
+// The sections below keep unknown keys in Extra.  The local types drop the methods so the default
+// encoding can be used for the known fields.
+
+// UnmarshalJSON decodes the report keeping unknown sections in Extra
+func (r *Report) UnmarshalJSON(data []byte) (err error) {
+	type report Report
+	r.Extra, err = unmarshalExtra(data, (*report)(r))
+	return err
+}
+
+// MarshalJSON encodes the report including the sections in Extra
+func (r *Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	return marshalExtra((*report)(r), r.Extra)
+}
+
+// UnmarshalJSON decodes the section keeping unknown keys in Extra
+func (i *ReportInfo) UnmarshalJSON(data []byte) (err error) {
+	type info ReportInfo
+	i.Extra, err = unmarshalExtra(data, (*info)(i))
+	return err
+}
+
+// MarshalJSON encodes the section including the keys in Extra
+func (i *ReportInfo) MarshalJSON() ([]byte, error) {
+	type info ReportInfo
+	return marshalExtra((*info)(i), i.Extra)
+}
+
+// UnmarshalJSON decodes the section keeping unknown keys in Extra
+func (t *ReportTarget) UnmarshalJSON(data []byte) (err error) {
+	type target ReportTarget
+	t.Extra, err = unmarshalExtra(data, (*target)(t))
+	return err
+}
+
+// MarshalJSON encodes the section including the keys in Extra
+func (t *ReportTarget) MarshalJSON() ([]byte, error) {
+	type target ReportTarget
+	return marshalExtra((*target)(t), t.Extra)
+}
+
+// UnmarshalJSON decodes the signature keeping unknown keys in Extra
+func (s *Signature) UnmarshalJSON(data []byte) (err error) {
+	type signature Signature
+	s.Extra, err = unmarshalExtra(data, (*signature)(s))
+	return err
+}
+
+// MarshalJSON encodes the signature including the keys in Extra
+func (s *Signature) MarshalJSON() ([]byte, error) {
+	type signature Signature
+	return marshalExtra((*signature)(s), s.Extra)
+}
+
+// UnmarshalJSON decodes the mark keeping unknown keys in Extra
+func (m *Mark) UnmarshalJSON(data []byte) (err error) {
+	type mark Mark
+	m.Extra, err = unmarshalExtra(data, (*mark)(m))
+	return err
+}
+
+// MarshalJSON encodes the mark including the keys in Extra
+func (m *Mark) MarshalJSON() ([]byte, error) {
+	type mark Mark
+	return marshalExtra((*mark)(m), m.Extra)
+}
+
+// UnmarshalJSON decodes the section keeping unknown keys in Extra
+func (b *Behavior) UnmarshalJSON(data []byte) (err error) {
+	type behavior Behavior
+	b.Extra, err = unmarshalExtra(data, (*behavior)(b))
+	return err
+}
+
+// MarshalJSON encodes the section including the keys in Extra
+func (b *Behavior) MarshalJSON() ([]byte, error) {
+	type behavior Behavior
+	return marshalExtra((*behavior)(b), b.Extra)
+}
+
+// UnmarshalJSON decodes the process keeping unknown keys in Extra
+func (p *Process) UnmarshalJSON(data []byte) (err error) {
+	type process Process
+	p.Extra, err = unmarshalExtra(data, (*process)(p))
+	return err
+}
+
+// MarshalJSON encodes the process including the keys in Extra
+func (p *Process) MarshalJSON() ([]byte, error) {
+	type process Process
+	return marshalExtra((*process)(p), p.Extra)
+}
+
+// UnmarshalJSON decodes the summary keeping unknown keys in Extra
+func (s *BehaviorSummary) UnmarshalJSON(data []byte) (err error) {
+	type summary BehaviorSummary
+	s.Extra, err = unmarshalExtra(data, (*summary)(s))
+	return err
+}
+
+// MarshalJSON encodes the summary including the keys in Extra
+func (s *BehaviorSummary) MarshalJSON() ([]byte, error) {
+	type summary BehaviorSummary
+	return marshalExtra((*summary)(s), s.Extra)
+}
+
+// UnmarshalJSON decodes the section keeping unknown keys in Extra
+func (n *Network) UnmarshalJSON(data []byte) (err error) {
+	type network Network
+	n.Extra, err = unmarshalExtra(data, (*network)(n))
+	return err
+}
+
+// MarshalJSON encodes the section including the keys in Extra
+func (n *Network) MarshalJSON() ([]byte, error) {
+	type network Network
+	return marshalExtra((*network)(n), n.Extra)
+}
+
+// UnmarshalJSON decodes the section keeping unknown keys in Extra
+func (s *Static) UnmarshalJSON(data []byte) (err error) {
+	type static Static
+	s.Extra, err = unmarshalExtra(data, (*static)(s))
+	return err
+}
+
+// MarshalJSON encodes the section including the keys in Extra
+func (s *Static) MarshalJSON() ([]byte, error) {
+	type static Static
+	return marshalExtra((*static)(s), s.Extra)
+}
+
+// UnmarshalJSON decodes the dropped file keeping unknown keys in Extra
+func (d *DroppedFile) UnmarshalJSON(data []byte) (err error) {
+	type dropped DroppedFile
+	d.Extra, err = unmarshalExtra(data, (*dropped)(d))
+	return err
+}
+
+// MarshalJSON encodes the dropped file including the keys in Extra
+func (d *DroppedFile) MarshalJSON() ([]byte, error) {
+	type dropped DroppedFile
+	return marshalExtra((*dropped)(d), d.Extra)
+}
+
+// UnmarshalJSON decodes the memory dump keeping unknown keys in Extra
+func (p *ProcMemory) UnmarshalJSON(data []byte) (err error) {
+	type procMemory ProcMemory
+	p.Extra, err = unmarshalExtra(data, (*procMemory)(p))
+	return err
+}
+
+// MarshalJSON encodes the memory dump including the keys in Extra
+func (p *ProcMemory) MarshalJSON() ([]byte, error) {
+	type procMemory ProcMemory
+	return marshalExtra((*procMemory)(p), p.Extra)
+}
+
+// UnmarshalJSON decodes the section keeping unknown keys in Extra
+func (d *Debug) UnmarshalJSON(data []byte) (err error) {
+	type debug Debug
+	d.Extra, err = unmarshalExtra(data, (*debug)(d))
+	return err
+}
+
+// MarshalJSON encodes the section including the keys in Extra
+func (d *Debug) MarshalJSON() ([]byte, error) {
+	type debug Debug
+	return marshalExtra((*debug)(d), d.Extra)
+}
+
+// unmarshalExtra decodes data into v and returns the keys v has no field for
+func unmarshalExtra(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	known := jsonFieldNames(reflect.TypeOf(v).Elem())
+	for key := range all {
+		// encoding/json matches keys case insensitively
+		if known[strings.ToLower(key)] {
+			delete(all, key)
+		}
+	}
+
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, nil
+}
+
+// marshalExtra encodes v and adds the keys in extra that v does not already set
+func marshalExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := merged[key]; !ok {
+			merged[key] = value
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// jsonFieldNames returns the lower cased JSON names of the fields of t, including embedded structs
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			for name := range jsonFieldNames(field.Type) {
+				names[name] = true
+			}
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return names
+}
+
+// TasksReportParsed Returns the report associated with the specified task ID decoded into a Report.
+//
+// Reports can be very large, see TasksReport to work with the raw JSON instead.
+func (c *Client) TasksReportParsed(ctx context.Context, taskID int) (*Report, error) {
+	body, err := c.TasksReport(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	report := &Report{}
+	if err := json.NewDecoder(body).Decode(report); err != nil {
+		return nil, fmt.Errorf("cuckoo: report response marshalling error: %w", err)
+	}
+
+	return report, nil
+}
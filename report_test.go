@@ -0,0 +1,113 @@
+package cuckoo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func loadTestReport(t *testing.T) *Report {
+	data, err := ioutil.ReadFile("testdata/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := &Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestReportDecode(t *testing.T) {
+	report := loadTestReport(t)
+
+	if report.Info.ID != 42 || report.Info.Score != 6.4 || report.Info.Machine.Name != "win7-1" {
+		t.Errorf("info not decoded: %+v", report.Info)
+	}
+	if report.Info.Started.Unix() != 1581439592 {
+		t.Errorf("unexpected start time %s", report.Info.Started)
+	}
+	if report.Target.File.SHA256 == "" || report.Target.File.Yara[0].Name != "UPX" {
+		t.Errorf("target not decoded: %+v", report.Target.File)
+	}
+
+	if len(report.Signatures) != 3 {
+		t.Fatalf("expected 3 signatures, got %d", len(report.Signatures))
+	}
+	if report.Signatures[0].TTP["T1055"].Short != "Process Injection" || report.Signatures[0].Marks[0].Call.API != "CreateRemoteThread" {
+		t.Errorf("signature not decoded: %+v", report.Signatures[0])
+	}
+	if _, ok := report.Signatures[2].TTP["T1071"]; !ok {
+		t.Errorf("ttp list not decoded: %+v", report.Signatures[2].TTP)
+	}
+	if string(report.Signatures[2].Marks[0].Extra["request"]) != `"GET http://evil.example.com/gate.php"` {
+		t.Errorf("generic mark keys not kept: %v", report.Signatures[2].Marks[0].Extra)
+	}
+
+	if len(report.Behavior.Processes) != 2 || len(report.Behavior.Processes[0].Calls) != 4 || !bool(report.Behavior.Processes[0].Calls[2].Status) {
+		t.Errorf("processes not decoded")
+	}
+	if report.Behavior.ProcessTree[0].Children[0].PID != 2100 {
+		t.Errorf("process tree not decoded")
+	}
+	if report.Behavior.Summary.FileCopied[0][1] == "" || report.Behavior.Summary.Extra["some_new_key"] == nil {
+		t.Errorf("summary not decoded: %+v", report.Behavior.Summary)
+	}
+
+	if report.Network.Hosts[0].IP != "185.100.87.202" || report.Network.HTTP[0].UserAgent == "" {
+		t.Errorf("network not decoded: %+v", report.Network)
+	}
+	if report.Static.PEImphash == "" || report.Dropped[0].SHA256 == "" || report.Dropped[0].Filepath == "" {
+		t.Errorf("static or dropped not decoded")
+	}
+	if report.ProcMemory[0].PID != 2048 || len(report.Debug.Log) != 2 || len(report.Debug.Cuckoo) != 1 {
+		t.Errorf("procmemory or debug not decoded")
+	}
+
+	// Unknown sections and keys should be kept
+	if report.Extra["virustotal"] == nil || report.Info.Extra["analysis_path"] == nil {
+		t.Errorf("unknown fields were dropped")
+	}
+	if report.Dropped[0].Extra != nil {
+		t.Errorf("embedded fields ended up in extra: %v", report.Dropped[0].Extra)
+	}
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	report := loadTestReport(t)
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Report{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Extra["virustotal"] == nil || decoded.Info.Extra["analysis_path"] == nil {
+		t.Errorf("extra fields were not encoded")
+	}
+	if decoded.Info.Ended.Sub(report.Info.Ended.Time).Round(time.Millisecond) != 0 || decoded.Dropped[1].Name != "config.bin" {
+		t.Errorf("report did not survive a round trip")
+	}
+}
+
+func TestTasksReportParsed(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/report.json")
+	})
+
+	report, err := c.TasksReportParsed(context.Background(), 42)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.Info.ID != 42 {
+		t.Errorf("unexpected report %+v", report.Info)
+	}
+}
@@ -0,0 +1,232 @@
+// Package reportdiff compares the cuckoo reports of two tasks, e.g. a sample reanalyzed on a new machine image
+package reportdiff
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+// Diff is what changed between an old and a new report
+type Diff struct {
+	OldTaskID   int     `json:"old_task_id"`
+	NewTaskID   int     `json:"new_task_id"`
+	OldScore    float64 `json:"old_score"`
+	NewScore    float64 `json:"new_score"`
+	ScoreChange float64 `json:"score_change"`
+
+	Signatures   *Change     `json:"signatures"`
+	IPs          *Change     `json:"ips"`
+	Domains      *Change     `json:"domains"`
+	URLs         *Change     `json:"urls"`
+	DroppedFiles *FileChange `json:"dropped_files"`
+	Processes    *Change     `json:"processes"`
+}
+
+// Change lists the values only found in the old or the new report.  Both lists are sorted.
+type Change struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// FileChange lists the dropped files, compared by SHA256, only found in the old or the new report
+type FileChange struct {
+	Added   []*ioc.File `json:"added"`
+	Removed []*ioc.File `json:"removed"`
+}
+
+// Options control the comparison
+type Options struct {
+	// Options used to extract the network destinations and dropped files of both reports, see ioc.Extract
+	IOC *ioc.Options
+}
+
+// Compare Returns what changed from the old report to the new one.  opts may be nil.
+func Compare(old, new *cuckoo.Report, opts *Options) *Diff {
+	if opts == nil {
+		opts = &Options{}
+	}
+	oldSet, newSet := ioc.Extract(old, opts.IOC), ioc.Extract(new, opts.IOC)
+
+	diff := &Diff{
+		Signatures:   compare(signatureNames(old), signatureNames(new)),
+		IPs:          compare(oldSet.IPs, newSet.IPs),
+		Domains:      compare(oldSet.Domains, newSet.Domains),
+		URLs:         compare(oldSet.URLs, newSet.URLs),
+		DroppedFiles: compareFiles(oldSet.DroppedFiles, newSet.DroppedFiles),
+		Processes:    compare(processes(oldSet), processes(newSet)),
+	}
+	if old.Info != nil {
+		diff.OldTaskID = old.Info.ID
+		diff.OldScore = old.Info.Score
+	}
+	if new.Info != nil {
+		diff.NewTaskID = new.Info.ID
+		diff.NewScore = new.Info.Score
+	}
+	diff.ScoreChange = diff.NewScore - diff.OldScore
+
+	return diff
+}
+
+// CompareTasks Fetches the reports of both tasks with TasksReportParsed and compares them, see Compare.  The
+// indicators leave out the machines of the tasks, see ioc.WithReportMachine.
+func CompareTasks(ctx context.Context, c *cuckoo.Client, oldTaskID, newTaskID int, opts *Options) (*Diff, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	reports := []*cuckoo.Report{}
+	iocOptions := opts.IOC
+	for _, taskID := range []int{oldTaskID, newTaskID} {
+		report, err := c.TasksReportParsed(ctx, taskID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting report for task %d: %w", taskID, err)
+		}
+		iocOptions, err = ioc.WithReportMachine(ctx, c, report, iocOptions)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return Compare(reports[0], reports[1], &Options{IOC: iocOptions}), nil
+}
+
+// Empty returns true if nothing but possibly the task IDs changed
+func (d *Diff) Empty() bool {
+	return d.ScoreChange == 0 && d.Signatures.empty() && d.IPs.empty() && d.Domains.empty() && d.URLs.empty() &&
+		len(d.DroppedFiles.Added) == 0 && len(d.DroppedFiles.Removed) == 0 && d.Processes.empty()
+}
+
+// WriteText writes a readable summary of the diff
+func (d *Diff) WriteText(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Task %d -> task %d\n", d.OldTaskID, d.NewTaskID)
+	fmt.Fprintf(b, "Score: %.1f -> %.1f (%+.1f)\n", d.OldScore, d.NewScore, d.ScoreChange)
+	if d.Empty() {
+		b.WriteString("No changes\n")
+	}
+
+	d.Signatures.writeText(b, "Signatures")
+	d.IPs.writeText(b, "IPs")
+	d.Domains.writeText(b, "Domains")
+	d.URLs.writeText(b, "URLs")
+	if len(d.DroppedFiles.Added) > 0 || len(d.DroppedFiles.Removed) > 0 {
+		b.WriteString("Dropped files:\n")
+		for _, file := range d.DroppedFiles.Added {
+			fmt.Fprintf(b, "  + %s %s\n", file.SHA256, file.Path)
+		}
+		for _, file := range d.DroppedFiles.Removed {
+			fmt.Fprintf(b, "  - %s %s\n", file.SHA256, file.Path)
+		}
+	}
+	d.Processes.writeText(b, "Processes")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// String returns the readable summary of the diff
+func (d *Diff) String() string {
+	b := &strings.Builder{}
+	d.WriteText(b)
+	return b.String()
+}
+
+func (c *Change) empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+func (c *Change) writeText(b *strings.Builder, title string) {
+	if c.empty() {
+		return
+	}
+	fmt.Fprintf(b, "%s:\n", title)
+	for _, value := range c.Added {
+		fmt.Fprintf(b, "  + %s\n", value)
+	}
+	for _, value := range c.Removed {
+		fmt.Fprintf(b, "  - %s\n", value)
+	}
+}
+
+// compare returns the values only in old or only in new
+func compare(old, new []string) *Change {
+	change := &Change{Added: []string{}, Removed: []string{}}
+	oldValues, newValues := map[string]bool{}, map[string]bool{}
+	for _, value := range old {
+		oldValues[value] = true
+	}
+	for _, value := range new {
+		newValues[value] = true
+	}
+
+	for value := range newValues {
+		if !oldValues[value] {
+			change.Added = append(change.Added, value)
+		}
+	}
+	for value := range oldValues {
+		if !newValues[value] {
+			change.Removed = append(change.Removed, value)
+		}
+	}
+	sort.Strings(change.Added)
+	sort.Strings(change.Removed)
+
+	return change
+}
+
+// compareFiles returns the dropped files only in old or only in new
+func compareFiles(old, new []*ioc.File) *FileChange {
+	change := &FileChange{Added: []*ioc.File{}, Removed: []*ioc.File{}}
+	oldHashes, newHashes := map[string]bool{}, map[string]bool{}
+	for _, file := range old {
+		oldHashes[file.SHA256] = true
+	}
+	for _, file := range new {
+		newHashes[file.SHA256] = true
+	}
+
+	// The sets are already sorted by hash
+	for _, file := range new {
+		if !oldHashes[file.SHA256] {
+			change.Added = append(change.Added, file)
+		}
+	}
+	for _, file := range old {
+		if !newHashes[file.SHA256] {
+			change.Removed = append(change.Removed, file)
+		}
+	}
+
+	return change
+}
+
+// signatureNames returns the names of the matched signatures
+func signatureNames(report *cuckoo.Report) []string {
+	names := make([]string, 0, len(report.Signatures))
+	for _, signature := range report.Signatures {
+		names = append(names, signature.Name)
+	}
+	return names
+}
+
+// processes returns the processes as command lines since PIDs differ between runs
+func processes(set *ioc.Set) []string {
+	commandLines := make([]string, 0, len(set.Processes))
+	for _, process := range set.Processes {
+		if process.CommandLine != "" {
+			commandLines = append(commandLines, process.CommandLine)
+		} else {
+			commandLines = append(commandLines, process.Name)
+		}
+	}
+	return commandLines
+}
@@ -0,0 +1,83 @@
+package reportdiff
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+// changedReport returns the test report as if it was reanalyzed
+func changedReport(t *testing.T) *cuckoo.Report {
+	report := testutil.LoadReport(t)
+	report.Info.ID = 43
+	report.Info.Score = 3.4
+	report.Signatures = report.Signatures[1:]
+	report.Signatures = append(report.Signatures, &cuckoo.Signature{Name: "antivm_generic_disk"})
+	report.Network.Hosts = append(report.Network.Hosts, cuckoo.NetworkHost{IP: "203.0.113.7"})
+	report.Dropped = report.Dropped[:1]
+	report.Behavior.Processes = report.Behavior.Processes[:1]
+	return report
+}
+
+func TestCompare(t *testing.T) {
+	diff := Compare(testutil.LoadReport(t), changedReport(t), nil)
+
+	if diff.OldTaskID != 42 || diff.NewTaskID != 43 || math.Abs(diff.ScoreChange+3) > 0.001 {
+		t.Errorf("unexpected header %+v", diff)
+	}
+	if !reflect.DeepEqual(diff.Signatures, &Change{Added: []string{"antivm_generic_disk"}, Removed: []string{"injection_createremotethread"}}) {
+		t.Errorf("unexpected signatures %+v", diff.Signatures)
+	}
+	if !reflect.DeepEqual(diff.IPs.Added, []string{"203.0.113.7"}) || len(diff.IPs.Removed) != 0 {
+		t.Errorf("unexpected ips %+v", diff.IPs)
+	}
+	if len(diff.DroppedFiles.Removed) != 1 || diff.DroppedFiles.Removed[0].Name != "config.bin" {
+		t.Errorf("unexpected dropped files %+v", diff.DroppedFiles)
+	}
+	if !reflect.DeepEqual(diff.Processes.Removed, []string{"cmd.exe /c del invoice.exe"}) {
+		t.Errorf("unexpected processes %+v", diff.Processes)
+	}
+
+	text := diff.String()
+	for _, line := range []string{"Score: 6.4 -> 3.4 (-3.0)", "  + antivm_generic_disk", "  - injection_createremotethread", "  + 203.0.113.7"} {
+		if !strings.Contains(text, line) {
+			t.Errorf("summary is missing %q:\n%s", line, text)
+		}
+	}
+
+	if same := Compare(testutil.LoadReport(t), testutil.LoadReport(t), nil); !same.Empty() || !strings.Contains(same.String(), "No changes") {
+		t.Errorf("identical reports should not differ:\n%s", same)
+	}
+}
+
+func TestCompareTasks(t *testing.T) {
+	changed, _ := json.Marshal(changedReport(t))
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/report/42/json":
+			http.ServeFile(w, r, "../testdata/report.json")
+		case "/tasks/report/43/json":
+			w.Write(changed)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	diff, err := CompareTasks(context.Background(), c, 42, 43, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.NewTaskID != 43 || len(diff.Signatures.Added) != 1 {
+		t.Errorf("unexpected diff %+v", diff)
+	}
+}
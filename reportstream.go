@@ -0,0 +1,170 @@
+package cuckoo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrStopWalk can be returned by a SectionHandler to stop reading the report early without an error
+var ErrStopWalk = errors.New("stop walking report")
+
+// SectionHandler is called by WalkReport with the decoder positioned at the start of a selected value.
+//
+// The handler must consume exactly that one value, either with dec.Decode or by reading its tokens
+// (WalkValue can be used to select values nested inside it).
+type SectionHandler func(path string, dec *json.Decoder) error
+
+// WalkReport Reads a JSON report token by token and calls the handler registered for each selected path.
+//
+// Paths are made of object keys separated by dots, and "[]" selects every element of an array, e.g.
+// "signatures", "network.dns" or "behavior.processes[].calls[]".  Everything that is not selected is
+// skipped as it is read, so only the values handed to handlers are ever decoded.
+func WalkReport(r io.Reader, handlers map[string]SectionHandler) error {
+	err := WalkValue(json.NewDecoder(r), handlers)
+	if err == ErrStopWalk {
+		return nil
+	}
+	return err
+}
+
+// WalkValue Walks the next value of dec like WalkReport, with paths relative to that value.
+//
+// It is meant to be called from a SectionHandler to stream through a large value, such as the calls of a process.
+func WalkValue(dec *json.Decoder, handlers map[string]SectionHandler) error {
+	w := &walker{dec: dec, handlers: handlers, prefixes: map[string]bool{}}
+	for path := range handlers {
+		// Mark every parent of a selected path so the walker knows to descend into it
+		for i := range path {
+			switch {
+			case path[i] == '.':
+				w.prefixes[path[:i]] = true
+			case strings.HasPrefix(path[i:], "[]"):
+				w.prefixes[path[:i]] = true
+			}
+		}
+	}
+	w.prefixes[""] = true
+
+	return w.walk("")
+}
+
+// walker holds the state of a single WalkValue call
+type walker struct {
+	dec      *json.Decoder
+	handlers map[string]SectionHandler
+	prefixes map[string]bool
+}
+
+// walk handles the value at path, descending into it if a selected path is nested below it
+func (w *walker) walk(path string) error {
+	if handler, ok := w.handlers[path]; ok {
+		return handler(path, w.dec)
+	}
+	if !w.prefixes[path] {
+		return skipValue(w.dec)
+	}
+
+	token, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		for w.dec.More() {
+			key, err := w.dec.Token()
+			if err != nil {
+				return err
+			}
+			child := key.(string)
+			if path != "" {
+				child = path + "." + child
+			}
+			if err := w.walk(child); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for w.dec.More() {
+			if err := w.walk(path + "[]"); err != nil {
+				return err
+			}
+		}
+	default:
+		// A scalar where an object or array was expected, nothing below it can be selected
+		return nil
+	}
+
+	// Consume the closing delimiter
+	_, err = w.dec.Token()
+	return err
+}
+
+// skipValue reads past the next value of dec without decoding it
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// ExtractReportSection Decodes the value at path (see WalkReport) into v and stops reading.
+//
+// It returns an error if the report has no value at path.
+func ExtractReportSection(r io.Reader, path string, v interface{}) error {
+	found := false
+	err := WalkReport(r, map[string]SectionHandler{
+		path: func(path string, dec *json.Decoder) error {
+			found = true
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+			return ErrStopWalk
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("report section %s not found", path)
+	}
+
+	return nil
+}
+
+// TasksReportWalk Walks the report of the specified task ID, see WalkReport.
+func (c *Client) TasksReportWalk(ctx context.Context, taskID int, handlers map[string]SectionHandler) error {
+	body, err := c.TasksReport(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return WalkReport(body, handlers)
+}
+
+// TasksReportSection Decodes a single section of the report of the specified task ID, see ExtractReportSection.
+func (c *Client) TasksReportSection(ctx context.Context, taskID int, path string, v interface{}) error {
+	body, err := c.TasksReport(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return ExtractReportSection(body, path, v)
+}
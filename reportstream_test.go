@@ -0,0 +1,87 @@
+package cuckoo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestWalkReport(t *testing.T) {
+	file, err := os.Open("testdata/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	signatures := []*Signature{}
+	calls := map[int]int{}
+	pid := 0
+	err = WalkReport(file, map[string]SectionHandler{
+		"signatures": func(path string, dec *json.Decoder) error {
+			return dec.Decode(&signatures)
+		},
+		"behavior.processes[]": func(path string, dec *json.Decoder) error {
+			return WalkValue(dec, map[string]SectionHandler{
+				"pid": func(path string, dec *json.Decoder) error {
+					return dec.Decode(&pid)
+				},
+				"calls[]": func(path string, dec *json.Decoder) error {
+					call := &Call{}
+					if err := dec.Decode(call); err != nil {
+						return err
+					}
+					calls[pid]++
+					return nil
+				},
+			})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(signatures) != 3 || signatures[0].Name != "injection_createremotethread" {
+		t.Errorf("signatures not extracted: %v", signatures)
+	}
+	if calls[2048] != 4 || calls[2100] != 1 {
+		t.Errorf("calls not streamed: %v", calls)
+	}
+}
+
+func TestExtractReportSection(t *testing.T) {
+	file, err := os.Open("testdata/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	dns := []*DNSRequest{}
+	if err := ExtractReportSection(file, "network.dns", &dns); err != nil {
+		t.Fatal(err)
+	}
+	if len(dns) != 2 || dns[0].Answers[0].Data != "185.100.87.202" {
+		t.Errorf("dns not extracted: %v", dns)
+	}
+
+	file.Seek(0, 0)
+	var missing interface{}
+	if err := ExtractReportSection(file, "network.nothing", &missing); err == nil {
+		t.Errorf("expected an error for a missing section")
+	}
+}
+
+func TestTasksReportSection(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/report.json")
+	})
+
+	info := &ReportInfo{}
+	if err := c.TasksReportSection(context.Background(), 42, "info", info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != 42 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
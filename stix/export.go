@@ -0,0 +1,299 @@
+package stix
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/reporttime"
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+// Analysis results, from the STIX malware-result vocabulary
+const (
+	ResultMalicious  = "malicious"
+	ResultSuspicious = "suspicious"
+	ResultBenign     = "benign"
+	ResultUnknown    = "unknown"
+)
+
+const (
+	defaultMaliciousScore  = 5
+	defaultSuspiciousScore = 2
+	product                = "cuckoo"
+)
+
+// Options control the export
+type Options struct {
+	// Options used to extract the indicators, see ioc.Extract
+	IOC *ioc.Options
+	// Score from which the analysis is malicious, defaults to 5
+	MaliciousScore float64
+	// Score from which the analysis is suspicious, defaults to 2
+	SuspiciousScore float64
+	// Result overrides the score based result when set (e.g. from a verdict policy)
+	Result string
+}
+
+// Export Converts a reported task into a STIX 2.1 bundle.
+//
+// sample holds the hashes of the analyzed file and may be nil for URL tasks.  Every identifier is derived
+// from the task and its content, so exporting the same task again produces the same objects.  The SDOs carry the
+// time and the result of the analysis, so their identifiers include the task: exporting another task that saw the
+// same domain produces another indicator rather than a conflicting version of the same one.  SCOs are shared.
+//
+// Indicators, a malware SDO and its relationships are only created when the result is malicious or suspicious.
+func Export(task *cuckoo.Task, sample *cuckoo.Sample, report *cuckoo.Report, opts *Options) (*Bundle, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	created := reporttime.Analysis(task, report)
+	e := &exporter{created: Timestamp(created), objects: []interface{}{}, seen: map[string]bool{}}
+
+	// The analyzed sample
+	var sampleRef, sampleName string
+	switch {
+	case sample != nil:
+		if report.Target != nil && report.Target.File != nil {
+			sampleName = report.Target.File.Name
+		}
+		file := newFile(sampleName, sample.FileSize, hashes(sample.Md5, sample.Sha1, sample.Sha256, sample.Sha512))
+		e.add(file)
+		sampleRef = file.ID
+	case task.Category == "url":
+		sampleName = task.Target
+		value := newValue("url", task.Target)
+		e.add(value)
+		sampleRef = value.ID
+	default:
+		return nil, fmt.Errorf("task %d has no sample to export", task.ID)
+	}
+	e.analysisID = sdoID("malware-analysis", product, strconv.Itoa(task.ID), sampleRef)
+
+	// Observed objects
+	set := ioc.Extract(report, opts.IOC)
+	observed := []string{}
+	network := []string{}
+	for _, ip := range set.IPs {
+		t := "ipv4-addr"
+		if net.ParseIP(ip).To4() == nil {
+			t = "ipv6-addr"
+		}
+		value := newValue(t, ip)
+		e.add(value)
+		observed = append(observed, value.ID)
+		network = append(network, value.ID)
+		e.indicator(fmt.Sprintf("[%s:value = %s]", t, patternString(ip)), ip)
+	}
+	for _, domain := range set.Domains {
+		value := newValue("domain-name", domain)
+		e.add(value)
+		observed = append(observed, value.ID)
+		network = append(network, value.ID)
+		e.indicator(fmt.Sprintf("[domain-name:value = %s]", patternString(domain)), domain)
+	}
+	for _, u := range set.URLs {
+		value := newValue("url", u)
+		if value.ID == sampleRef {
+			continue
+		}
+		e.add(value)
+		observed = append(observed, value.ID)
+		network = append(network, value.ID)
+		e.indicator(fmt.Sprintf("[url:value = %s]", patternString(u)), u)
+	}
+	dropped := []string{}
+	for _, dropFile := range set.DroppedFiles {
+		file := newFile(dropFile.Name, dropFile.Size, hashes(dropFile.MD5, dropFile.SHA1, dropFile.SHA256, dropFile.SHA512))
+		if file.ID == sampleRef {
+			continue
+		}
+		e.add(file)
+		observed = append(observed, file.ID)
+		dropped = append(dropped, file.ID)
+		e.indicator(fmt.Sprintf("[file:hashes.'SHA-256' = %s]", patternString(dropFile.SHA256)), dropFile.Name)
+	}
+
+	// The analysis itself
+	result := opts.Result
+	if result == "" {
+		result = scoreResult(report, opts)
+	}
+	analysis := &MalwareAnalysis{
+		Type:            "malware-analysis",
+		SpecVersion:     specVersion,
+		ID:              e.analysisID,
+		Created:         e.created,
+		Modified:        e.created,
+		Product:         product,
+		Result:          result,
+		SampleRef:       sampleRef,
+		AnalysisSCORefs: observed,
+		ExternalReferences: []*ExternalReference{{
+			SourceName: product,
+			ExternalID: strconv.Itoa(task.ID),
+		}},
+	}
+	if report.Info != nil {
+		analysis.Version = report.Info.Version
+		if !report.Info.Started.IsZero() {
+			started := Timestamp(report.Info.Started.Time)
+			analysis.AnalysisStarted = &started
+		}
+		if !report.Info.Ended.IsZero() {
+			ended := Timestamp(report.Info.Ended.Time)
+			analysis.AnalysisEnded = &ended
+		}
+	}
+	e.add(analysis)
+
+	if result == ResultMalicious || result == ResultSuspicious {
+		indicatorType := "malicious-activity"
+		if result == ResultSuspicious {
+			indicatorType = "anomalous-activity"
+		}
+
+		malware := &Malware{
+			Type:        "malware",
+			SpecVersion: specVersion,
+			ID:          sdoID("malware", analysis.ID),
+			Created:     e.created,
+			Modified:    e.created,
+			Name:        sampleName,
+			SampleRefs:  []string{sampleRef},
+		}
+		e.add(malware)
+		e.relationship("dynamic-analysis-of", analysis.ID, malware.ID)
+		for _, ref := range network {
+			e.relationship("communicates-with", malware.ID, ref)
+		}
+		for _, ref := range dropped {
+			e.relationship("drops", malware.ID, ref)
+		}
+		for _, indicator := range e.indicators {
+			indicator.IndicatorTypes = []string{indicatorType}
+			e.add(indicator)
+			e.relationship("indicates", indicator.ID, malware.ID)
+		}
+	}
+
+	return &Bundle{
+		Type:    "bundle",
+		ID:      sdoID("bundle", analysis.ID),
+		Objects: e.objects,
+	}, nil
+}
+
+// ExportTask Fetches a task, its sample and its report from cuckoo with ioc.FetchTask and exports them, see Export.
+func ExportTask(ctx context.Context, c *cuckoo.Client, taskID int, opts *Options) (*Bundle, error) {
+	withMachine := &Options{}
+	if opts != nil {
+		*withMachine = *opts
+	}
+	data, err := ioc.FetchTask(ctx, c, taskID, withMachine.IOC)
+	if err != nil {
+		return nil, err
+	}
+	withMachine.IOC = data.Options
+
+	return Export(data.Task, data.Sample, data.Report, withMachine)
+}
+
+// exporter collects the objects of a bundle
+type exporter struct {
+	created    Timestamp
+	analysisID string
+	objects    []interface{}
+	seen       map[string]bool
+	indicators []*Indicator
+}
+
+// add adds an object to the bundle unless an object with the same ID was already added
+func (e *exporter) add(object interface{}) {
+	var id string
+	switch o := object.(type) {
+	case *File:
+		id = o.ID
+	case *Value:
+		id = o.ID
+	case *MalwareAnalysis:
+		id = o.ID
+	case *Malware:
+		id = o.ID
+	case *Indicator:
+		id = o.ID
+	case *Relationship:
+		id = o.ID
+	}
+	if e.seen[id] {
+		return
+	}
+	e.seen[id] = true
+	e.objects = append(e.objects, object)
+}
+
+// indicator queues an indicator, they are only added if the analysis is not benign
+func (e *exporter) indicator(pattern, name string) {
+	e.indicators = append(e.indicators, &Indicator{
+		Type:        "indicator",
+		SpecVersion: specVersion,
+		ID:          sdoID("indicator", e.analysisID, pattern),
+		Created:     e.created,
+		Modified:    e.created,
+		Name:        name,
+		Pattern:     pattern,
+		PatternType: "stix",
+		ValidFrom:   e.created,
+	})
+}
+
+func (e *exporter) relationship(relationshipType, source, target string) {
+	e.add(&Relationship{
+		Type:             "relationship",
+		SpecVersion:      specVersion,
+		ID:               sdoID("relationship", relationshipType, source, target),
+		Created:          e.created,
+		Modified:         e.created,
+		RelationshipType: relationshipType,
+		SourceRef:        source,
+		TargetRef:        target,
+	})
+}
+
+// scoreResult maps the cuckoo score to a result
+func scoreResult(report *cuckoo.Report, opts *Options) string {
+	if report.Info == nil {
+		return ResultUnknown
+	}
+
+	malicious, suspicious := opts.MaliciousScore, opts.SuspiciousScore
+	if malicious == 0 {
+		malicious = defaultMaliciousScore
+	}
+	if suspicious == 0 {
+		suspicious = defaultSuspiciousScore
+	}
+
+	switch {
+	case report.Info.Score >= malicious:
+		return ResultMalicious
+	case report.Info.Score >= suspicious:
+		return ResultSuspicious
+	default:
+		return ResultBenign
+	}
+}
+
+// hashes returns the STIX hashes dictionary for the non empty hashes
+func hashes(md5, sha1, sha256, sha512 string) map[string]string {
+	all := map[string]string{"MD5": md5, "SHA-1": sha1, "SHA-256": sha256, "SHA-512": sha512}
+	for algorithm, hash := range all {
+		if hash == "" {
+			delete(all, algorithm)
+		}
+	}
+	return all
+}
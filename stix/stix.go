@@ -0,0 +1,180 @@
+// Package stix exports cuckoo analysis results as STIX 2.1 bundles
+package stix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/godaddy/go-cukoo/internal/uuid"
+)
+
+const (
+	specVersion = "2.1"
+	timeFormat  = "2006-01-02T15:04:05.000Z"
+)
+
+var (
+	// scoNamespace is the namespace the STIX 2.1 spec defines for deterministic SCO identifiers
+	scoNamespace = uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+	// sdoNamespace is used to derive identifiers for the SDOs and SROs created by this package
+	sdoNamespace = uuid.V5(scoNamespace, "github.com/godaddy/go-cuckoo/stix")
+)
+
+// Bundle is a STIX 2.1 bundle
+type Bundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// Timestamp is a STIX timestamp, always written in UTC with millisecond precision
+type Timestamp time.Time
+
+// MarshalJSON writes the timestamp in the STIX format
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Time(t).UTC().Format(timeFormat))
+}
+
+// MalwareAnalysis is a malware-analysis SDO
+type MalwareAnalysis struct {
+	Type               string               `json:"type"`
+	SpecVersion        string               `json:"spec_version"`
+	ID                 string               `json:"id"`
+	Created            Timestamp            `json:"created"`
+	Modified           Timestamp            `json:"modified"`
+	Product            string               `json:"product"`
+	Version            string               `json:"version,omitempty"`
+	Result             string               `json:"result"`
+	AnalysisStarted    *Timestamp           `json:"analysis_started,omitempty"`
+	AnalysisEnded      *Timestamp           `json:"analysis_ended,omitempty"`
+	SampleRef          string               `json:"sample_ref,omitempty"`
+	AnalysisSCORefs    []string             `json:"analysis_sco_refs,omitempty"`
+	ExternalReferences []*ExternalReference `json:"external_references,omitempty"`
+}
+
+// ExternalReference points to the task the analysis came from
+type ExternalReference struct {
+	SourceName  string `json:"source_name"`
+	ExternalID  string `json:"external_id,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Malware is a malware SDO describing the analyzed sample
+type Malware struct {
+	Type        string    `json:"type"`
+	SpecVersion string    `json:"spec_version"`
+	ID          string    `json:"id"`
+	Created     Timestamp `json:"created"`
+	Modified    Timestamp `json:"modified"`
+	Name        string    `json:"name,omitempty"`
+	IsFamily    bool      `json:"is_family"`
+	SampleRefs  []string  `json:"sample_refs,omitempty"`
+}
+
+// Indicator is an indicator SDO with a STIX pattern
+type Indicator struct {
+	Type           string    `json:"type"`
+	SpecVersion    string    `json:"spec_version"`
+	ID             string    `json:"id"`
+	Created        Timestamp `json:"created"`
+	Modified       Timestamp `json:"modified"`
+	Name           string    `json:"name"`
+	IndicatorTypes []string  `json:"indicator_types,omitempty"`
+	Pattern        string    `json:"pattern"`
+	PatternType    string    `json:"pattern_type"`
+	ValidFrom      Timestamp `json:"valid_from"`
+}
+
+// Relationship is a relationship SRO
+type Relationship struct {
+	Type             string    `json:"type"`
+	SpecVersion      string    `json:"spec_version"`
+	ID               string    `json:"id"`
+	Created          Timestamp `json:"created"`
+	Modified         Timestamp `json:"modified"`
+	RelationshipType string    `json:"relationship_type"`
+	SourceRef        string    `json:"source_ref"`
+	TargetRef        string    `json:"target_ref"`
+}
+
+// File is a file SCO
+type File struct {
+	Type        string            `json:"type"`
+	SpecVersion string            `json:"spec_version"`
+	ID          string            `json:"id"`
+	Hashes      map[string]string `json:"hashes,omitempty"`
+	Size        int64             `json:"size,omitempty"`
+	Name        string            `json:"name,omitempty"`
+}
+
+// Value is one of the SCOs identified by a single value: ipv4-addr, ipv6-addr, domain-name and url
+type Value struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value"`
+}
+
+// newValue creates a value SCO with its deterministic identifier
+func newValue(t, value string) *Value {
+	return &Value{
+		Type:        t,
+		SpecVersion: specVersion,
+		ID:          scoID(t, map[string]interface{}{"value": value}),
+		Value:       value,
+	}
+}
+
+// newFile creates a file SCO with its deterministic identifier
+func newFile(name string, size int64, hashes map[string]string) *File {
+	contributing := map[string]interface{}{}
+	// The spec only uses one hash for the identifier, in this order of preference
+	for _, algorithm := range []string{"MD5", "SHA-1", "SHA-256", "SHA-512"} {
+		if hashes[algorithm] != "" {
+			contributing["hashes"] = map[string]string{algorithm: hashes[algorithm]}
+			break
+		}
+	}
+	if name != "" {
+		contributing["name"] = name
+	}
+
+	return &File{
+		Type:        "file",
+		SpecVersion: specVersion,
+		ID:          scoID("file", contributing),
+		Hashes:      hashes,
+		Size:        size,
+		Name:        name,
+	}
+}
+
+// scoID returns the deterministic identifier of an SCO from its ID contributing properties
+func scoID(t string, contributing map[string]interface{}) string {
+	return fmt.Sprintf("%s--%s", t, uuid.V5(scoNamespace, canonicalJSON(contributing)))
+}
+
+// sdoID returns a deterministic identifier for an SDO or SRO made from the given parts
+func sdoID(t string, parts ...string) string {
+	return fmt.Sprintf("%s--%s", t, uuid.V5(sdoNamespace, t+"|"+strings.Join(parts, "|")))
+}
+
+// canonicalJSON encodes v with sorted keys and without HTML escaping, as JCS requires for ASCII content
+func canonicalJSON(v interface{}) string {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// patternString quotes a value for use in a STIX pattern
+func patternString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
@@ -0,0 +1,160 @@
+package stix
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+var (
+	testTask   = &cuckoo.Task{ID: 42, Category: "file", Status: cuckoo.StatusReported}
+	testSample = &cuckoo.Sample{
+		Md5:      "5d41402abc4b2a76b9719d911017c592",
+		Sha1:     "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+		Sha256:   "0d8f0c3aa6b9f34d4a2dcaabb8a0a0a3a4b6f6ad5f4b5b8c8e0f1d2c3b4a5968",
+		FileSize: 73802,
+	}
+	testOptions = &Options{IOC: &ioc.Options{Machines: []*cuckoo.Machine{{IP: "192.168.56.101", ResultserverIP: "192.168.56.1"}}}}
+)
+
+func TestSCOIdentifiers(t *testing.T) {
+	// Identifiers generated with the uuid5 of the STIX namespace over the canonical value
+	if id := newValue("ipv4-addr", "185.100.87.202").ID; id != "ipv4-addr--346d0d43-03f7-5188-a697-debbcc8eb06f" {
+		t.Errorf("unexpected ipv4-addr id %s", id)
+	}
+	if id := newValue("domain-name", "evil.example.com").ID; id != "domain-name--6262c8a9-ac28-50fe-a7df-f5923bb2ee14" {
+		t.Errorf("unexpected domain-name id %s", id)
+	}
+}
+
+func TestExport(t *testing.T) {
+	bundle, err := Export(testTask, testSample, testutil.LoadReport(t), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	var analysis *MalwareAnalysis
+	for _, object := range bundle.Objects {
+		switch o := object.(type) {
+		case *MalwareAnalysis:
+			analysis = o
+			counts[o.Type]++
+		case *Value:
+			counts[o.Type]++
+		case *File:
+			counts[o.Type]++
+		case *Indicator:
+			counts[o.Type]++
+			if !strings.HasPrefix(o.Pattern, "[") || o.IndicatorTypes[0] != "malicious-activity" {
+				t.Errorf("bad indicator %+v", o)
+			}
+		case *Malware:
+			counts[o.Type]++
+		case *Relationship:
+			counts[o.RelationshipType]++
+		}
+	}
+
+	expected := map[string]int{
+		"malware-analysis":    1,
+		"malware":             1,
+		"file":                3,
+		"ipv4-addr":           2,
+		"domain-name":         1,
+		"url":                 1,
+		"indicator":           6,
+		"indicates":           6,
+		"communicates-with":   4,
+		"drops":               2,
+		"dynamic-analysis-of": 1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected %v, got %v", expected, counts)
+	}
+	if analysis.Result != ResultMalicious || analysis.Version != "2.0.7" || len(analysis.AnalysisSCORefs) != 6 {
+		t.Errorf("unexpected analysis %+v", analysis)
+	}
+
+	data, _ := json.Marshal(bundle)
+	if !strings.Contains(string(data), `"analysis_ended":"2020-02-11T16:48:35.250Z"`) {
+		t.Errorf("timestamps not in the STIX format: %s", data)
+	}
+}
+
+func TestExportDeterministic(t *testing.T) {
+	first, err := Export(testTask, testSample, testutil.LoadReport(t), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Export(testTask, testSample, testutil.LoadReport(t), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstJSON, _ := json.Marshal(first)
+	secondJSON, _ := json.Marshal(second)
+	if string(firstJSON) != string(secondJSON) {
+		t.Errorf("exports differ")
+	}
+}
+
+func TestExportTasksIndicators(t *testing.T) {
+	first, err := Export(testTask, testSample, testutil.LoadReport(t), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := *testTask
+	other.ID = 43
+	second, err := Export(&other, testSample, testutil.LoadReport(t), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(bundle *Bundle) map[string]string {
+		found := map[string]string{}
+		for _, object := range bundle.Objects {
+			switch o := object.(type) {
+			case *Indicator:
+				found[o.ID] = o.Type
+			case *Malware:
+				found[o.ID] = o.Type
+			case *Value:
+				found[o.ID] = o.Type
+			}
+		}
+		return found
+	}
+	firstIDs := ids(first)
+	for id, objectType := range ids(second) {
+		shared := firstIDs[id] != ""
+		if objectType == "indicator" || objectType == "malware" {
+			if shared {
+				t.Errorf("%s %s is shared by two tasks", objectType, id)
+			}
+		} else if !shared {
+			t.Errorf("%s %s is not shared by two tasks", objectType, id)
+		}
+	}
+}
+
+func TestExportBenign(t *testing.T) {
+	report := testutil.LoadReport(t)
+	report.Info.Score = 0.5
+
+	bundle, err := Export(testTask, testSample, report, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range bundle.Objects {
+		switch object.(type) {
+		case *Indicator, *Malware, *Relationship:
+			t.Errorf("benign analysis should not have %T", object)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package cuckoo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockFormat is the layout cuckoo expects for the clock option (%m-%d-%Y %H:%M:%S)
+const clockFormat = "01-02-2006 15:04:05"
+
+// TaskOptions are the optional settings cuckoo accepts when creating a task.
+//
+// Any field left at its zero value is not sent, so cuckoo falls back to its own defaults.
+type TaskOptions struct {
+	// Analysis package to be used for the analysis
+	Package string
+	// Analysis timeout (in seconds)
+	Timeout int
+	// Priority to assign to the task (1-3)
+	Priority int
+	// Options to pass to the analysis package
+	Options map[string]string
+	// Label of the analysis machine to use for the analysis
+	Machine string
+	// Name of the platform to select the analysis machine from (e.g. "windows")
+	Platform string
+	// Tags used to select the analysis machine
+	Tags []string
+	// Custom string to pass over to the analysis and the processing/reporting modules
+	Custom string
+	// Owner of the task
+	Owner string
+	// Enable to take a full memory dump of the analysis machine
+	Memory bool
+	// Enable to enforce the execution for the full timeout value
+	EnforceTimeout bool
+	// Set the virtual machine clock
+	Clock time.Time
+	// Only submit samples that have not been analyzed before (file submissions only)
+	Unique bool
+}
+
+// fields returns the form fields for the options in a stable order
+func (o *TaskOptions) fields() [][2]string {
+	if o == nil {
+		return nil
+	}
+
+	fields := [][2]string{}
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, [2]string{key, value})
+		}
+	}
+	addBool := func(key string, value bool) {
+		if value {
+			add(key, "1")
+		}
+	}
+
+	add("package", o.Package)
+	if o.Timeout > 0 {
+		add("timeout", strconv.Itoa(o.Timeout))
+	}
+	if o.Priority > 0 {
+		add("priority", strconv.Itoa(o.Priority))
+	}
+	add("options", encodeOptions(o.Options))
+	add("machine", o.Machine)
+	add("platform", o.Platform)
+	add("tags", strings.Join(o.Tags, ","))
+	add("custom", o.Custom)
+	add("owner", o.Owner)
+	addBool("memory", o.Memory)
+	addBool("enforce_timeout", o.EnforceTimeout)
+	if !o.Clock.IsZero() {
+		add("clock", o.Clock.Format(clockFormat))
+	}
+	addBool("unique", o.Unique)
+
+	return fields
+}
+
+// encodeOptions encodes package options in the "key1=value1,key2=value2" format cuckoo expects
+func encodeOptions(options map[string]string) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, options[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// TasksCreateFile Adds a file to the list of pending tasks and returns the new task ID.
+//
+// The file is streamed to cuckoo as a multipart upload, so sample is never fully buffered in memory.
+// opts may be nil to use the cuckoo defaults.
+func (c *Client) TasksCreateFile(ctx context.Context, filename string, sample io.Reader, opts *TaskOptions) (taskID int, err error) {
+	body, contentType := multipartBody(opts.fields(), func(w *multipart.Writer) error {
+		part, err := w.CreateFormFile("file", filename)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, sample)
+		return err
+	})
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/tasks/create/file", c.BaseURL), body)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.MakeRequest(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 400:
+		return -1, fmt.Errorf("unable to create task: %s", errorMessage(resp))
+	default:
+		return -1, fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+
+	return decodeTaskID(resp)
+}
+
+// InvalidURLError is returned by TasksCreateURL when the URL is rejected before being sent to cuckoo
+type InvalidURLError struct {
+	URL    string
+	Reason string
+}
+
+func (e *InvalidURLError) Error() string {
+	return fmt.Sprintf("invalid url %q: %s", e.URL, e.Reason)
+}
+
+// TasksCreateURL Adds a URL to the list of pending tasks and returns the new task ID.
+//
+// Only http and https URLs are accepted, anything else returns an *InvalidURLError without contacting cuckoo.
+// opts may be nil to use the cuckoo defaults.  Unique only applies to file submissions and is ignored.
+func (c *Client) TasksCreateURL(ctx context.Context, target string, opts *TaskOptions) (taskID int, err error) {
+	if err := validateURL(target); err != nil {
+		return -1, err
+	}
+
+	form := url.Values{}
+	form.Set("url", target)
+	for _, field := range opts.fields() {
+		if field[0] == "unique" {
+			continue
+		}
+		form.Set(field[0], field[1])
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/tasks/create/url", c.BaseURL), strings.NewReader(form.Encode()))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.MakeRequest(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 400:
+		return -1, fmt.Errorf("unable to create task: %s", errorMessage(resp))
+	default:
+		return -1, fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+
+	return decodeTaskID(resp)
+}
+
+// validateURL makes sure target is an absolute http(s) URL
+func validateURL(target string) error {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return &InvalidURLError{URL: target, Reason: err.Error()}
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		break
+	case "":
+		return &InvalidURLError{URL: target, Reason: "missing scheme"}
+	default:
+		return &InvalidURLError{URL: target, Reason: fmt.Sprintf("unsupported scheme %q", parsed.Scheme)}
+	}
+	if parsed.Host == "" {
+		return &InvalidURLError{URL: target, Reason: "missing host"}
+	}
+
+	return nil
+}
+
+// SubmitFile is a file to send along with TasksCreateSubmit
+type SubmitFile struct {
+	Name   string
+	Reader io.Reader
+}
+
+// SubmitInput is one submitted file or URL along with the tasks cuckoo created for it
+type SubmitInput struct {
+	// Name of the file, empty for URLs
+	Name string
+	// URL that was submitted, empty for files
+	URL     string
+	TaskIDs []int
+}
+
+// SubmitResult is returned by TasksCreateSubmit
+type SubmitResult struct {
+	// One submit ID per request made, files and URLs are sent as separate requests
+	SubmitIDs []int
+	// Every task created, in the order cuckoo returned them
+	TaskIDs []int
+	// Errors cuckoo reported for individual inputs (e.g. an unreadable archive)
+	Errors []string
+	// Inputs in the order they were given, files first
+	Inputs []*SubmitInput
+}
+
+// TasksCreateSubmit Submits several files and/or URLs in one go through the /tasks/create/submit endpoint.
+//
+// Cuckoo accepts either files or URLs per request, so if both are given two submissions are made.
+// Every created task is looked up with one TasksView call so it can be mapped back to the input it came from, tasks
+// that can't be matched (e.g. files extracted from an archive) only show up in SubmitResult.TaskIDs.  Tasks are
+// matched on the base name of files, so inputs sharing a name get one of their tasks each, in order, and any extra
+// task goes to the first of them.
+//
+// Once cuckoo created tasks, an error from a later step is returned along with the partial result so the tasks
+// already created are not lost, submitting again would analyze the samples twice.  The result is nil only if
+// nothing was submitted.
+//
+// The submit endpoint only honours the Options, Memory and EnforceTimeout settings of opts.
+func (c *Client) TasksCreateSubmit(ctx context.Context, files []*SubmitFile, urls []string, opts *TaskOptions) (*SubmitResult, error) {
+	if len(files) == 0 && len(urls) == 0 {
+		return nil, fmt.Errorf("no files or urls to submit")
+	}
+	for _, target := range urls {
+		if err := validateURL(target); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &SubmitResult{
+		SubmitIDs: []int{},
+		TaskIDs:   []int{},
+		Errors:    []string{},
+		Inputs:    []*SubmitInput{},
+	}
+	for _, file := range files {
+		result.Inputs = append(result.Inputs, &SubmitInput{Name: file.Name, TaskIDs: []int{}})
+	}
+	for _, target := range urls {
+		result.Inputs = append(result.Inputs, &SubmitInput{URL: target, TaskIDs: []int{}})
+	}
+
+	if len(files) > 0 {
+		body, contentType := multipartBody(opts.fields(), func(w *multipart.Writer) error {
+			for _, file := range files {
+				part, err := w.CreateFormFile("files", file.Name)
+				if err != nil {
+					return err
+				}
+				if _, err := io.Copy(part, file.Reader); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		err := c.submit(ctx, body, contentType, result)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(urls) > 0 {
+		form := url.Values{}
+		form.Set("strings", strings.Join(urls, "\n"))
+		for _, field := range opts.fields() {
+			form.Set(field[0], field[1])
+		}
+		if err := c.submit(ctx, strings.NewReader(form.Encode()), "application/x-www-form-urlencoded", result); err != nil {
+			// The files may already have been submitted
+			if len(result.SubmitIDs) == 0 {
+				return nil, err
+			}
+			return result, err
+		}
+	}
+
+	if err := c.matchSubmitInputs(ctx, result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// submit sends one request to /tasks/create/submit and adds the response to result
+func (c *Client) submit(ctx context.Context, body io.Reader, contentType string, result *SubmitResult) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/tasks/create/submit", c.BaseURL), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.MakeRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 400, 500:
+		return fmt.Errorf("unable to submit: %s", errorMessage(resp))
+	default:
+		return fmt.Errorf("bad response code: %d", resp.StatusCode)
+	}
+
+	response := struct {
+		SubmitID int      `json:"submit_id"`
+		TaskIDs  []int    `json:"task_ids"`
+		Errors   []string `json:"errors"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("cuckoo: submit response marshalling error: %w", err)
+	}
+
+	result.SubmitIDs = append(result.SubmitIDs, response.SubmitID)
+	result.TaskIDs = append(result.TaskIDs, response.TaskIDs...)
+	result.Errors = append(result.Errors, response.Errors...)
+	return nil
+}
+
+// matchSubmitInputs looks up the target of every created task to map it back to its input
+func (c *Client) matchSubmitInputs(ctx context.Context, result *SubmitResult) error {
+	for _, taskID := range result.TaskIDs {
+		task, err := c.TasksView(ctx, taskID)
+		if err != nil {
+			return fmt.Errorf("error looking up submitted task %d: %w", taskID, err)
+		}
+
+		// Inputs sharing a name get a task each before any gets a second one
+		var match *SubmitInput
+		for _, input := range result.Inputs {
+			if !input.matches(task) {
+				continue
+			}
+			if match == nil || len(input.TaskIDs) < len(match.TaskIDs) {
+				match = input
+			}
+		}
+		if match != nil {
+			match.TaskIDs = append(match.TaskIDs, taskID)
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if task was created for this input
+func (i *SubmitInput) matches(task *Task) bool {
+	if i.URL != "" {
+		return task.Category == "url" && task.Target == i.URL
+	}
+	// Cuckoo stores files under a temporary path, only the base name is kept
+	return task.Category != "url" && path.Base(strings.ReplaceAll(task.Target, "\\", "/")) == i.Name
+}
+
+// multipartBody streams a multipart form made of fields followed by whatever writeFiles adds.
+//
+// The returned reader must be closed so the writing goroutine exits if the request is never sent.
+func multipartBody(fields [][2]string, writeFiles func(w *multipart.Writer) error) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+
+	go func() {
+		for _, field := range fields {
+			if err := w.WriteField(field[0], field[1]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if err := writeFiles(w); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+
+	return pr, w.FormDataContentType()
+}
+
+// decodeTaskID decodes the {"task_id": 1} response returned by the create endpoints
+func decodeTaskID(resp *http.Response) (int, error) {
+	response := struct {
+		TaskID *int `json:"task_id"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return -1, fmt.Errorf("cuckoo: create task response marshalling error: %w", err)
+	}
+	if response.TaskID == nil {
+		return -1, fmt.Errorf("cuckoo did not return a task id")
+	}
+
+	return *response.TaskID, nil
+}
+
+// errorMessage returns the message cuckoo sends along with an error response, or the raw body if there is none
+func errorMessage(resp *http.Response) string {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024)) // Limit reading incase response is massive for some reason
+	if err != nil {
+		return ""
+	}
+
+	message := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(body, &message); err == nil && message.Message != "" {
+		return message.Message
+	}
+	return strings.TrimSpace(string(body))
+}
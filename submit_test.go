@@ -0,0 +1,242 @@
+package cuckoo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTasksCreateFile(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/tasks/create/file" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := r.ParseMultipartForm(1024 * 1024); err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := map[string]string{
+			"package":         "exe",
+			"timeout":         "120",
+			"priority":        "2",
+			"options":         "free=yes,procmemdump=yes",
+			"tags":            "win7,x64",
+			"memory":          "1",
+			"enforce_timeout": "1",
+			"clock":           "03-04-2020 05:06:07",
+			"unique":          "1",
+		}
+		for key, value := range expected {
+			if r.FormValue(key) != value {
+				t.Errorf("field %s is %q, expected %q", key, r.FormValue(key), value)
+			}
+		}
+		if r.FormValue("owner") != "" {
+			t.Errorf("unset fields should not be sent")
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		content, _ := ioutil.ReadAll(file)
+		if header.Filename != "sample.exe" || string(content) != "MZ sample" {
+			t.Errorf("unexpected file %s: %q", header.Filename, content)
+		}
+
+		w.Write([]byte(`{"task_id": 42}`))
+	})
+
+	taskID, err := c.TasksCreateFile(context.Background(), "sample.exe", strings.NewReader("MZ sample"), &TaskOptions{
+		Package:        "exe",
+		Timeout:        120,
+		Priority:       2,
+		Options:        map[string]string{"procmemdump": "yes", "free": "yes"},
+		Tags:           []string{"win7", "x64"},
+		Memory:         true,
+		EnforceTimeout: true,
+		Clock:          time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC),
+		Unique:         true,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if taskID != 42 {
+		t.Errorf("expected task 42, got %d", taskID)
+	}
+}
+
+func TestTasksCreateFileDuplicate(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"message": "This file has already been submitted"}`))
+	})
+
+	_, err := c.TasksCreateFile(context.Background(), "sample.exe", strings.NewReader("MZ"), &TaskOptions{Unique: true})
+	if err == nil || !strings.Contains(err.Error(), "already been submitted") {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+}
+
+func TestTasksCreateURL(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/tasks/create/url" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.FormValue("url") != "http://example.com/login" {
+			t.Errorf("unexpected url %q", r.FormValue("url"))
+		}
+		if r.FormValue("package") != "ie" || r.FormValue("timeout") != "60" {
+			t.Errorf("options were not sent: %v", r.Form)
+		}
+		if r.FormValue("unique") != "" {
+			t.Errorf("unique should not be sent for urls")
+		}
+		w.Write([]byte(`{"task_id": 7}`))
+	})
+
+	taskID, err := c.TasksCreateURL(context.Background(), "http://example.com/login", &TaskOptions{Package: "ie", Timeout: 60, Unique: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if taskID != 7 {
+		t.Errorf("expected task 7, got %d", taskID)
+	}
+}
+
+func TestTasksCreateURLInvalid(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("invalid urls should not be sent to cuckoo")
+	})
+
+	for _, target := range []string{"ftp://example.com/file", "file:///etc/passwd", "example.com", "http://", "javascript:alert(1)"} {
+		_, err := c.TasksCreateURL(context.Background(), target, nil)
+		urlErr, ok := err.(*InvalidURLError)
+		if !ok {
+			t.Errorf("%s: expected *InvalidURLError, got %v", target, err)
+			continue
+		}
+		if urlErr.URL != target {
+			t.Errorf("error has url %q, expected %q", urlErr.URL, target)
+		}
+	}
+}
+
+func TestTasksCreateSubmit(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/create/submit":
+			if err := r.ParseMultipartForm(1024 * 1024); err == nil {
+				if len(r.MultipartForm.File["files"]) != 2 {
+					t.Errorf("expected 2 files, got %d", len(r.MultipartForm.File["files"]))
+				}
+				w.Write([]byte(`{"submit_id": 1, "task_ids": [10, 11], "errors": []}`))
+				return
+			}
+			if r.FormValue("strings") != "http://a.example\nhttps://b.example/x" {
+				t.Errorf("unexpected strings %q", r.FormValue("strings"))
+			}
+			w.Write([]byte(`{"submit_id": 2, "task_ids": [12, 13], "errors": []}`))
+		case "/tasks/view/10":
+			w.Write([]byte(`{"task": {"id": 10, "category": "file", "target": "/tmp/cuckoo-tmp/upload_abc/one.exe"}}`))
+		case "/tasks/view/11":
+			w.Write([]byte(`{"task": {"id": 11, "category": "file", "target": "/tmp/cuckoo-tmp/upload_def/two.doc"}}`))
+		case "/tasks/view/12":
+			w.Write([]byte(`{"task": {"id": 12, "category": "url", "target": "http://a.example"}}`))
+		case "/tasks/view/13":
+			w.Write([]byte(`{"task": {"id": 13, "category": "url", "target": "https://b.example/x"}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			w.WriteHeader(404)
+		}
+	})
+
+	result, err := c.TasksCreateSubmit(context.Background(), []*SubmitFile{
+		{Name: "one.exe", Reader: strings.NewReader("MZ one")},
+		{Name: "two.doc", Reader: strings.NewReader("doc two")},
+	}, []string{"http://a.example", "https://b.example/x"}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result.SubmitIDs) != 2 || len(result.TaskIDs) != 4 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	expected := map[string]int{"one.exe": 10, "two.doc": 11, "http://a.example": 12, "https://b.example/x": 13}
+	for _, input := range result.Inputs {
+		key := input.Name + input.URL
+		if len(input.TaskIDs) != 1 || input.TaskIDs[0] != expected[key] {
+			t.Errorf("input %s mapped to %v, expected %d", key, input.TaskIDs, expected[key])
+		}
+	}
+}
+
+func TestTasksCreateSubmitEmpty(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("nothing should be sent")
+	})
+
+	if _, err := c.TasksCreateSubmit(context.Background(), nil, nil, nil); err == nil {
+		t.Errorf("expected an error for an empty submission")
+	}
+	if _, err := c.TasksCreateSubmit(context.Background(), nil, []string{"ftp://example.com"}, nil); err == nil {
+		t.Errorf("expected an error for an invalid url")
+	}
+}
+
+func TestTasksCreateSubmitPartial(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/create/submit":
+			if err := r.ParseMultipartForm(1024 * 1024); err == nil {
+				w.Write([]byte(`{"submit_id": 1, "task_ids": [10, 11, 12], "errors": []}`))
+				return
+			}
+			w.WriteHeader(500)
+			w.Write([]byte(`{"message": "database is locked"}`))
+		case "/tasks/view/10", "/tasks/view/11":
+			fmt.Fprintf(w, `{"task": {"id": %s, "category": "file", "target": "/tmp/upload/sample.exe"}}`, path.Base(r.URL.Path))
+		case "/tasks/view/12":
+			w.Write([]byte(`{"task": {"id": 12, "category": "file", "target": "/tmp/upload/other.exe"}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	files := func() []*SubmitFile {
+		return []*SubmitFile{
+			{Name: "sample.exe", Reader: strings.NewReader("one")},
+			{Name: "sample.exe", Reader: strings.NewReader("two")},
+			{Name: "other.exe", Reader: strings.NewReader("three")},
+		}
+	}
+
+	// The files were submitted before the urls failed, their tasks must not be lost
+	result, err := c.TasksCreateSubmit(context.Background(), files(), []string{"http://a.example"}, nil)
+	if err == nil {
+		t.Fatal("expected the error of the url submission")
+	}
+	if result == nil || !reflect.DeepEqual(result.TaskIDs, []int{10, 11, 12}) {
+		t.Fatalf("expected the created tasks with the error, got %+v", result)
+	}
+
+	result, err = c.TasksCreateSubmit(context.Background(), files(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range [][]int{{10}, {11}, {12}} {
+		if !reflect.DeepEqual(result.Inputs[i].TaskIDs, expected) {
+			t.Errorf("input %d mapped to %v, expected %v", i, result.Inputs[i].TaskIDs, expected)
+		}
+	}
+}
@@ -22,12 +22,16 @@ var (
 	StatusRunning   TaskStatus = "running"
 	StatusCompleted TaskStatus = "completed"
 	StatusReported  TaskStatus = "reported"
+
+	StatusFailedAnalysis   TaskStatus = "failed_analysis"
+	StatusFailedProcessing TaskStatus = "failed_processing"
+	StatusFailedReporting  TaskStatus = "failed_reporting"
 )
 
 // ErrTaskNotFound is returned when the task is not found
 var ErrTaskNotFound = fmt.Errorf("task not found")
 
-// TaskStatus is a possible task status from cuckoo (pending, running, completed, reported)
+// TaskStatus is a possible task status from cuckoo (pending, running, completed, reported, or one of the failed statuses)
 type TaskStatus string
 
 // Task is a task in cuckoo
@@ -251,15 +255,51 @@ func (c *Client) TasksDelete(ctx context.Context, taskID int) (err error) {
 	default:
 		return fmt.Errorf("bad response code: %d", resp.StatusCode)
 	}
+	c.Cache.InvalidateTask(taskID)
 
 	return nil
 }
 
+// ReportFormat is a format a task report can be downloaded in
+type ReportFormat string
+
+// Report formats served by cuckoo.  The archive formats are returned as a tarball, see TasksReportArchive.
+const (
+	ReportJSON ReportFormat = "json"
+	ReportHTML ReportFormat = "html"
+	// Everything in the analysis folder except the full memory dump
+	ReportAll ReportFormat = "all"
+	// The files dropped during the analysis
+	ReportDropped ReportFormat = "dropped"
+	// The files collected by the analysis package
+	ReportPackageFiles ReportFormat = "package_files"
+)
+
 // TasksReport Returns the report associated with the specified task ID.
 //
 // It gets the reports in JSON format by default.  The report is very large and dynamic so it returns the http reader
 func (c *Client) TasksReport(ctx context.Context, taskID int) (report io.ReadCloser, err error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/report/%d", c.BaseURL, taskID), nil)
+	return c.TasksReportFormat(ctx, taskID, ReportJSON)
+}
+
+// TasksReportFormat Returns the report associated with the specified task ID in the given format.
+//
+// The archive formats (all, dropped and package_files) return a tarball, use TasksReportArchive to read them.
+func (c *Client) TasksReportFormat(ctx context.Context, taskID int, format ReportFormat) (report io.ReadCloser, err error) {
+	cacheName, cacheable := reportCacheName(taskID, format)
+	if cacheable {
+		if cached, ok := c.Cache.get(cacheName); ok {
+			return cached, nil
+		}
+	}
+
+	URL := fmt.Sprintf("%s/tasks/report/%d/%s", c.BaseURL, taskID, format)
+	if format.IsArchive() {
+		// Cuckoo defaults to bzip2 which is a lot slower to decompress
+		URL += "?tar=gz"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", URL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -279,6 +319,9 @@ func (c *Client) TasksReport(ctx context.Context, taskID int) (report io.ReadClo
 		return nil, fmt.Errorf("bad response code: %d", resp.StatusCode)
 	}
 
+	if cacheable {
+		return c.putTask(ctx, taskID, cacheName, resp.Body), nil
+	}
 	return resp.Body, nil
 }
 
@@ -288,6 +331,11 @@ func (c *Client) TasksReport(ctx context.Context, taskID int) (report io.ReadClo
 //
 // It will return a reader from the API reading the ZIP data of the screenshot(s).  You can use the zip package to read the files
 func (c *Client) TasksScreenshots(ctx context.Context, taskID, screenshotNumber int) (zippedData io.ReadCloser, err error) {
+	cacheName := screenshotsCacheName(taskID, screenshotNumber)
+	if cached, ok := c.Cache.get(cacheName); ok {
+		return cached, nil
+	}
+
 	var req *http.Request
 	if screenshotNumber == -1 {
 		req, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/tasks/screenshots/%d", c.BaseURL, taskID), nil)
@@ -312,7 +360,7 @@ func (c *Client) TasksScreenshots(ctx context.Context, taskID, screenshotNumber
 		return nil, fmt.Errorf("bad response code: %d", resp.StatusCode)
 	}
 
-	return resp.Body, nil
+	return c.putTask(ctx, taskID, cacheName, resp.Body), nil
 }
 
 // TasksReReport Re-run reporting for task associated with the specified task ID.
@@ -346,6 +394,7 @@ func (c *Client) TasksReReport(ctx context.Context, taskID int) (err error) {
 	if !response.Success {
 		return fmt.Errorf("cuckoo returned non success")
 	}
+	c.Cache.InvalidateTask(taskID)
 
 	return nil
 }
@@ -0,0 +1,439 @@
+// Package timeline flattens the API calls of the monitored processes into a single time-ordered timeline
+package timeline
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+)
+
+// Format is an output format of the timeline
+type Format string
+
+// Output formats
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// defaultRunSize is the default number of calls sorted in memory before they are spooled to disk
+const defaultRunSize = 100000
+
+// Event is an API call in the timeline
+type Event struct {
+	Time        time.Time              `json:"time"`
+	PID         int                    `json:"pid"`
+	PPID        int                    `json:"ppid"`
+	ProcessName string                 `json:"process_name"`
+	TID         int                    `json:"tid"`
+	Category    string                 `json:"category"`
+	API         string                 `json:"api"`
+	Status      bool                   `json:"status"`
+	ReturnValue interface{}            `json:"return_value"`
+	Arguments   map[string]interface{} `json:"arguments"`
+}
+
+// Filter selects the calls in the timeline.  Empty fields select everything.
+type Filter struct {
+	// Only calls made by these processes
+	PIDs []int
+	// Only calls made by processes with these names, compared case insensitively
+	ProcessNames []string
+	// Only calls in these API categories, e.g. "network" or "registry"
+	Categories []string
+	// Only calls to these APIs, e.g. "NtCreateFile"
+	APIs []string
+}
+
+// Options control how the timeline is built
+type Options struct {
+	Filter *Filter
+	// Number of calls sorted in memory before they are spooled to a temporary file, defaults to 100000
+	RunSize int
+	// Directory of the temporary files, defaults to os.TempDir
+	TempDir string
+}
+
+// Walk Reads a JSON report and calls fn for each selected API call in time order.
+//
+// The report is streamed and the calls are sorted in runs of at most opts.RunSize, spooled to temporary files
+// and merged, so memory use does not grow with the number of calls.  Calls made at the same time keep the
+// order of the report.
+func Walk(r io.Reader, opts *Options, fn func(event *Event) error) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	t := &timeline{opts: opts, filter: opts.Filter, runSize: opts.RunSize}
+	if t.filter == nil {
+		t.filter = &Filter{}
+	}
+	if t.runSize <= 0 {
+		t.runSize = defaultRunSize
+	}
+	defer t.cleanup()
+
+	err := cuckoo.WalkReport(r, map[string]cuckoo.SectionHandler{
+		"behavior.processes[]": t.process,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.emit(fn)
+}
+
+// Export Streams the selected API calls of a JSON report to w in time order, see Walk.
+func Export(r io.Reader, w io.Writer, format Format, opts *Options) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		err := Walk(r, opts, func(event *Event) error {
+			return cw.Write(event.csvRecord())
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatJSONL:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		err := Walk(r, opts, func(event *Event) error {
+			return enc.Encode(event)
+		})
+		if err != nil {
+			return err
+		}
+		return bw.Flush()
+	default:
+		return fmt.Errorf("unknown timeline format %q", format)
+	}
+}
+
+// ExportTask Streams the timeline of the specified task ID to w, see Export.
+func ExportTask(ctx context.Context, c *cuckoo.Client, taskID int, w io.Writer, format Format, opts *Options) error {
+	body, err := c.TasksReport(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return Export(body, w, format, opts)
+}
+
+// csvHeader is the first record of the CSV output
+var csvHeader = []string{"time", "pid", "ppid", "process_name", "tid", "category", "api", "status", "return_value", "arguments"}
+
+// csvRecord returns the event as a CSV record, the arguments are JSON encoded
+func (e *Event) csvRecord() []string {
+	arguments, _ := json.Marshal(e.Arguments)
+	return []string{
+		e.Time.UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(e.PID),
+		strconv.Itoa(e.PPID),
+		e.ProcessName,
+		strconv.Itoa(e.TID),
+		e.Category,
+		e.API,
+		strconv.FormatBool(e.Status),
+		returnValue(e.ReturnValue),
+		string(arguments),
+	}
+}
+
+// returnValue formats a return value for CSV, numbers are written without an exponent
+func returnValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
+
+// processInfo identifies the process of spooled calls
+type processInfo struct {
+	PID         int
+	PPID        int
+	ProcessName string
+}
+
+// spooled is a call as it is sorted and written to the temporary files
+type spooled struct {
+	Seq     int64       `json:"s"`
+	Process int         `json:"p"`
+	Call    cuckoo.Call `json:"c"`
+}
+
+// timeline holds the state of a single Walk
+type timeline struct {
+	opts      *Options
+	filter    *Filter
+	runSize   int
+	processes []*processInfo
+	buffer    []*spooled
+	seq       int64
+	runs      []*os.File
+}
+
+// process reads one element of behavior.processes, the process fields may come before or after its calls
+func (t *timeline) process(path string, dec *json.Decoder) error {
+	info := &processInfo{}
+	index := len(t.processes)
+	t.processes = append(t.processes, info)
+	known := map[string]bool{}
+
+	return cuckoo.WalkValue(dec, map[string]cuckoo.SectionHandler{
+		"pid": func(path string, dec *json.Decoder) error {
+			known["pid"] = true
+			return dec.Decode(&info.PID)
+		},
+		"ppid": func(path string, dec *json.Decoder) error {
+			return dec.Decode(&info.PPID)
+		},
+		"process_name": func(path string, dec *json.Decoder) error {
+			known["process_name"] = true
+			return dec.Decode(&info.ProcessName)
+		},
+		"calls[]": func(path string, dec *json.Decoder) error {
+			// Skip the calls of processes already known to be filtered out
+			if (known["pid"] && !t.filter.matchPID(info.PID)) || (known["process_name"] && !t.filter.matchName(info.ProcessName)) {
+				return dec.Decode(&struct{}{})
+			}
+
+			s := &spooled{Seq: t.seq, Process: index}
+			t.seq++
+			if err := dec.Decode(&s.Call); err != nil {
+				return err
+			}
+			if !t.filter.matchCall(&s.Call) {
+				return nil
+			}
+			t.buffer = append(t.buffer, s)
+			if len(t.buffer) >= t.runSize {
+				return t.spool()
+			}
+			return nil
+		},
+	})
+}
+
+// sortBuffer sorts the buffered calls by time, then by their order in the report
+func (t *timeline) sortBuffer() {
+	sort.Slice(t.buffer, func(i, j int) bool {
+		return before(t.buffer[i], t.buffer[j])
+	})
+}
+
+func before(a, b *spooled) bool {
+	if a.Call.Time != b.Call.Time {
+		return a.Call.Time < b.Call.Time
+	}
+	return a.Seq < b.Seq
+}
+
+// spool writes the buffered calls to a temporary file as a sorted run
+func (t *timeline) spool() error {
+	t.sortBuffer()
+
+	f, err := ioutil.TempFile(t.opts.TempDir, "cuckoo-timeline-")
+	if err != nil {
+		return err
+	}
+	t.runs = append(t.runs, f)
+
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, s := range t.buffer {
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	t.buffer = t.buffer[:0]
+	return nil
+}
+
+// emit calls fn for every selected call in time order, merging the spooled runs
+func (t *timeline) emit(fn func(event *Event) error) error {
+	if len(t.runs) == 0 {
+		t.sortBuffer()
+		for _, s := range t.buffer {
+			if err := t.send(s, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(t.buffer) > 0 {
+		if err := t.spool(); err != nil {
+			return err
+		}
+	}
+
+	h := &runHeap{}
+	for _, f := range t.runs {
+		r := &run{dec: json.NewDecoder(bufio.NewReader(f))}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		r := h.runs[0]
+		if err := t.send(r.current, fn); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// send converts a spooled call into an event, applies the process filter and calls fn
+func (t *timeline) send(s *spooled, fn func(event *Event) error) error {
+	info := t.processes[s.Process]
+	if !t.filter.matchPID(info.PID) || !t.filter.matchName(info.ProcessName) {
+		return nil
+	}
+
+	return fn(&Event{
+		Time:        callTime(s.Call.Time),
+		PID:         info.PID,
+		PPID:        info.PPID,
+		ProcessName: info.ProcessName,
+		TID:         s.Call.TID,
+		Category:    s.Call.Category,
+		API:         s.Call.API,
+		Status:      bool(s.Call.Status),
+		ReturnValue: s.Call.ReturnValue,
+		Arguments:   s.Call.Arguments,
+	})
+}
+
+// cleanup removes the temporary files
+func (t *timeline) cleanup() {
+	for _, f := range t.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// callTime converts the epoch time of a call, keeping microsecond precision
+func callTime(epoch float64) time.Time {
+	seconds := math.Floor(epoch)
+	micros := math.Round((epoch - seconds) * 1e6)
+	return time.Unix(int64(seconds), int64(micros)*int64(time.Microsecond)).UTC()
+}
+
+// run is a sorted temporary file being merged
+type run struct {
+	dec     *json.Decoder
+	current *spooled
+}
+
+// next reads the next call of the run, returning false at the end
+func (r *run) next() (bool, error) {
+	s := &spooled{}
+	if err := r.dec.Decode(s); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	r.current = s
+	return true, nil
+}
+
+// runHeap orders the runs by their current call
+type runHeap struct {
+	runs []*run
+}
+
+func (h *runHeap) Len() int           { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool { return before(h.runs[i].current, h.runs[j].current) }
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
+
+func (f *Filter) matchPID(pid int) bool {
+	if len(f.PIDs) == 0 {
+		return true
+	}
+	for _, p := range f.PIDs {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchName(name string) bool {
+	if len(f.ProcessNames) == 0 {
+		return true
+	}
+	for _, n := range f.ProcessNames {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchCall(call *cuckoo.Call) bool {
+	return matchString(f.Categories, call.Category) && matchString(f.APIs, call.API)
+}
+
+func matchString(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
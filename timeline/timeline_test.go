@@ -0,0 +1,157 @@
+package timeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/godaddy/go-cukoo/internal/testutil"
+)
+
+func loadTestData(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("../testdata/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func collect(t *testing.T, report string, opts *Options) []*Event {
+	events := []*Event{}
+	err := Walk(strings.NewReader(report), opts, func(event *Event) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func apis(events []*Event) []string {
+	names := []string{}
+	for _, event := range events {
+		names = append(names, event.API)
+	}
+	return names
+}
+
+func TestWalk(t *testing.T) {
+	events := collect(t, string(loadTestData(t)), nil)
+
+	expected := []string{"NtCreateFile", "CreateRemoteThread", "RegSetValueExW", "InternetOpenA", "NtClose"}
+	if !reflect.DeepEqual(apis(events), expected) {
+		t.Errorf("expected %v, got %v", expected, apis(events))
+	}
+	last := events[4]
+	if last.PID != 2100 || last.PPID != 2048 || last.ProcessName != "cmd.exe" || last.TID != 2104 || !last.Status {
+		t.Errorf("unexpected event %+v", last)
+	}
+	if last.Time.UnixNano() != 1581439604500000000 {
+		t.Errorf("unexpected time %v", last.Time)
+	}
+}
+
+// interleaved has two processes whose calls overlap in time, with the pid of the second after its calls
+const interleaved = `{"behavior": {"processes": [
+	{"pid": 1, "process_name": "a.exe", "calls": [
+		{"api": "A1", "category": "file", "time": 1.0},
+		{"api": "A3", "category": "network", "time": 3.0},
+		{"api": "A5", "category": "file", "time": 5.0},
+		{"api": "A2", "category": "file", "time": 2.0}
+	]},
+	{"calls": [
+		{"api": "B2", "category": "registry", "time": 2.0},
+		{"api": "B4", "category": "file", "time": 4.0}
+	], "process_name": "b.exe", "pid": 2, "ppid": 1}
+]}}`
+
+func TestWalkMerge(t *testing.T) {
+	expected := []string{"A1", "A2", "B2", "A3", "B4", "A5"}
+	for _, runSize := range []int{0, 1, 2, 3} {
+		events := collect(t, interleaved, &Options{RunSize: runSize, TempDir: t.TempDir()})
+		if !reflect.DeepEqual(apis(events), expected) {
+			t.Errorf("run size %d: expected %v, got %v", runSize, expected, apis(events))
+		}
+	}
+}
+
+func TestWalkFilter(t *testing.T) {
+	for _, test := range []struct {
+		filter   *Filter
+		expected []string
+	}{
+		{&Filter{PIDs: []int{2}}, []string{"B2", "B4"}},
+		{&Filter{ProcessNames: []string{"A.EXE"}}, []string{"A1", "A2", "A3", "A5"}},
+		{&Filter{Categories: []string{"file"}}, []string{"A1", "A2", "B4", "A5"}},
+		{&Filter{APIs: []string{"A3", "B4"}}, []string{"A3", "B4"}},
+		{&Filter{PIDs: []int{1}, Categories: []string{"network", "registry"}}, []string{"A3"}},
+	} {
+		events := collect(t, interleaved, &Options{Filter: test.filter, RunSize: 2, TempDir: t.TempDir()})
+		if !reflect.DeepEqual(apis(events), test.expected) {
+			t.Errorf("filter %+v: expected %v, got %v", test.filter, test.expected, apis(events))
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := Export(bytes.NewReader(loadTestData(t)), b, FormatCSV, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 || !reflect.DeepEqual(records[0], csvHeader) {
+		t.Fatalf("unexpected records %v", records)
+	}
+	expected := []string{"2020-02-11T16:46:43.75Z", "2048", "1988", "invoice.exe", "2052", "network", "InternetOpenA", "true", "13369348",
+		`{"user_agent":"Mozilla/4.0 (compatible; MSIE 8.0)"}`}
+	if !reflect.DeepEqual(records[4], expected) {
+		t.Errorf("expected %q, got %q", expected, records[4])
+	}
+}
+
+func TestExportTaskJSONL(t *testing.T) {
+	data := loadTestData(t)
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tasks/report/42/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	})
+
+	b := &bytes.Buffer{}
+	opts := &Options{Filter: &Filter{Categories: []string{"registry"}}}
+	err := ExportTask(context.Background(), c, 42, b, FormatJSONL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %q", b.String())
+	}
+	event := &Event{}
+	if err := json.Unmarshal([]byte(lines[0]), event); err != nil {
+		t.Fatal(err)
+	}
+	if event.API != "RegSetValueExW" || event.PID != 2048 {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if err := Export(strings.NewReader("{}"), ioutil.Discard, "xml", nil); err == nil {
+		t.Errorf("expected an error")
+	}
+}
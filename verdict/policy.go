@@ -0,0 +1,164 @@
+package verdict
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+// Policy is an ordered list of rules deciding the verdict of an analysis.
+//
+// Policies are usually loaded from JSON with LoadPolicy, e.g.
+//
+//	{
+//	  "default": "clean",
+//	  "rules": [
+//	    {"name": "high score", "verdict": "malicious", "score": {"min": 7}},
+//	    {"name": "injection", "verdict": "suspicious", "signature": {"names": ["injection_*"], "min_severity": 3}},
+//	    {"name": "known c2", "verdict": "malicious", "ioc": {"types": ["ip"], "values": ["185.100.87.202"]}},
+//	    {"name": "failed analysis", "verdict": "inconclusive", "errors": {}}
+//	  ]
+//	}
+type Policy struct {
+	Rules []*Rule `json:"rules"`
+	// Verdict when no rule matches, defaults to clean
+	Default Verdict `json:"default,omitempty"`
+	// Indicators never matched by IOC conditions, on top of ioc.DefaultAllowlist
+	Allowlist *ioc.Allowlist `json:"allowlist,omitempty"`
+}
+
+// Rule yields its verdict when all of its conditions match.  A rule must have at least one condition.
+type Rule struct {
+	Name    string  `json:"name"`
+	Verdict Verdict `json:"verdict"`
+
+	Score     *ScoreCondition     `json:"score,omitempty"`
+	Signature *SignatureCondition `json:"signature,omitempty"`
+	IOC       *IOCCondition       `json:"ioc,omitempty"`
+	Errors    *ErrorsCondition    `json:"errors,omitempty"`
+}
+
+// ScoreCondition matches the score of the analysis.  Nil bounds are open.
+type ScoreCondition struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// SignatureCondition matches the signatures of the analysis
+type SignatureCondition struct {
+	// Signature names, shell patterns as in path.Match (e.g. "injection_*").  Empty matches any signature.
+	Names []string `json:"names,omitempty"`
+	// Malware families, empty matches any family
+	Families []string `json:"families,omitempty"`
+	// Minimum severity of the signature
+	MinSeverity int `json:"min_severity,omitempty"`
+	// Minimum number of signatures matching, defaults to 1
+	MinCount int `json:"min_count,omitempty"`
+}
+
+// IOCCondition matches the indicators extracted from the report, see ioc.Extract
+type IOCCondition struct {
+	// Indicator types, empty matches any type.  File indicators match any of the hashes of a dropped file.
+	Types []ioc.Type `json:"types,omitempty"`
+	// Exact values, compared case insensitively
+	Values []string `json:"values,omitempty"`
+	// Regular expressions.  Without values or patterns any indicator of the types matches.
+	Patterns []string `json:"patterns,omitempty"`
+
+	patterns []*regexp.Regexp
+}
+
+// ErrorsCondition matches the errors of the task and of the analysis
+type ErrorsCondition struct {
+	// Regular expressions, empty matches any error
+	Patterns []string `json:"patterns,omitempty"`
+
+	patterns []*regexp.Regexp
+}
+
+// LoadPolicy Decodes a policy from JSON and validates it.  Unknown fields are rejected to catch typos.
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	policy := &Policy{}
+	if err := dec.Decode(policy); err != nil {
+		return nil, fmt.Errorf("verdict: policy decoding error: %w", err)
+	}
+	if err := policy.Compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// LoadPolicyFile Loads a policy from a JSON file, see LoadPolicy
+func LoadPolicyFile(filename string) (*Policy, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadPolicy(f)
+}
+
+// Compile Validates the policy and compiles its patterns.  LoadPolicy calls it.  Policies built in code should
+// call it to catch invalid rules, the patterns of a policy that was not compiled are compiled by each evaluation and
+// the invalid ones never match.
+func (p *Policy) Compile() error {
+	if p.Default != "" && !p.Default.valid() {
+		return fmt.Errorf("verdict: invalid default verdict %q", p.Default)
+	}
+
+	for i, rule := range p.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if !rule.Verdict.valid() {
+			return fmt.Errorf("verdict: rule %s: invalid verdict %q", name, rule.Verdict)
+		}
+		if rule.Score == nil && rule.Signature == nil && rule.IOC == nil && rule.Errors == nil {
+			return fmt.Errorf("verdict: rule %s has no condition", name)
+		}
+
+		if rule.Signature != nil {
+			for _, pattern := range rule.Signature.Names {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("verdict: rule %s: invalid signature pattern %q: %w", name, pattern, err)
+				}
+			}
+		}
+
+		var err error
+		if rule.IOC != nil {
+			if rule.IOC.patterns, err = compilePatterns(rule.IOC.Patterns); err != nil {
+				return fmt.Errorf("verdict: rule %s: %w", name, err)
+			}
+		}
+		if rule.Errors != nil {
+			if rule.Errors.patterns, err = compilePatterns(rule.Errors.Patterns); err != nil {
+				return fmt.Errorf("verdict: rule %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
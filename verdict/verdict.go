@@ -0,0 +1,321 @@
+// Package verdict turns cuckoo analysis results into a verdict with a declarative policy
+package verdict
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+// Verdict is the conclusion of an analysis
+type Verdict string
+
+// Verdicts, from the strongest to the weakest.  When several rules match the strongest verdict wins.
+const (
+	Malicious    Verdict = "malicious"
+	Suspicious   Verdict = "suspicious"
+	Inconclusive Verdict = "inconclusive"
+	Clean        Verdict = "clean"
+)
+
+// strength orders the verdicts
+var strength = map[Verdict]int{
+	Malicious:    4,
+	Suspicious:   3,
+	Inconclusive: 2,
+	Clean:        1,
+}
+
+func (v Verdict) valid() bool {
+	return strength[v] > 0
+}
+
+// Result is the verdict of an analysis and the rules that led to it
+type Result struct {
+	Verdict Verdict `json:"verdict"`
+	// Every rule that matched, in policy order
+	Reasons []*Reason `json:"reasons"`
+}
+
+// Reason is a rule that matched
+type Reason struct {
+	Rule    string  `json:"rule"`
+	Verdict Verdict `json:"verdict"`
+	// What matched, e.g. "score 6.4" or "signature injection_createremotethread (severity 3)"
+	Evidence []string `json:"evidence"`
+}
+
+// Evaluate Applies the policy to a task and its parsed report.
+//
+// task may be nil when only the report is available, e.g. a saved report, in which case only the errors of the
+// report are considered.
+func (p *Policy) Evaluate(task *cuckoo.Task, report *cuckoo.Report) *Result {
+	return p.evaluate(task, report, &ioc.Options{Allowlist: p.Allowlist})
+}
+
+// EvaluateTask Fetches a task and its report from cuckoo and applies the policy.  IOC conditions leave out the
+// addresses of the machine, see ioc.WithReportMachine.
+func (p *Policy) EvaluateTask(ctx context.Context, c *cuckoo.Client, taskID int) (*Result, error) {
+	task, err := c.TasksView(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	report, err := c.TasksReportParsed(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := ioc.WithReportMachine(ctx, c, report, &ioc.Options{Allowlist: p.Allowlist})
+	if err != nil {
+		return nil, err
+	}
+	return p.evaluate(task, report, opts), nil
+}
+
+func (p *Policy) evaluate(task *cuckoo.Task, report *cuckoo.Report, iocOpts *ioc.Options) *Result {
+	e := &evaluation{task: task, report: report, iocOpts: iocOpts}
+
+	result := &Result{Verdict: p.Default, Reasons: []*Reason{}}
+	if result.Verdict == "" {
+		result.Verdict = Clean
+	}
+
+	matched := false
+	for i, rule := range p.Rules {
+		evidence, ok := e.match(rule)
+		if !ok {
+			continue
+		}
+
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		result.Reasons = append(result.Reasons, &Reason{Rule: name, Verdict: rule.Verdict, Evidence: evidence})
+		if !matched || strength[rule.Verdict] > strength[result.Verdict] {
+			result.Verdict = rule.Verdict
+			matched = true
+		}
+	}
+
+	return result
+}
+
+// evaluation holds what a single evaluation derives from the report, so it is only computed once
+type evaluation struct {
+	task    *cuckoo.Task
+	report  *cuckoo.Report
+	iocOpts *ioc.Options
+	iocs    *ioc.Set
+	// Patterns of a policy that was not compiled, by their joined source
+	compiled map[string][]*regexp.Regexp
+}
+
+// match returns the evidence of a rule if all its conditions match
+func (e *evaluation) match(rule *Rule) ([]string, bool) {
+	evidence := []string{}
+	for _, condition := range []func(rule *Rule) []string{e.score, e.signatures, e.indicators, e.errors} {
+		found := condition(rule)
+		if found == nil {
+			return nil, false
+		}
+		evidence = append(evidence, found...)
+	}
+	return evidence, true
+}
+
+// score matches the score condition of a rule.  Conditions return nil when they do not match, and an empty
+// list when the rule has no such condition.
+func (e *evaluation) score(rule *Rule) []string {
+	c := rule.Score
+	if c == nil {
+		return []string{}
+	}
+	if e.report.Info == nil {
+		return nil
+	}
+
+	score := e.report.Info.Score
+	if (c.Min != nil && score < *c.Min) || (c.Max != nil && score > *c.Max) {
+		return nil
+	}
+	return []string{fmt.Sprintf("score %g", score)}
+}
+
+func (e *evaluation) signatures(rule *Rule) []string {
+	c := rule.Signature
+	if c == nil {
+		return []string{}
+	}
+
+	evidence := []string{}
+	for _, signature := range e.report.Signatures {
+		if signature.Severity < c.MinSeverity || !matchName(c.Names, signature.Name) || !matchFamily(c.Families, signature.Families) {
+			continue
+		}
+		evidence = append(evidence, fmt.Sprintf("signature %s (severity %d)", signature.Name, signature.Severity))
+	}
+
+	minCount := c.MinCount
+	if minCount < 1 {
+		minCount = 1
+	}
+	if len(evidence) < minCount {
+		return nil
+	}
+	return evidence
+}
+
+func (e *evaluation) indicators(rule *Rule) []string {
+	c := rule.IOC
+	if c == nil {
+		return []string{}
+	}
+	if e.iocs == nil {
+		e.iocs = ioc.Extract(e.report, e.iocOpts)
+	}
+
+	evidence := []string{}
+	patterns := e.regexps(c.Patterns, c.patterns)
+	add := func(t ioc.Type, value string) bool {
+		if !c.matchType(t) || !c.matchValue(value, patterns) {
+			return false
+		}
+		evidence = append(evidence, fmt.Sprintf("%s %s", t, value))
+		return true
+	}
+	for _, indicator := range e.iocs.All() {
+		if indicator.Type != ioc.TypeFile {
+			add(indicator.Type, indicator.Value)
+		}
+	}
+	for _, file := range e.iocs.DroppedFiles {
+		for _, hash := range []string{file.SHA256, file.SHA1, file.MD5, file.SHA512} {
+			if hash != "" && add(ioc.TypeFile, hash) {
+				break
+			}
+		}
+	}
+
+	if len(evidence) == 0 {
+		return nil
+	}
+	return evidence
+}
+
+func (e *evaluation) errors(rule *Rule) []string {
+	c := rule.Errors
+	if c == nil {
+		return []string{}
+	}
+
+	messages := []string{}
+	if e.task != nil {
+		for _, taskError := range e.task.Errors {
+			messages = append(messages, fmt.Sprint(taskError))
+		}
+	}
+	if e.report.Debug != nil {
+		messages = append(messages, e.report.Debug.Errors...)
+	}
+
+	evidence := []string{}
+	patterns := e.regexps(c.Patterns, c.patterns)
+	for _, message := range messages {
+		if len(c.Patterns) == 0 || matchAny(patterns, message) {
+			evidence = append(evidence, "error "+message)
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return evidence
+}
+
+func (c *IOCCondition) matchType(t ioc.Type) bool {
+	if len(c.Types) == 0 {
+		return true
+	}
+	for _, allowed := range c.Types {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *IOCCondition) matchValue(value string, patterns []*regexp.Regexp) bool {
+	if len(c.Values) == 0 && len(c.Patterns) == 0 {
+		return true
+	}
+	for _, v := range c.Values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return matchAny(patterns, value)
+}
+
+// regexps returns the compiled patterns of a condition.  When the policy was not compiled they are compiled for
+// this evaluation, leaving out the invalid ones so they never match.
+func (e *evaluation) regexps(patterns []string, compiled []*regexp.Regexp) []*regexp.Regexp {
+	if len(compiled) == len(patterns) {
+		return compiled
+	}
+	key := strings.Join(patterns, "\x00")
+	if regexps, ok := e.compiled[key]; ok {
+		return regexps
+	}
+
+	regexps := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			regexps = append(regexps, re)
+		}
+	}
+	if e.compiled == nil {
+		e.compiled = map[string][]*regexp.Regexp{}
+	}
+	e.compiled[key] = regexps
+	return regexps
+}
+
+func matchName(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFamily(wanted, families []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, family := range families {
+		for _, w := range wanted {
+			if strings.EqualFold(w, family) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
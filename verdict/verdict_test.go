@@ -0,0 +1,169 @@
+package verdict
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	cuckoo "github.com/godaddy/go-cukoo"
+	"github.com/godaddy/go-cukoo/internal/testutil"
+	"github.com/godaddy/go-cukoo/ioc"
+)
+
+func loadTestPolicy(t *testing.T) *Policy {
+	policy, err := LoadPolicyFile("testdata/policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func ruleNames(result *Result) []string {
+	names := []string{}
+	for _, reason := range result.Reasons {
+		names = append(names, reason.Rule)
+	}
+	return names
+}
+
+func TestEvaluate(t *testing.T) {
+	result := loadTestPolicy(t).Evaluate(nil, testutil.LoadReport(t))
+
+	if result.Verdict != Malicious {
+		t.Errorf("expected malicious, got %s", result.Verdict)
+	}
+	expected := []string{"medium score", "injection", "known family", "known c2", "known dropper"}
+	if !reflect.DeepEqual(ruleNames(result), expected) {
+		t.Errorf("expected rules %v, got %v", expected, ruleNames(result))
+	}
+
+	c2 := result.Reasons[3]
+	if !reflect.DeepEqual(c2.Evidence, []string{"ip 185.100.87.202", "domain evil.example.com"}) {
+		t.Errorf("unexpected evidence %v", c2.Evidence)
+	}
+	if result.Reasons[0].Evidence[0] != "score 6.4" {
+		t.Errorf("unexpected evidence %v", result.Reasons[0].Evidence)
+	}
+}
+
+func TestEvaluateVerdicts(t *testing.T) {
+	policy := loadTestPolicy(t)
+
+	// Nothing matches
+	report := &cuckoo.Report{Info: &cuckoo.ReportInfo{Score: 0.5}}
+	if result := policy.Evaluate(nil, report); result.Verdict != Clean || len(result.Reasons) != 0 {
+		t.Errorf("expected clean, got %+v", result)
+	}
+
+	// Errors of the task and of the report
+	task := &cuckoo.Task{Errors: []interface{}{"Analysis hit the critical timeout"}}
+	result := policy.Evaluate(task, report)
+	if result.Verdict != Inconclusive || result.Reasons[0].Evidence[0] != "error Analysis hit the critical timeout" {
+		t.Errorf("expected inconclusive, got %+v", result)
+	}
+
+	// The strongest verdict wins
+	report.Info.Score = 5
+	if result := policy.Evaluate(task, report); result.Verdict != Suspicious || len(result.Reasons) != 2 {
+		t.Errorf("expected suspicious, got %+v", result)
+	}
+}
+
+func TestEvaluateAllConditions(t *testing.T) {
+	min := 6.0
+	policy := &Policy{
+		Default: Inconclusive,
+		Rules: []*Rule{
+			{Verdict: Malicious, Score: &ScoreCondition{Min: &min}, Signature: &SignatureCondition{MinSeverity: 3, MinCount: 3}},
+			{Verdict: Suspicious, Score: &ScoreCondition{Min: &min}, Signature: &SignatureCondition{MinSeverity: 3, MinCount: 2}},
+		},
+	}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := policy.Evaluate(nil, testutil.LoadReport(t))
+	if result.Verdict != Suspicious || !reflect.DeepEqual(ruleNames(result), []string{"#1"}) {
+		t.Errorf("expected suspicious from rule #1, got %+v", result)
+	}
+	if len(result.Reasons[0].Evidence) != 3 {
+		t.Errorf("expected the score and 2 signatures as evidence, got %v", result.Reasons[0].Evidence)
+	}
+
+	policy.Rules = policy.Rules[:1]
+	if result := policy.Evaluate(nil, testutil.LoadReport(t)); result.Verdict != Inconclusive {
+		t.Errorf("expected the default verdict, got %s", result.Verdict)
+	}
+}
+
+func TestEvaluateUncompiled(t *testing.T) {
+	// Built in code without calling Compile
+	policy := &Policy{Rules: []*Rule{
+		{Name: "c2", Verdict: Malicious, IOC: &IOCCondition{Types: []ioc.Type{"ip"}, Patterns: []string{`^185\.`}}},
+		{Name: "invalid", Verdict: Malicious, IOC: &IOCCondition{Patterns: []string{"("}}},
+		{Name: "timeout", Verdict: Inconclusive, Errors: &ErrorsCondition{Patterns: []string{"timeout"}}},
+	}}
+
+	task := &cuckoo.Task{Errors: []interface{}{"Machine not available"}}
+	result := policy.Evaluate(task, testutil.LoadReport(t))
+	if !reflect.DeepEqual(ruleNames(result), []string{"c2"}) {
+		t.Fatalf("expected only the c2 rule to match, got %+v", result.Reasons)
+	}
+	if !reflect.DeepEqual(result.Reasons[0].Evidence, []string{"ip 185.100.87.202"}) {
+		t.Errorf("unexpected evidence %v", result.Reasons[0].Evidence)
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for _, policy := range []string{
+		`{"rules": [{"name": "x", "verdict": "bad", "score": {}}]}`,
+		`{"rules": [{"name": "x", "verdict": "clean"}]}`,
+		`{"rules": [{"name": "x", "verdict": "clean", "scor": {}}]}`,
+		`{"rules": [{"name": "x", "verdict": "clean", "signature": {"names": ["["]}}]}`,
+		`{"rules": [{"name": "x", "verdict": "clean", "ioc": {"patterns": ["("]}}]}`,
+		`{"rules": [{"name": "x", "verdict": "clean", "errors": {"patterns": ["("]}}]}`,
+		`{"default": "bad", "rules": []}`,
+	} {
+		if _, err := LoadPolicy(strings.NewReader(policy)); err == nil {
+			t.Errorf("expected an error for %s", policy)
+		}
+	}
+}
+
+func TestEvaluateTask(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testutil.MockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if testutil.ServeMachine(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/tasks/view/42":
+			fmt.Fprint(w, `{"task": {"id": 42, "status": "reported", "errors": []}}`)
+		case "/tasks/report/42/json":
+			_, _ = w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	policy := &Policy{Rules: []*Rule{{Name: "any ip", Verdict: Suspicious, IOC: &IOCCondition{Types: []ioc.Type{"ip"}}}}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := policy.EvaluateTask(context.Background(), c, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The resultserver is not an indicator
+	if result.Verdict != Suspicious || !reflect.DeepEqual(result.Reasons[0].Evidence, []string{"ip 185.100.87.202", "ip 8.8.8.8"}) {
+		t.Errorf("unexpected result %s %+v", result.Verdict, result.Reasons)
+	}
+}
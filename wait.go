@@ -0,0 +1,99 @@
+package cuckoo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultPollInterval    = time.Second * 5
+	defaultMaxPollInterval = time.Minute
+	defaultPollMultiplier  = 2
+)
+
+// statusOrder is the order a task moves through its statuses
+var statusOrder = map[TaskStatus]int{
+	StatusPending:   0,
+	StatusRunning:   1,
+	StatusCompleted: 2,
+	StatusReported:  3,
+}
+
+// TaskFailedError is returned by WaitForTask when the task records errors or ends up in a failed status
+type TaskFailedError struct {
+	Task *Task
+}
+
+func (e *TaskFailedError) Error() string {
+	if len(e.Task.Errors) > 0 {
+		return fmt.Sprintf("task %d failed with status %s: %v", e.Task.ID, e.Task.Status, e.Task.Errors)
+	}
+	return fmt.Sprintf("task %d failed with status %s", e.Task.ID, e.Task.Status)
+}
+
+// WaitOptions configure how WaitForTask polls cuckoo
+type WaitOptions struct {
+	// Time to wait before the first re-check, defaults to 5 seconds
+	PollInterval time.Duration
+	// Upper bound for the time between checks, defaults to 1 minute
+	MaxPollInterval time.Duration
+	// Factor the interval grows by after every check, defaults to 2.  Set to 1 to poll at a fixed interval
+	Multiplier float64
+}
+
+// WaitForTask Polls TasksView until the task reaches (or moves past) the target status and returns the final task.
+//
+// The overall deadline is taken from ctx.  It returns ErrTaskNotFound straight away if the task disappears,
+// and a *TaskFailedError if the task records errors or ends up in one of the failed statuses.
+// opts may be nil to use the defaults.
+func (c *Client) WaitForTask(ctx context.Context, taskID int, target TaskStatus, opts *WaitOptions) (*Task, error) {
+	targetOrder, ok := statusOrder[target]
+	if !ok {
+		return nil, fmt.Errorf("can not wait for status %s", target)
+	}
+
+	interval, maxInterval, multiplier := defaultPollInterval, defaultMaxPollInterval, float64(defaultPollMultiplier)
+	if opts != nil {
+		if opts.PollInterval > 0 {
+			interval = opts.PollInterval
+		}
+		if opts.MaxPollInterval > 0 {
+			maxInterval = opts.MaxPollInterval
+		}
+		if opts.Multiplier >= 1 {
+			multiplier = opts.Multiplier
+		}
+	}
+	if interval > maxInterval {
+		maxInterval = interval
+	}
+
+	for {
+		task, err := c.TasksView(ctx, taskID)
+		if err != nil {
+			return nil, err
+		}
+		if len(task.Errors) > 0 {
+			return task, &TaskFailedError{Task: task}
+		}
+		order, ok := statusOrder[task.Status]
+		if !ok {
+			return task, &TaskFailedError{Task: task}
+		}
+		if order >= targetOrder {
+			return task, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return task, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		interval = time.Duration(float64(interval) * multiplier)
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cuckoo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWaitForTask(t *testing.T) {
+	statuses := []TaskStatus{StatusPending, StatusRunning, StatusCompleted, StatusReported}
+	calls := 0
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[calls]
+		calls++
+		fmt.Fprintf(w, `{"task": {"id": 3, "status": %q}}`, status)
+	})
+
+	task, err := c.WaitForTask(context.Background(), 3, StatusReported, &WaitOptions{PollInterval: time.Millisecond})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if task.Status != StatusReported || calls != 4 {
+		t.Errorf("expected reported after 4 calls, got %s after %d", task.Status, calls)
+	}
+}
+
+func TestWaitForTaskFailures(t *testing.T) {
+	c := getMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tasks/view/1":
+			w.WriteHeader(404)
+		case "/tasks/view/2":
+			w.Write([]byte(`{"task": {"id": 2, "status": "running", "errors": ["machine failed to start"]}}`))
+		case "/tasks/view/3":
+			w.Write([]byte(`{"task": {"id": 3, "status": "failed_analysis"}}`))
+		case "/tasks/view/4":
+			w.Write([]byte(`{"task": {"id": 4, "status": "pending"}}`))
+		}
+	})
+	opts := &WaitOptions{PollInterval: time.Millisecond}
+
+	if _, err := c.WaitForTask(context.Background(), 1, StatusReported, opts); err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	if _, err := c.WaitForTask(context.Background(), 2, StatusReported, opts); err == nil {
+		t.Errorf("expected task errors to fail")
+	} else if _, ok := err.(*TaskFailedError); !ok {
+		t.Errorf("expected *TaskFailedError, got %v", err)
+	}
+	if _, err := c.WaitForTask(context.Background(), 3, StatusReported, opts); err == nil {
+		t.Errorf("expected failed status to fail")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := c.WaitForTask(ctx, 4, StatusReported, opts); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}